@@ -15,3 +15,18 @@ const (
 	// ConsumeNackPersistentFailure "Failure, I give up on this message! Let's move on."
 	ConsumeNackPersistentFailure
 )
+
+func (c ConsumptionCode) String() string {
+	switch c {
+	case ConsumeAck:
+		return "ack"
+	case ConsumeAckFinal:
+		return "ack_final"
+	case ConsumeNackTransientFailure:
+		return "nack_transient"
+	case ConsumeNackPersistentFailure:
+		return "nack_persistent"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,45 @@
+package common
+
+import "github.com/gstones/moke-kit/utility"
+
+// Standard message headers, set through the miface publish options and read back from Message.Headers().
+const (
+	// HeaderContentType is the media type of the message data, e.g. ContentTypeJSON.
+	HeaderContentType = "content-type"
+	// HeaderSchemaVersion is the version of the schema the message data was encoded with.
+	HeaderSchemaVersion = "schema-version"
+	// HeaderPublishedAt is the RFC 3339 time the message was published, set by mq on publish.
+	HeaderPublishedAt = "published-at"
+	// HeaderReplyTo is the topic a request expects its reply on, for the message queues without
+	// request/reply of their own.
+	HeaderReplyTo = "reply-to"
+	// HeaderError is the error a responder failed a request with.
+	HeaderError = "error"
+	// HeaderAttempt is the number of the delivery attempt of a message handled with a retry policy.
+	HeaderAttempt = "attempt"
+	// HeaderDeadLetterTopic is the topic a dead-lettered message failed on.
+	HeaderDeadLetterTopic = "dead-letter-topic"
+	// HeaderDeadLetterReason is why a message was dead-lettered, DeadLetterPersistentFailure or
+	// DeadLetterRetriesExhausted.
+	HeaderDeadLetterReason = "dead-letter-reason"
+	// HeaderContextPrefix prefixes the headers carrying the ContextKeys values of the publishing context.
+	HeaderContextPrefix = "ctx-"
+)
+
+const (
+	// ContentTypeJSON is the content type of the messages published WithJSON.
+	ContentTypeJSON = "application/json"
+	// ContentTypeProtobuf is the content type of protobuf encoded messages.
+	ContentTypeProtobuf = "application/x-protobuf"
+)
+
+const (
+	// DeadLetterPersistentFailure dead-letters a message its handler gave up on.
+	DeadLetterPersistentFailure = "persistent-failure"
+	// DeadLetterRetriesExhausted dead-letters a message still failing after the attempts of its retry policy.
+	DeadLetterRetriesExhausted = "retries-exhausted"
+)
+
+// ContextKeys are the utility context values carried from the context a message is published with
+// to the context of its handler. The bearer token is left out on purpose, append keys at init.
+var ContextKeys = []utility.ContextKey{utility.UIDContextKey}
@@ -0,0 +1,77 @@
+package deadletter
+
+import (
+	"context"
+	"maps"
+	"strconv"
+
+	"github.com/gstones/moke-kit/mq/common"
+	"github.com/gstones/moke-kit/mq/miface"
+)
+
+// Letter is a message dead-lettered by a subscription WithDeadLetterTopic.
+type Letter struct {
+	miface.Message
+	// Topic is the topic the message failed on, Replay publishes it there again.
+	Topic string
+	// Reason is common.DeadLetterPersistentFailure or common.DeadLetterRetriesExhausted.
+	Reason string
+	// Attempts is how many times the message was handled before it was dead-lettered.
+	Attempts int
+}
+
+// FromMessage reads the dead letter msg, it reports false for a message that is not one.
+func FromMessage(msg miface.Message) (Letter, bool) {
+	headers := msg.Headers()
+	topic, ok := headers[common.HeaderDeadLetterTopic]
+	if !ok {
+		return Letter{}, false
+	}
+	attempts, _ := strconv.Atoi(headers[common.HeaderAttempt])
+	return Letter{
+		Message:  msg,
+		Topic:    topic,
+		Reason:   headers[common.HeaderDeadLetterReason],
+		Attempts: attempts,
+	}, true
+}
+
+// Inspect subscribes handler to the dead letters of deadLetterTopic. Dead letters are messages like
+// any other: the ones handler acks are gone, nack them to keep them in the topic.
+func Inspect(
+	ctx context.Context,
+	mq miface.MessageQueue,
+	deadLetterTopic string,
+	handler func(l Letter) common.ConsumptionCode,
+	opts ...miface.SubOption,
+) (miface.Subscription, error) {
+	return mq.Subscribe(ctx, deadLetterTopic, func(msg miface.Message, err error) common.ConsumptionCode {
+		if msg == nil || err != nil {
+			return common.ConsumeNackTransientFailure
+		}
+		l, ok := FromMessage(msg)
+		if !ok {
+			// not a dead letter, there is nothing to replay it to
+			return common.ConsumeNackPersistentFailure
+		}
+		return handler(l)
+	}, opts...)
+}
+
+// Replay publishes l to the topic it failed on again, with its id, data and original headers.
+func Replay(mq miface.MessageQueue, l Letter, opts ...miface.PubOption) error {
+	headers := maps.Clone(l.Headers())
+	for _, h := range []string{
+		common.HeaderDeadLetterTopic,
+		common.HeaderDeadLetterReason,
+		common.HeaderAttempt,
+		common.HeaderPublishedAt,
+	} {
+		delete(headers, h)
+	}
+	return mq.Publish(l.Topic, append([]miface.PubOption{
+		miface.WithBytes(l.Data()),
+		miface.WithMessageId(l.ID()),
+		miface.WithHeaders(headers),
+	}, opts...)...)
+}
@@ -0,0 +1,77 @@
+package deadletter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/gstones/moke-kit/mq/common"
+	"github.com/gstones/moke-kit/mq/internal"
+	"github.com/gstones/moke-kit/mq/internal/local"
+	"github.com/gstones/moke-kit/mq/miface"
+)
+
+func TestInspectAndReplay(t *testing.T) {
+	mq := internal.NewMessageQueue(nil, nil, nil, local.NewMessageQueue(zap.NewNop(), 16, false, false), nil)
+
+	handled := make(chan miface.Message, 8)
+	failing := true
+	sub, err := mq.Subscribe(context.Background(), "local://orders", func(msg miface.Message, _ error) common.ConsumptionCode {
+		handled <- msg
+		if failing {
+			return common.ConsumeNackTransientFailure
+		}
+		return common.ConsumeAck
+	}, miface.WithRetryPolicy(miface.RetryPolicy{MaxAttempts: 2, Backoff: time.Millisecond}),
+		miface.WithDeadLetterTopic("local://orders.dlq"))
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	letters := make(chan Letter, 1)
+	inspect, err := Inspect(context.Background(), mq, "local://orders.dlq", func(l Letter) common.ConsumptionCode {
+		letters <- l
+		return common.ConsumeAck
+	})
+	if err != nil {
+		t.Fatalf("Inspect: %v", err)
+	}
+	defer inspect.Unsubscribe()
+
+	if err := mq.Publish("local://orders", miface.WithBytes([]byte("o-1")), miface.WithHeader("correlation-id", "c-1")); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	var l Letter
+	select {
+	case l = <-letters:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the dead letter")
+	}
+	if l.Topic != "local://orders" || l.Reason != common.DeadLetterRetriesExhausted || l.Attempts != 2 ||
+		string(l.Data()) != "o-1" {
+		t.Fatalf("unexpected letter %+v", l)
+	}
+	for range 2 {
+		<-handled
+	}
+
+	failing = false
+	if err := Replay(mq, l); err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	select {
+	case msg := <-handled:
+		h := msg.Headers()
+		if string(msg.Data()) != "o-1" || msg.ID() != l.ID() || h["correlation-id"] != "c-1" || h[common.HeaderAttempt] != "1" {
+			t.Fatalf("unexpected replayed message %v %v", msg.ID(), h)
+		}
+		if _, ok := h[common.HeaderDeadLetterTopic]; ok {
+			t.Fatalf("replayed message still dead-lettered: %v", h)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the replayed message")
+	}
+}
@@ -1,35 +1,52 @@
 package internal
 
 import (
+	"slices"
 	"strings"
+	"time"
 
 	"golang.org/x/net/context"
 
+	"github.com/gstones/moke-kit/mq/common"
+	"github.com/gstones/moke-kit/mq/middleware"
 	"github.com/gstones/moke-kit/mq/miface"
 
 	"github.com/pkg/errors"
 
 	"github.com/gstones/moke-kit/mq/internal/qerrors"
+	"github.com/gstones/moke-kit/mq/internal/retry"
+	"github.com/gstones/moke-kit/mq/internal/tracing"
 )
 
 type MessageQueue struct {
-	kafkaMQ miface.MessageQueue
-	natsMQ  miface.MessageQueue
-	nsqMQ   miface.MessageQueue
-	localMQ miface.MessageQueue
+	kafkaMQ   miface.MessageQueue
+	natsMQ    miface.MessageQueue
+	nsqMQ     miface.MessageQueue
+	localMQ   miface.MessageQueue
+	scheduler miface.Scheduler
+
+	middlewares []miface.Middleware
 }
 
+// NewMessageQueue routes topics to the message queue of their scheme. Delayed messages go to the
+// delayed delivery of the message queue when it has one, NSQ, and to scheduler otherwise; without
+// a scheduler the other message queues reject them.
+// Subscription and responder handlers are wrapped in middlewares, the first one outermost.
 func NewMessageQueue(
 	kafkaMQ miface.MessageQueue,
 	natsMQ miface.MessageQueue,
 	nsqMQ miface.MessageQueue,
 	localMQ miface.MessageQueue,
+	scheduler miface.Scheduler,
+	middlewares ...miface.Middleware,
 ) *MessageQueue {
 	return &MessageQueue{
-		kafkaMQ: kafkaMQ,
-		natsMQ:  natsMQ,
-		nsqMQ:   nsqMQ,
-		localMQ: localMQ,
+		kafkaMQ:     kafkaMQ,
+		natsMQ:      natsMQ,
+		nsqMQ:       nsqMQ,
+		localMQ:     localMQ,
+		scheduler:   scheduler,
+		middlewares: middlewares,
 	}
 }
 
@@ -41,7 +58,11 @@ func (m *MessageQueue) Subscribe(
 ) (miface.Subscription, error) {
 	if mqType, t, err := parseTopic(topic); err != nil {
 		return nil, err
+	} else if options, err := miface.NewSubOptions(opts...); err != nil {
+		return nil, err
 	} else {
+		handler = middleware.Chain(handler, m.middlewares...)
+		handler = retry.Handler(m.Publish, topic, options, tracing.Handler(systems[mqType], t, handler))
 		switch mqType {
 		case kafka:
 			if m.kafkaMQ == nil {
@@ -93,39 +114,145 @@ func (m *MessageQueue) Subscribe(
 func (m *MessageQueue) Publish(topic string, opts ...miface.PubOption) error {
 	if mqType, t, err := parseTopic(topic); err != nil {
 		return err
+	} else if options, err := miface.NewPubOptions(opts...); err != nil {
+		return err
+	} else if delayed, err := m.schedule(mqType, topic, options, opts); delayed || err != nil {
+		return err
+	} else if options.Context == nil {
+		return m.publish(mqType, t, opts)
 	} else {
-		switch mqType {
-		case kafka:
-			if m.kafkaMQ == nil {
-				return qerrors.ErrNoKafkaQueue
-			}
+		ctx, span := tracing.StartPublish(options.Context, systems[mqType], t)
+		headers := map[string]string{}
+		tracing.Inject(ctx, headers)
+		err := m.publish(mqType, t, append(slices.Clip(opts), miface.WithHeaders(headers)))
+		tracing.End(span, err)
+		return err
+	}
+}
 
-			return m.kafkaMQ.Publish(t, opts...)
+func (m *MessageQueue) publish(mqType mqType, topic string, opts []miface.PubOption) error {
+	if mq, err := m.route(mqType); err != nil {
+		return err
+	} else {
+		return mq.Publish(topic, opts...)
+	}
+}
 
-		case nats:
-			if m.natsMQ == nil {
-				return qerrors.ErrNoNatsQueue
-			}
+func (m *MessageQueue) Request(ctx context.Context, topic string, opts ...miface.PubOption) (miface.Message, error) {
+	mqType, t, err := parseTopic(topic)
+	if err != nil {
+		return nil, err
+	}
+	mq, err := m.route(mqType)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, miface.DefaultRequestTimeout)
+		defer cancel()
+	}
 
-			return m.natsMQ.Publish(t, opts...)
-		case nsq:
-			if m.nsqMQ == nil {
-				return qerrors.ErrNoNsqQueue
-			}
-			return m.nsqMQ.Publish(t, opts...)
-		case local:
-			if m.localMQ == nil {
-				return qerrors.ErrNoLocalQueue
-			}
+	spanCtx, span := tracing.StartPublish(ctx, systems[mqType], t)
+	headers := map[string]string{}
+	tracing.Inject(spanCtx, headers)
+	reply, err := mq.Request(ctx, t, append(slices.Clip(opts), miface.WithHeaders(headers))...)
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = qerrors.ErrRequestTimeout
+	} else if err == nil && reply.Headers()[common.HeaderError] != "" {
+		reply, err = nil, errors.Wrap(qerrors.ErrRequestFailure, reply.Headers()[common.HeaderError])
+	}
+	tracing.End(span, err)
+	return reply, err
+}
 
-			return m.localMQ.Publish(t, opts...)
+func (m *MessageQueue) Respond(
+	ctx context.Context,
+	topic string,
+	handler miface.RequestHandler,
+	opts ...miface.SubOption,
+) (miface.Subscription, error) {
+	mqType, t, err := parseTopic(topic)
+	if err != nil {
+		return nil, err
+	}
+	mq, err := m.route(mqType)
+	if err != nil {
+		return nil, err
+	}
+	handler = tracing.Responder(systems[mqType], t, m.responder(handler))
+	if sub, err := mq.Respond(ctx, t, handler, opts...); err != nil {
+		return nil, errors.Wrap(err, qerrors.ErrSubscriptionFailure.Error())
+	} else {
+		return sub, nil
+	}
+}
 
-		default:
-			return qerrors.ErrMQTypeUnsupported
+// responder wraps handler in the middlewares, it is handled like a message nacked as a persistent
+// failure when it fails, and fails with ErrRequestUnhandled when the middlewares stop it.
+func (m *MessageQueue) responder(handler miface.RequestHandler) miface.RequestHandler {
+	if len(m.middlewares) == 0 {
+		return handler
+	}
+	return func(msg miface.Message) ([]byte, error) {
+		reply, err := []byte(nil), qerrors.ErrRequestUnhandled
+		middleware.Chain(func(msg miface.Message, _ error) common.ConsumptionCode {
+			if reply, err = handler(msg); err != nil {
+				return common.ConsumeNackPersistentFailure
+			}
+			return common.ConsumeAck
+		}, m.middlewares...)(msg, nil)
+		return reply, err
+	}
+}
+
+// route returns the message queue of mqType.
+func (m *MessageQueue) route(mqType mqType) (miface.MessageQueue, error) {
+	switch mqType {
+	case kafka:
+		if m.kafkaMQ == nil {
+			return nil, qerrors.ErrNoKafkaQueue
+		}
+		return m.kafkaMQ, nil
+	case nats:
+		if m.natsMQ == nil {
+			return nil, qerrors.ErrNoNatsQueue
 		}
+		return m.natsMQ, nil
+	case nsq:
+		if m.nsqMQ == nil {
+			return nil, qerrors.ErrNoNsqQueue
+		}
+		return m.nsqMQ, nil
+	case local:
+		if m.localMQ == nil {
+			return nil, qerrors.ErrNoLocalQueue
+		}
+		return m.localMQ, nil
+	default:
+		return nil, qerrors.ErrMQTypeUnsupported
 	}
 }
 
+// schedule hands a delayed message for a message queue without delayed delivery to the scheduler,
+// and reports whether it did.
+func (m *MessageQueue) schedule(mqType mqType, topic string, options miface.PubOptions, opts []miface.PubOption) (bool, error) {
+	if m.scheduler == nil || nativeDelay[mqType] {
+		return false, nil
+	}
+	now := time.Now()
+	delay := options.DelayFrom(now)
+	if delay <= 0 {
+		return false, nil
+	}
+	ctx := options.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	_, err := m.scheduler.Schedule(ctx, topic, now.Add(delay), opts...)
+	return true, err
+}
+
 // topic string should follow the syntax of:
 // kafka://topic-name
 // nats://some-other-topic
@@ -158,3 +285,16 @@ const (
 	local
 	unknown
 )
+
+// nativeDelay tells the message queues delaying messages themselves.
+var nativeDelay = map[mqType]bool{
+	nsq: true,
+}
+
+// systems names the message queues in traces.
+var systems = map[mqType]string{
+	kafka: "kafka",
+	nats:  "nats",
+	nsq:   "nsq",
+	local: "local",
+}
@@ -0,0 +1,208 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/gstones/moke-kit/mq/common"
+	localmq "github.com/gstones/moke-kit/mq/internal/local"
+	"github.com/gstones/moke-kit/mq/internal/qerrors"
+	"github.com/gstones/moke-kit/mq/middleware"
+	"github.com/gstones/moke-kit/mq/miface"
+	"github.com/gstones/moke-kit/utility"
+)
+
+func TestRequestRoutesAndMapsErrors(t *testing.T) {
+	mq := NewMessageQueue(nil, nil, nil, localmq.NewMessageQueue(zap.NewNop(), 16, false, false), nil)
+
+	if _, err := mq.Request(context.Background(), "nats://price"); !errors.Is(err, qerrors.ErrNoNatsQueue) {
+		t.Fatalf("expected no nats queue, got %v", err)
+	}
+	sub, err := mq.Respond(context.Background(), "local://price", func(msg miface.Message) ([]byte, error) {
+		uid, _ := utility.FromContext(msg.Context(), utility.UIDContextKey)
+		if uid == "" {
+			return nil, errors.New("anonymous")
+		}
+		return []byte(uid), nil
+	})
+	if err != nil {
+		t.Fatalf("Respond: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	if _, err := mq.Request(context.Background(), "local://price"); !errors.Is(err, qerrors.ErrRequestFailure) {
+		t.Fatalf("expected request failure, got %v", err)
+	}
+	ctx := utility.NewContext(context.Background(), utility.UIDContextKey, "10001")
+	if reply, err := mq.Request(ctx, "local://price"); err != nil || string(reply.Data()) != "10001" {
+		t.Fatalf("Request: %v, %v", reply, err)
+	}
+}
+
+func TestSubscribeAppliesMiddlewares(t *testing.T) {
+	var wrapped []string
+	mark := func(name string) miface.Middleware {
+		return func(next miface.SubResponseHandler) miface.SubResponseHandler {
+			return func(msg miface.Message, err error) common.ConsumptionCode {
+				wrapped = append(wrapped, name)
+				return next(msg, err)
+			}
+		}
+	}
+	var codes []common.ConsumptionCode
+	observe := func(next miface.SubResponseHandler) miface.SubResponseHandler {
+		return func(msg miface.Message, err error) common.ConsumptionCode {
+			code := next(msg, err)
+			codes = append(codes, code)
+			return code
+		}
+	}
+	mq := NewMessageQueue(nil, nil, nil, localmq.NewMessageQueue(zap.NewNop(), 16, false, true), nil,
+		observe, middleware.Recovery(zap.NewNop()), mark("outer"), mark("inner"))
+
+	handled := make(chan string, 2)
+	sub, err := mq.Subscribe(context.Background(), "local://mw", func(msg miface.Message, err error) common.ConsumptionCode {
+		if string(msg.Data()) == "panic" {
+			panic("boom")
+		}
+		handled <- string(msg.Data())
+		return common.ConsumeAck
+	})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	for _, data := range []string{"panic", "ok"} {
+		if err := mq.Publish("local://mw", miface.WithBytes([]byte(data))); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+	select {
+	case data := <-handled:
+		if data != "ok" {
+			t.Fatalf("handled %q", data)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("subscription did not survive the panic")
+	}
+	if len(wrapped) != 4 || wrapped[0] != "outer" || wrapped[1] != "inner" {
+		t.Fatalf("middlewares ran as %v", wrapped)
+	}
+	// the middlewares outside the recovery see the panic as a nack
+	if len(codes) != 2 || codes[0] != common.ConsumeNackPersistentFailure || codes[1] != common.ConsumeAck {
+		t.Fatalf("middlewares saw %v", codes)
+	}
+}
+
+func TestRespondAppliesMiddlewares(t *testing.T) {
+	var handled int
+	count := func(next miface.SubResponseHandler) miface.SubResponseHandler {
+		return func(msg miface.Message, err error) common.ConsumptionCode {
+			handled++
+			return next(msg, err)
+		}
+	}
+	mq := NewMessageQueue(nil, nil, nil, localmq.NewMessageQueue(zap.NewNop(), 16, false, false), nil,
+		count, middleware.Recovery(zap.NewNop()))
+
+	sub, err := mq.Respond(context.Background(), "local://quote", func(msg miface.Message) ([]byte, error) {
+		if string(msg.Data()) == "panic" {
+			panic("boom")
+		}
+		return []byte("42"), nil
+	})
+	if err != nil {
+		t.Fatalf("Respond: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	_, err = mq.Request(context.Background(), "local://quote", miface.WithBytes([]byte("panic")))
+	if !errors.Is(err, qerrors.ErrRequestFailure) || !strings.Contains(err.Error(), qerrors.ErrRequestUnhandled.Error()) {
+		t.Fatalf("expected an unhandled request failure, got %v", err)
+	}
+	if reply, err := mq.Request(context.Background(), "local://quote"); err != nil || string(reply.Data()) != "42" {
+		t.Fatalf("the responder did not survive the panic: %v, %v", reply, err)
+	}
+	if handled != 2 {
+		t.Fatalf("middlewares handled %d requests", handled)
+	}
+}
+
+func TestUnsubscribeEndsHandlerContext(t *testing.T) {
+	mq := NewMessageQueue(nil, nil, nil, localmq.NewMessageQueue(zap.NewNop(), 16, false, false), nil)
+
+	handling := make(chan struct{})
+	sub, err := mq.Subscribe(context.Background(), "local://drain", func(msg miface.Message, err error) common.ConsumptionCode {
+		close(handling)
+		<-msg.Context().Done()
+		return common.ConsumeAck
+	})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	if err := mq.Publish("local://drain", miface.WithBytes([]byte("x"))); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	<-handling
+
+	_ = sub.Unsubscribe()
+	select {
+	case <-sub.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler context not ended by Unsubscribe")
+	}
+}
+
+type recordingScheduler struct {
+	topics []string
+}
+
+func (s *recordingScheduler) Schedule(_ context.Context, topic string, _ time.Time, _ ...miface.PubOption) (string, error) {
+	s.topics = append(s.topics, topic)
+	return "id", nil
+}
+
+func (s *recordingScheduler) Cancel(context.Context, string) error {
+	return nil
+}
+
+type delayingMQ struct {
+	miface.MessageQueue
+	delays []time.Duration
+}
+
+func (q *delayingMQ) Publish(_ string, opts ...miface.PubOption) error {
+	o, err := miface.NewPubOptions(opts...)
+	q.delays = append(q.delays, o.Delay)
+	return err
+}
+
+func TestDelayedPublishRoutesByQueue(t *testing.T) {
+	var sch recordingScheduler
+	nsqMQ := &delayingMQ{}
+	mq := NewMessageQueue(nil, nil, nsqMQ, localmq.NewMessageQueue(zap.NewNop(), 16, false, false), &sch)
+
+	if err := mq.Publish("nsq://refills", miface.WithDelay(time.Minute)); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if err := mq.Publish("local://auctions", miface.WithDelay(time.Minute)); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if len(nsqMQ.delays) != 1 || nsqMQ.delays[0] != time.Minute {
+		t.Fatalf("nsq did not delay natively: %v", nsqMQ.delays)
+	}
+	if len(sch.topics) != 1 || sch.topics[0] != "local://auctions" {
+		t.Fatalf("unexpected scheduled topics %v", sch.topics)
+	}
+
+	mq = NewMessageQueue(nil, nil, nil, localmq.NewMessageQueue(zap.NewNop(), 16, false, false), nil)
+	if err := mq.Publish("local://auctions", miface.WithDelay(time.Minute)); !errors.Is(err, qerrors.ErrDelayedPublishUnsupported) {
+		t.Fatalf("expected unsupported delay without a scheduler, got %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+package kafka
+
+import (
+	"crypto/tls"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+
+	"github.com/gstones/moke-kit/mq/internal/qerrors"
+)
+
+const (
+	defaultClientID = "moke-kit"
+	dialTimeout     = 10 * time.Second
+	// batchTimeout bounds how long a synchronous publish waits for more messages to batch with.
+	batchTimeout = 5 * time.Millisecond
+	// messageIDHeader carries the message id, kafka records have none of their own.
+	messageIDHeader = "message_id"
+)
+
+// config is the connection part of a kafka url:
+//
+//	kafka://[user:password@]broker1:9092,broker2:9092[?tls=true&skip_verify=true&sasl=scram-sha-512&client_id=svc]
+//
+// sasl is plain, scram-sha-256 or scram-sha-512, plain by default when user info is set.
+type config struct {
+	brokers  []string
+	clientID string
+	tls      *tls.Config
+	sasl     sasl.Mechanism
+}
+
+func parseURL(address string) (*config, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "kafka" || u.Host == "" {
+		return nil, qerrors.ErrInvalidKafkaURL
+	}
+	c := &config{clientID: defaultClientID}
+	for _, b := range strings.Split(u.Host, ",") {
+		if b = strings.TrimSpace(b); b != "" {
+			c.brokers = append(c.brokers, b)
+		}
+	}
+
+	q := u.Query()
+	if id := q.Get("client_id"); id != "" {
+		c.clientID = id
+	}
+	if v := q.Get("tls"); v != "" {
+		on, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, qerrors.ErrInvalidKafkaURL
+		}
+		if on {
+			c.tls = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+	}
+	if v := q.Get("skip_verify"); v != "" {
+		skip, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, qerrors.ErrInvalidKafkaURL
+		}
+		if c.tls == nil {
+			c.tls = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		c.tls.InsecureSkipVerify = skip
+	}
+
+	if u.User != nil {
+		user := u.User.Username()
+		password, _ := u.User.Password()
+		switch mechanism := strings.ToLower(q.Get("sasl")); mechanism {
+		case "", "plain":
+			c.sasl = plain.Mechanism{Username: user, Password: password}
+		case "scram-sha-256", "scram-sha-512":
+			algo := scram.SHA256
+			if mechanism == "scram-sha-512" {
+				algo = scram.SHA512
+			}
+			if c.sasl, err = scram.Mechanism(algo, user, password); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, qerrors.ErrInvalidKafkaURL
+		}
+	}
+	return c, nil
+}
+
+func (c *config) dialer() *kafka.Dialer {
+	return &kafka.Dialer{
+		ClientID:      c.clientID,
+		Timeout:       dialTimeout,
+		DualStack:     true,
+		TLS:           c.tls,
+		SASLMechanism: c.sasl,
+	}
+}
+
+func (c *config) transport() *kafka.Transport {
+	return &kafka.Transport{
+		ClientID:    c.clientID,
+		DialTimeout: dialTimeout,
+		TLS:         c.tls,
+		SASL:        c.sasl,
+	}
+}
@@ -0,0 +1,294 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+
+	"github.com/gstones/moke-kit/mq/common"
+	message2 "github.com/gstones/moke-kit/mq/internal/message"
+	"github.com/gstones/moke-kit/mq/internal/pool"
+	"github.com/gstones/moke-kit/mq/internal/qerrors"
+	"github.com/gstones/moke-kit/mq/internal/subscription"
+	"github.com/gstones/moke-kit/mq/miface"
+)
+
+const (
+	// retryDelay and maxRetryDelay bound the backoff between redeliveries of a nacked message.
+	retryDelay    = 10 * time.Millisecond
+	maxRetryDelay = 5 * time.Second
+)
+
+// reader is the part of *kafka.Reader a subscription uses.
+type reader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	ReadMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	SetOffset(offset int64) error
+	Close() error
+}
+
+// writer is the part of *kafka.Writer publishing uses.
+type writer interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// MessageQueue publishes and consumes kafka topics. Subscriptions with a group id join that
+// consumer group, so the replicas sharing it split the partitions between them; without one
+// every subscription reads all the partitions of the topic from their end, without a group, so
+// it receives every new message. Such a topic must exist when subscribing.
+//
+// At-least-once subscriptions, the default, commit a message after its handler acked it and
+// redeliver nacked messages in place with backoff, which holds back the rest of the partition.
+// At-most-once subscriptions commit a message before handling it.
+type MessageQueue struct {
+	logger    *zap.Logger
+	brokers   []string
+	dialer    *kafka.Dialer
+	writer    writer
+	newReader func(cfg kafka.ReaderConfig) reader
+	// partitions looks up the partitions a subscription without a group reads
+	partitions func(ctx context.Context, topic string) ([]int, error)
+
+	// closed ends the subscriptions on Close, subs waits for them
+	closed context.Context
+	close  context.CancelFunc
+	subs   sync.WaitGroup
+}
+
+// NewMessageQueue creates a kafka message queue for a kafka:// url, see parseURL.
+func NewMessageQueue(logger *zap.Logger, address string) (*MessageQueue, error) {
+	c, err := parseURL(address)
+	if err != nil {
+		return nil, err
+	}
+	closed, closeFunc := context.WithCancel(context.Background())
+	m := &MessageQueue{
+		logger:  logger,
+		brokers: c.brokers,
+		dialer:  c.dialer(),
+		writer: &kafka.Writer{
+			Addr:                   kafka.TCP(c.brokers...),
+			Balancer:               &kafka.Hash{},
+			RequiredAcks:           kafka.RequireAll,
+			BatchTimeout:           batchTimeout,
+			AllowAutoTopicCreation: true,
+			Transport:              c.transport(),
+			ErrorLogger:            kafka.LoggerFunc(logger.Sugar().Errorf),
+		},
+		newReader: func(cfg kafka.ReaderConfig) reader {
+			return kafka.NewReader(cfg)
+		},
+		closed: closed,
+		close:  closeFunc,
+	}
+	m.partitions = m.lookupPartitions
+	return m, nil
+}
+
+func (m *MessageQueue) Subscribe(
+	ctx context.Context,
+	topic string,
+	handler miface.SubResponseHandler,
+	opts ...miface.SubOption,
+) (miface.Subscription, error) {
+	if topic == "" {
+		return nil, qerrors.ErrEmptyTopic
+	}
+	topic = common.NamespaceTopic(topic)
+	options, err := miface.NewSubOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := kafka.ReaderConfig{
+		Brokers:     m.brokers,
+		Topic:       topic,
+		GroupID:     options.GroupId,
+		Dialer:      m.dialer,
+		StartOffset: kafka.FirstOffset,
+		ErrorLogger: kafka.LoggerFunc(m.logger.Sugar().Errorf),
+	}
+	var r reader
+	if cfg.GroupID != "" {
+		r = m.newReader(cfg)
+	} else {
+		partitions, err := m.partitions(ctx, topic)
+		if err != nil {
+			return nil, err
+		}
+		readers := make([]reader, 0, len(partitions))
+		for _, partition := range partitions {
+			pc := cfg
+			pc.Partition = partition
+			r := m.newReader(pc)
+			// readers without a group start at the first offset whatever StartOffset says
+			if err := r.SetOffset(kafka.LastOffset); err != nil {
+				for _, r := range append(readers, r) {
+					_ = r.Close()
+				}
+				return nil, err
+			}
+			readers = append(readers, r)
+		}
+		r = newPartitionReader(readers)
+	}
+
+	subCtx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(m.closed, cancel)
+	sub := subscription.NewCancelSubscription(cancel)
+	atMostOnce := options.DeliverySemantics == common.AtMostOnce
+	m.subs.Add(1)
+	go func() {
+		p := pool.ForSubscription(options)
+		defer func() {
+			p.Close()
+			sub.Ended(ctx)
+			if err := r.Close(); err != nil {
+				m.logger.Warn("kafka reader close failure", zap.String("topic", topic), zap.Error(err))
+			}
+			stop()
+			m.subs.Done()
+		}()
+		// fail ends the subscription for a reader error, which the handler is told about
+		fail := func(what string, err error) {
+			if subCtx.Err() == nil {
+				m.logger.Error(what, zap.String("topic", topic), zap.Error(err))
+				handler(nil, err)
+				sub.Close(err)
+			}
+		}
+		// handlers in flight when the subscription ends still get their messages committed
+		commitCtx := context.WithoutCancel(subCtx)
+		fetched := newOffsets()
+		for sub.IsValid() {
+			var msg kafka.Message
+			var err error
+			if atMostOnce {
+				msg, err = r.ReadMessage(subCtx)
+			} else {
+				msg, err = r.FetchMessage(subCtx)
+			}
+			if err != nil {
+				fail("kafka fetch failure", err)
+				return
+			}
+			f := fetched.fetched(msg)
+			ms := message2.WithContext(toMessage(topic, msg), subCtx)
+			p.Submit(options.Key(ms), func() {
+				code, ok := m.handle(subCtx, ms, handler)
+				if !ok {
+					return
+				}
+				if last, ok := fetched.handled(f); ok && !atMostOnce {
+					if err := r.CommitMessages(commitCtx, last); err != nil {
+						fail("kafka commit failure", err)
+						return
+					}
+				}
+				if code == common.ConsumeAckFinal {
+					_ = sub.Unsubscribe()
+				}
+			})
+		}
+	}()
+	return sub, nil
+}
+
+// handle runs handler until it stops asking for a redelivery and returns its last code, it reports
+// false if ctx ended first.
+func (m *MessageQueue) handle(
+	ctx context.Context,
+	ms miface.Message,
+	handler miface.SubResponseHandler,
+) (common.ConsumptionCode, bool) {
+	delay := retryDelay
+	for {
+		code := handler(ms, nil)
+		if code != common.ConsumeNackTransientFailure {
+			return code, true
+		}
+		select {
+		case <-ctx.Done():
+			return code, false
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
+
+// toMessage converts a kafka record, records published without an id or a published-at header
+// get their position and their own timestamp instead.
+func toMessage(topic string, msg kafka.Message) miface.Message {
+	id := fmt.Sprintf("%s-%d-%d", msg.Topic, msg.Partition, msg.Offset)
+	headers := make(map[string]string, len(msg.Headers))
+	for _, h := range msg.Headers {
+		if h.Key == messageIDHeader {
+			id = string(h.Value)
+		} else {
+			headers[h.Key] = string(h.Value)
+		}
+	}
+	publishedAt := message2.PublishedAt(headers)
+	if publishedAt.IsZero() {
+		publishedAt = msg.Time
+	}
+	return message2.NewMessage(id, topic, msg.Value, nil, headers, publishedAt)
+}
+
+func (m *MessageQueue) Publish(topic string, pOpts ...miface.PubOption) error {
+	if topic == "" {
+		return qerrors.ErrEmptyTopic
+	}
+	topic = common.NamespaceTopic(topic)
+
+	if options, err := miface.NewPubOptions(pOpts...); err != nil {
+		return err
+	} else if options.DelayFrom(time.Now()) > 0 {
+		return qerrors.ErrDelayedPublishUnsupported
+	} else {
+		id := options.MessageId
+		if id == "" {
+			id = watermill.NewUUID()
+		}
+		msg := kafka.Message{
+			Topic: topic,
+			Value: options.Data,
+			Headers: []kafka.Header{
+				{Key: messageIDHeader, Value: []byte(id)},
+			},
+		}
+		for k, v := range message2.Headers(options.Headers, time.Now()) {
+			msg.Headers = append(msg.Headers, kafka.Header{Key: k, Value: []byte(v)})
+		}
+		if options.Key != "" {
+			msg.Key = []byte(options.Key)
+		}
+		return m.writer.WriteMessages(context.Background(), msg)
+	}
+}
+
+// Close ends the subscriptions, waiting for their handlers in flight and their readers, and closes
+// the publisher once its buffered messages are written.
+func (m *MessageQueue) Close() error {
+	m.close()
+	m.subs.Wait()
+	return m.writer.Close()
+}
+
+// Request is not supported, there is no way to address a reply to the requester.
+func (m *MessageQueue) Request(context.Context, string, ...miface.PubOption) (miface.Message, error) {
+	return nil, qerrors.ErrRequestUnsupported
+}
+
+func (m *MessageQueue) Respond(context.Context, string, miface.RequestHandler, ...miface.SubOption) (miface.Subscription, error) {
+	return nil, qerrors.ErrRequestUnsupported
+}
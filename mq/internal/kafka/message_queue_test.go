@@ -0,0 +1,369 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+
+	"github.com/gstones/moke-kit/mq/common"
+	"github.com/gstones/moke-kit/mq/internal/qerrors"
+	"github.com/gstones/moke-kit/mq/miface"
+)
+
+// fakeBroker stands in for the readers and the writer of a single partition topic.
+type fakeBroker struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	log       []kafka.Message
+	committed map[string]int64 // group -> next offset
+	configs   []kafka.ReaderConfig
+	fetchErr  error
+}
+
+func newFakeBroker() *fakeBroker {
+	b := &fakeBroker{committed: map[string]int64{}}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+func (b *fakeBroker) WriteMessages(_ context.Context, msgs ...kafka.Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, m := range msgs {
+		m.Offset = int64(len(b.log))
+		b.log = append(b.log, m)
+	}
+	b.cond.Broadcast()
+	return nil
+}
+
+func (b *fakeBroker) Close() error { return nil }
+
+func (b *fakeBroker) Committed(group string) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.committed[group]
+}
+
+type fakeReader struct {
+	b     *fakeBroker
+	group string
+	next  int64
+}
+
+func (b *fakeBroker) newReader(cfg kafka.ReaderConfig) reader {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.configs = append(b.configs, cfg)
+	return &fakeReader{b: b, group: cfg.GroupID, next: b.committed[cfg.GroupID]}
+}
+
+func (r *fakeReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	stop := context.AfterFunc(ctx, func() {
+		r.b.mu.Lock()
+		r.b.cond.Broadcast()
+		r.b.mu.Unlock()
+	})
+	defer stop()
+	r.b.mu.Lock()
+	defer r.b.mu.Unlock()
+	for {
+		if err := ctx.Err(); err != nil {
+			return kafka.Message{}, err
+		}
+		if r.b.fetchErr != nil {
+			return kafka.Message{}, r.b.fetchErr
+		}
+		if int64(len(r.b.log)) > r.next {
+			break
+		}
+		r.b.cond.Wait()
+	}
+	m := r.b.log[r.next]
+	r.next++
+	return m, nil
+}
+
+func (r *fakeReader) ReadMessage(ctx context.Context) (kafka.Message, error) {
+	m, err := r.FetchMessage(ctx)
+	if err != nil {
+		return m, err
+	}
+	return m, r.CommitMessages(ctx, m)
+}
+
+func (r *fakeReader) CommitMessages(_ context.Context, msgs ...kafka.Message) error {
+	r.b.mu.Lock()
+	defer r.b.mu.Unlock()
+	for _, m := range msgs {
+		r.b.committed[r.group] = m.Offset + 1
+	}
+	return nil
+}
+
+func (r *fakeReader) SetOffset(offset int64) error {
+	r.b.mu.Lock()
+	defer r.b.mu.Unlock()
+	if offset == kafka.LastOffset {
+		offset = int64(len(r.b.log))
+	}
+	r.next = offset
+	return nil
+}
+
+func (r *fakeReader) Close() error { return nil }
+
+func newTestQueue(b *fakeBroker) *MessageQueue {
+	closed, closeFunc := context.WithCancel(context.Background())
+	return &MessageQueue{
+		logger:    zap.NewNop(),
+		writer:    b,
+		newReader: b.newReader,
+		partitions: func(context.Context, string) ([]int, error) {
+			return []int{0}, nil
+		},
+		closed: closed,
+		close:  closeFunc,
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func TestParseURL(t *testing.T) {
+	c, err := parseURL("kafka://u:p@b1:9092,b2:9093?tls=true&sasl=scram-sha-512&client_id=svc")
+	if err != nil {
+		t.Fatalf("parseURL: %v", err)
+	}
+	if len(c.brokers) != 2 || c.brokers[0] != "b1:9092" || c.brokers[1] != "b2:9093" {
+		t.Fatalf("brokers: %v", c.brokers)
+	}
+	if c.clientID != "svc" || c.tls == nil || c.sasl == nil || c.sasl.Name() != "SCRAM-SHA-512" {
+		t.Fatalf("unexpected config: %+v", c)
+	}
+
+	for _, raw := range []string{
+		"nats://localhost:4222",
+		"kafka://",
+		"kafka://b1:9092?tls=maybe",
+		"kafka://u:p@b1:9092?sasl=gssapi",
+	} {
+		if _, err := parseURL(raw); err == nil {
+			t.Fatalf("expected error for %q", raw)
+		}
+	}
+}
+
+func TestPublishSetsKeyAndID(t *testing.T) {
+	b := newFakeBroker()
+	mq := newTestQueue(b)
+	if err := mq.Publish("orders", miface.WithBytes([]byte("x")), miface.WithPartitionKey("user-1")); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if err := mq.Publish("orders", miface.WithDelay(time.Second)); err == nil {
+		t.Fatal("expected delayed publish to be rejected")
+	}
+	if len(b.log) != 1 || string(b.log[0].Key) != "user-1" || toMessage("t", b.log[0]).ID() == "" {
+		t.Fatalf("unexpected log: %+v", b.log)
+	}
+}
+
+func TestPublishCarriesHeaders(t *testing.T) {
+	b := newFakeBroker()
+	mq := newTestQueue(b)
+	before := time.Now()
+	if err := mq.Publish("orders", miface.WithJSON(map[string]int{"n": 1}), miface.WithSchemaVersion("2"),
+		miface.WithMessageId("order-1")); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if len(b.log) != 1 {
+		t.Fatalf("unexpected log: %+v", b.log)
+	}
+	msg := toMessage("orders", b.log[0])
+	h := msg.Headers()
+	if msg.ID() != "order-1" || h[common.HeaderContentType] != common.ContentTypeJSON ||
+		h[common.HeaderSchemaVersion] != "2" || msg.Timestamp().Before(before.Truncate(time.Millisecond)) {
+		t.Fatalf("unexpected message: %s %v %v", msg.ID(), h, msg.Timestamp())
+	}
+}
+
+func TestSubscribeRedeliversNack(t *testing.T) {
+	b := newFakeBroker()
+	mq := newTestQueue(b)
+
+	var mu sync.Mutex
+	var got []string
+	attempts := 0
+	sub, err := mq.Subscribe(context.Background(), "orders", func(msg miface.Message, err error) common.ConsumptionCode {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if attempts == 1 {
+			return common.ConsumeNackTransientFailure
+		}
+		got = append(got, string(msg.Data()))
+		return common.ConsumeAck
+	}, miface.WithAtLeastOnceDelivery())
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	if b.configs[0].GroupID != "" || b.configs[0].Partition != 0 {
+		t.Fatalf("expected a partition reader without group: %+v", b.configs[0])
+	}
+	for _, v := range []string{"a", "b"} {
+		if err := mq.Publish("orders", miface.WithBytes([]byte(v))); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+	waitFor(t, "both messages handled", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(got) == 2
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != 2 || got[0] != "a" || got[1] != "b" || attempts != 3 {
+		t.Fatalf("got %v after %d attempts", got, attempts)
+	}
+}
+
+func TestSubscribeGroupsShareOffsets(t *testing.T) {
+	b := newFakeBroker()
+	mq := newTestQueue(b)
+	handler := func(miface.Message, error) common.ConsumptionCode { return common.ConsumeAck }
+
+	sub, err := mq.Subscribe(context.Background(), "orders", handler, miface.WithAtMostOnceDelivery("billing"))
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	if b.configs[0].GroupID != "billing" || b.configs[0].StartOffset != kafka.FirstOffset {
+		t.Fatalf("expected the billing group: %+v", b.configs[0])
+	}
+	if err := mq.Publish("orders", miface.WithBytes([]byte("a"))); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	waitFor(t, "message committed", func() bool { return b.Committed("billing") == 1 })
+	if err := sub.Unsubscribe(); err != nil {
+		t.Fatalf("Unsubscribe: %v", err)
+	}
+	if sub.IsValid() {
+		t.Fatal("expected subscription invalid after unsubscribe")
+	}
+
+	// a replica joining the group resumes after the committed offset
+	var got []string
+	var mu sync.Mutex
+	sub, err = mq.Subscribe(context.Background(), "orders", func(msg miface.Message, _ error) common.ConsumptionCode {
+		mu.Lock()
+		got = append(got, string(msg.Data()))
+		mu.Unlock()
+		return common.ConsumeAck
+	}, miface.WithAtMostOnceDelivery("billing"))
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer sub.Unsubscribe()
+	if err := mq.Publish("orders", miface.WithBytes([]byte("b"))); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	waitFor(t, "second message", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(got) > 0
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != 1 || got[0] != "b" || b.Committed("billing") != 2 {
+		t.Fatalf("got %v", got)
+	}
+}
+
+func TestAckFinalAndFetchFailureEndSubscriptions(t *testing.T) {
+	b := newFakeBroker()
+	mq := newTestQueue(b)
+
+	var handled int
+	sub, err := mq.Subscribe(context.Background(), "orders", func(msg miface.Message, err error) common.ConsumptionCode {
+		handled++
+		return common.ConsumeAckFinal
+	}, miface.WithAtMostOnceDelivery("billing"))
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	for _, v := range []string{"a", "b"} {
+		if err := mq.Publish("orders", miface.WithBytes([]byte(v))); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+	select {
+	case <-sub.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("subscription not ended by ConsumeAckFinal")
+	}
+	if handled != 1 || sub.Err() != nil || b.Committed("billing") != 1 {
+		t.Fatalf("handled %d, err %v, committed %d", handled, sub.Err(), b.Committed("billing"))
+	}
+
+	lost := errors.New("broker lost")
+	errs := make(chan error, 1)
+	sub, err = mq.Subscribe(context.Background(), "orders", func(msg miface.Message, err error) common.ConsumptionCode {
+		if msg == nil {
+			errs <- err
+		}
+		return common.ConsumeAck
+	}, miface.WithAtMostOnceDelivery("audit"))
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	waitFor(t, "both messages", func() bool { return b.Committed("audit") == 2 })
+	b.mu.Lock()
+	b.fetchErr = lost
+	b.cond.Broadcast()
+	b.mu.Unlock()
+	select {
+	case <-sub.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("subscription not ended by the fetch failure")
+	}
+	if sub.Err() != lost || <-errs != lost {
+		t.Fatalf("expected the fetch failure, got %v", sub.Err())
+	}
+}
+
+func TestCloseEndsSubscriptions(t *testing.T) {
+	b := newFakeBroker()
+	mq := newTestQueue(b)
+	sub, err := mq.Subscribe(context.Background(), "orders", func(miface.Message, error) common.ConsumptionCode {
+		return common.ConsumeAck
+	}, miface.WithAtMostOnceDelivery("billing"))
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	if err := mq.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	select {
+	case <-sub.Done():
+	default:
+		t.Fatal("Close returned before the subscription ended")
+	}
+	if !errors.Is(sub.Err(), qerrors.ErrSubscriptionClosed) {
+		t.Fatalf("expected ErrSubscriptionClosed, got %v", sub.Err())
+	}
+}
@@ -0,0 +1,50 @@
+package kafka
+
+import (
+	"sync"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// offsets keeps the fetched messages of each partition in order, so that a concurrent subscription
+// commits a message only once every message fetched before it was handled.
+type offsets struct {
+	mu      sync.Mutex
+	pending map[int][]*fetched
+}
+
+type fetched struct {
+	msg     kafka.Message
+	handled bool
+}
+
+func newOffsets() *offsets {
+	return &offsets{pending: map[int][]*fetched{}}
+}
+
+// fetched records msg, handled takes the result back.
+func (o *offsets) fetched(msg kafka.Message) *fetched {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	f := &fetched{msg: msg}
+	o.pending[msg.Partition] = append(o.pending[msg.Partition], f)
+	return f
+}
+
+// handled marks f and returns the last message of its partition that is now committable, false
+// while a message fetched before f is still being handled.
+func (o *offsets) handled(f *fetched) (kafka.Message, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	f.handled = true
+	pending := o.pending[f.msg.Partition]
+	var last *fetched
+	for len(pending) > 0 && pending[0].handled {
+		last, pending = pending[0], pending[1:]
+	}
+	o.pending[f.msg.Partition] = pending
+	if last == nil {
+		return kafka.Message{}, false
+	}
+	return last.msg, true
+}
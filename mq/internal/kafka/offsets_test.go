@@ -0,0 +1,28 @@
+package kafka
+
+import (
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func TestOffsetsCommitInOrder(t *testing.T) {
+	o := newOffsets()
+	a := o.fetched(kafka.Message{Partition: 0, Offset: 1})
+	b := o.fetched(kafka.Message{Partition: 0, Offset: 2})
+	other := o.fetched(kafka.Message{Partition: 1, Offset: 7})
+	c := o.fetched(kafka.Message{Partition: 0, Offset: 5})
+
+	if _, ok := o.handled(b); ok {
+		t.Fatal("offset 2 committable before offset 1 was handled")
+	}
+	if msg, ok := o.handled(other); !ok || msg.Offset != 7 {
+		t.Fatalf("partition 1: got %d, %v want 7", msg.Offset, ok)
+	}
+	if msg, ok := o.handled(a); !ok || msg.Offset != 2 {
+		t.Fatalf("got %d, %v want 2", msg.Offset, ok)
+	}
+	if msg, ok := o.handled(c); !ok || msg.Offset != 5 {
+		t.Fatalf("got %d, %v want 5", msg.Offset, ok)
+	}
+}
@@ -0,0 +1,98 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// lookupPartitions returns the partitions of topic from the first broker answering.
+func (m *MessageQueue) lookupPartitions(ctx context.Context, topic string) ([]int, error) {
+	var errs []error
+	for _, broker := range m.brokers {
+		ps, err := m.dialer.LookupPartitions(ctx, "tcp", broker, topic)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		ids := make([]int, 0, len(ps))
+		for _, p := range ps {
+			ids = append(ids, p.ID)
+		}
+		return ids, nil
+	}
+	return nil, errors.Join(errs...)
+}
+
+// partitionReader reads the partitions of a topic with one reader each, without a consumer group,
+// for the subscriptions that receive every message. Nothing is committed, a subscription starts
+// at the end of the partitions.
+type partitionReader struct {
+	readers []reader
+	fetched chan fetchResult
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+type fetchResult struct {
+	msg kafka.Message
+	err error
+}
+
+func newPartitionReader(readers []reader) *partitionReader {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &partitionReader{readers: readers, fetched: make(chan fetchResult), cancel: cancel}
+	p.wg.Add(len(readers))
+	for _, r := range readers {
+		go func() {
+			defer p.wg.Done()
+			for {
+				msg, err := r.FetchMessage(ctx)
+				select {
+				case p.fetched <- fetchResult{msg: msg, err: err}:
+				case <-ctx.Done():
+					return
+				}
+				if err != nil {
+					return
+				}
+			}
+		}()
+	}
+	return p
+}
+
+func (p *partitionReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	select {
+	case <-ctx.Done():
+		return kafka.Message{}, ctx.Err()
+	case f := <-p.fetched:
+		return f.msg, f.err
+	}
+}
+
+func (p *partitionReader) ReadMessage(ctx context.Context) (kafka.Message, error) {
+	return p.FetchMessage(ctx)
+}
+
+// CommitMessages does nothing, readers without a group have no offsets to commit.
+func (p *partitionReader) CommitMessages(context.Context, ...kafka.Message) error {
+	return nil
+}
+
+func (p *partitionReader) Close() error {
+	p.cancel()
+	p.wg.Wait()
+	errs := make([]error, 0, len(p.readers))
+	for _, r := range p.readers {
+		errs = append(errs, r.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// SetOffset is not supported, the partitions are read from their end.
+func (p *partitionReader) SetOffset(int64) error {
+	return errors.ErrUnsupported
+}
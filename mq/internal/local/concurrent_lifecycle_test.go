@@ -65,3 +65,78 @@ func TestConcurrentPublishAndUnsubscribe(t *testing.T) {
 		t.Fatal("expected at least one message before Unsubscribe")
 	}
 }
+
+func TestConcurrentSubscriptionOrdersKeysAndDrains(t *testing.T) {
+	// a blocking gochannel delivers the messages in the order they were published
+	mq := NewMessageQueue(zap.NewNop(), 64, false, true)
+
+	var mu sync.Mutex
+	seen := map[string][]string{}
+	var running, peak atomic.Int32
+	sub, err := mq.Subscribe(context.Background(), "pool-topic", func(msg miface.Message, err error) common.ConsumptionCode {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for old := peak.Load(); n > old && !peak.CompareAndSwap(old, n); old = peak.Load() {
+		}
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		key := msg.Headers()["key"]
+		seen[key] = append(seen[key], string(msg.Data()))
+		mu.Unlock()
+		return common.ConsumeAck
+	},
+		miface.WithConcurrency(4),
+		miface.WithOrderingKey(func(msg miface.Message) string { return msg.Headers()["key"] }),
+	)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	const messages = 24
+	for i := 0; i < messages; i++ {
+		if err := mq.Publish("pool-topic",
+			miface.WithBytes([]byte{byte('a' + i)}),
+			miface.WithHeader("key", string(rune('0'+i%3))),
+		); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		n := 0
+		for _, s := range seen {
+			n += len(s)
+		}
+		mu.Unlock()
+		if n == messages {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("handled %d of %d messages", n, messages)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if peak.Load() < 2 {
+		t.Fatalf("peak concurrency=%d, expected messages handled in parallel", peak.Load())
+	}
+	mu.Lock()
+	for key, data := range seen {
+		for i := 1; i < len(data); i++ {
+			if data[i] < data[i-1] {
+				t.Fatalf("key %s handled out of order: %v", key, data)
+			}
+		}
+	}
+	mu.Unlock()
+
+	_ = sub.Unsubscribe()
+	select {
+	case <-sub.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("subscription not done after Unsubscribe")
+	}
+	if running.Load() != 0 {
+		t.Fatalf("Done closed with %d handlers running", running.Load())
+	}
+}
@@ -0,0 +1,106 @@
+package local
+
+import (
+	"context"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+
+	"github.com/gstones/moke-kit/mq/internal/pool"
+	"github.com/gstones/moke-kit/mq/internal/qerrors"
+	"github.com/gstones/moke-kit/mq/internal/subscription"
+	"github.com/gstones/moke-kit/mq/miface"
+)
+
+// group shares one subscription to a topic between the subscriptions of a group id: each
+// message goes to whichever member takes it first, and a nacked message comes back to the group.
+type group struct {
+	msgs    chan *message.Message
+	done    chan struct{} // closed once the group subscription ended
+	cancel  context.CancelFunc
+	members int
+}
+
+func groupKey(topic, id string) string {
+	return topic + "\x00" + id
+}
+
+// joinGroup adds a member to the group of topic and id, subscribing to the topic for the first one.
+func (m *MessageQueue) joinGroup(topic, id string) (*group, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := groupKey(topic, id)
+	if g, ok := m.groups[key]; ok {
+		g.members++
+		return g, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	msgIn, err := m.subscriber.Subscribe(ctx, topic)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	g := &group{msgs: make(chan *message.Message), done: make(chan struct{}), cancel: cancel, members: 1}
+	go func() {
+		defer close(g.done)
+		for msg := range msgIn {
+			select {
+			case g.msgs <- msg:
+			case <-ctx.Done():
+			}
+		}
+	}()
+	m.groups[key] = g
+	return g, nil
+}
+
+// leaveGroup removes a member, the last one to leave closes the group subscription.
+func (m *MessageQueue) leaveGroup(topic, id string, g *group) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if g.members--; g.members == 0 {
+		g.cancel()
+		delete(m.groups, groupKey(topic, id))
+	}
+}
+
+func (m *MessageQueue) subscribeGroup(
+	ctx context.Context,
+	topic string,
+	handler miface.SubResponseHandler,
+	options miface.SubOptions,
+) (miface.Subscription, error) {
+	g, err := m.joinGroup(topic, options.GroupId)
+	if err != nil {
+		return nil, err
+	}
+	subCtx, cancel := context.WithCancel(ctx)
+	sub := subscription.NewCancelSubscription(cancel)
+	go func() {
+		defer m.leaveGroup(topic, options.GroupId, g)
+		defer sub.Ended(ctx)
+		p := pool.ForSubscription(options)
+		defer p.Close()
+		for {
+			select {
+			case <-subCtx.Done():
+				return
+			case <-g.done:
+				if subCtx.Err() == nil {
+					// the pubsub closed, the handler is told the subscription can't go on
+					handler(nil, qerrors.ErrSubscriptionClosed)
+					sub.Close(qerrors.ErrSubscriptionClosed)
+				}
+				return
+			case msg := <-g.msgs:
+				if !sub.IsValid() {
+					// back to the group for the other members
+					msg.Nack()
+					return
+				}
+				dispatch(subCtx, p, topic, msg, handler, options, sub)
+			}
+		}
+	}()
+	return sub, nil
+}
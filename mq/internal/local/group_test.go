@@ -0,0 +1,142 @@
+package local
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/gstones/moke-kit/mq/common"
+	"github.com/gstones/moke-kit/mq/miface"
+)
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func TestGroupSplitsMessages(t *testing.T) {
+	mq := NewMessageQueue(zap.NewNop(), 16, false, false)
+
+	var members [2]atomic.Int32
+	var broadcast atomic.Int32
+	for i := range members {
+		n := &members[i]
+		sub, err := mq.Subscribe(context.Background(), "orders", func(miface.Message, error) common.ConsumptionCode {
+			n.Add(1)
+			time.Sleep(time.Millisecond)
+			return common.ConsumeAck
+		}, miface.WithAtMostOnceDelivery("billing"))
+		if err != nil {
+			t.Fatalf("Subscribe: %v", err)
+		}
+		defer sub.Unsubscribe()
+	}
+	sub, err := mq.Subscribe(context.Background(), "orders", func(miface.Message, error) common.ConsumptionCode {
+		broadcast.Add(1)
+		return common.ConsumeAck
+	})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	const total = 20
+	for i := 0; i < total; i++ {
+		if err := mq.Publish("orders", miface.WithBytes([]byte("x"))); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+	waitFor(t, "every message", func() bool {
+		return broadcast.Load() == total && members[0].Load()+members[1].Load() == total
+	})
+	time.Sleep(50 * time.Millisecond)
+	if a, b := members[0].Load(), members[1].Load(); a+b != total || a == 0 || b == 0 {
+		t.Fatalf("group members got %d and %d of %d messages", a, b, total)
+	}
+}
+
+func TestGroupRedeliversNack(t *testing.T) {
+	mq := NewMessageQueue(zap.NewNop(), 16, false, false)
+
+	var mu sync.Mutex
+	var failed, handled []int
+	for i := 0; i < 2; i++ {
+		i := i
+		sub, err := mq.Subscribe(context.Background(), "orders", func(miface.Message, error) common.ConsumptionCode {
+			mu.Lock()
+			defer mu.Unlock()
+			if len(failed) == 0 {
+				failed = append(failed, i)
+				return common.ConsumeNackTransientFailure
+			}
+			handled = append(handled, i)
+			return common.ConsumeAck
+		}, miface.WithAtLeastOnceDelivery(), miface.WithGroupId("billing"))
+		if err != nil {
+			t.Fatalf("Subscribe: %v", err)
+		}
+		defer sub.Unsubscribe()
+	}
+
+	if err := mq.Publish("orders", miface.WithBytes([]byte("x"))); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	waitFor(t, "redelivery", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(handled) == 1
+	})
+}
+
+func TestGroupClosesWithLastMember(t *testing.T) {
+	mq := NewMessageQueue(zap.NewNop(), 16, false, false)
+	handler := func(miface.Message, error) common.ConsumptionCode { return common.ConsumeAck }
+
+	a, err := mq.Subscribe(context.Background(), "orders", handler, miface.WithGroupId("billing"))
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	b, err := mq.Subscribe(context.Background(), "orders", handler, miface.WithGroupId("billing"))
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	groups := func() int {
+		mq.mu.Lock()
+		defer mq.mu.Unlock()
+		return len(mq.groups)
+	}
+	if groups() != 1 {
+		t.Fatalf("expected one group, got %d", groups())
+	}
+	_ = a.Unsubscribe()
+	time.Sleep(20 * time.Millisecond)
+	if groups() != 1 {
+		t.Fatal("group closed while a member is left")
+	}
+	_ = b.Unsubscribe()
+	waitFor(t, "group closed", func() bool { return groups() == 0 })
+}
+
+func TestSubscribeRejectsUnknownSemantics(t *testing.T) {
+	mq := NewMessageQueue(zap.NewNop(), 16, false, false)
+	_, err := mq.Subscribe(context.Background(), "orders", func(miface.Message, error) common.ConsumptionCode {
+		return common.ConsumeAck
+	}, func(o *miface.SubOptions) error {
+		o.DeliverySemantics = "exactly-once"
+		return nil
+	})
+	if err == nil {
+		t.Fatal("expected unknown semantics to be rejected")
+	}
+}
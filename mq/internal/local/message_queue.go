@@ -2,6 +2,8 @@ package local
 
 import (
 	"context"
+	"sync"
+	"time"
 
 	"github.com/ThreeDotsLabs/watermill"
 	"github.com/ThreeDotsLabs/watermill/message"
@@ -9,14 +11,21 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/gstones/moke-kit/mq/common"
+	message2 "github.com/gstones/moke-kit/mq/internal/message"
 	"github.com/gstones/moke-kit/mq/internal/qerrors"
 	"github.com/gstones/moke-kit/mq/miface"
 )
 
+// MessageQueue passes messages between the subscriptions of the process. Every subscription
+// receives every message of its topic, except subscriptions sharing a group id, which split them.
 type MessageQueue struct {
 	logger     *zap.Logger
 	publisher  message.Publisher
 	subscriber message.Subscriber
+
+	mu         sync.Mutex
+	groups     map[string]*group
+	responders map[string]int
 }
 
 func NewMessageQueue(logger *zap.Logger, bufferSize int64, persistent bool, isBlocked bool) *MessageQueue {
@@ -32,6 +41,8 @@ func NewMessageQueue(logger *zap.Logger, bufferSize int64, persistent bool, isBl
 		logger:     logger,
 		publisher:  pubSub,
 		subscriber: pubSub,
+		groups:     map[string]*group{},
+		responders: map[string]int{},
 	}
 }
 
@@ -39,13 +50,20 @@ func (m *MessageQueue) Subscribe(
 	ctx context.Context,
 	topic string,
 	handler miface.SubResponseHandler,
-	_ ...miface.SubOption,
+	opts ...miface.SubOption,
 ) (miface.Subscription, error) {
 	if topic == "" {
 		return nil, qerrors.ErrEmptyTopic
 	}
 	topic = common.NamespaceTopic(topic)
-	return CreateSubscription(ctx, topic, handler, m.subscriber)
+	options, err := miface.NewSubOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	if options.GroupId != "" {
+		return m.subscribeGroup(ctx, topic, handler, options)
+	}
+	return CreateSubscription(ctx, topic, handler, m.subscriber, options)
 }
 
 func (m *MessageQueue) Publish(topic string, pOpts ...miface.PubOption) error {
@@ -55,10 +73,14 @@ func (m *MessageQueue) Publish(topic string, pOpts ...miface.PubOption) error {
 	topic = common.NamespaceTopic(topic)
 	if options, err := miface.NewPubOptions(pOpts...); err != nil {
 		return err
-	} else if options.Delay != 0 {
+	} else if options.DelayFrom(time.Now()) > 0 {
 		return qerrors.ErrDelayedPublishUnsupported
 	} else {
-		msg := message.NewMessage(watermill.NewUUID(), options.Data)
+		id := options.MessageId
+		if id == "" {
+			id = watermill.NewUUID()
+		}
+		msg := message2.Msg2WatermillMessage(id, options)
 		return m.publisher.Publish(topic, msg)
 	}
 
@@ -45,3 +45,85 @@ func TestLocalSubscribeReturnsValidSubscription(t *testing.T) {
 		t.Fatal("expected subscription invalid after unsubscribe")
 	}
 }
+
+func TestLocalPublishCarriesHeaders(t *testing.T) {
+	mq := NewMessageQueue(zap.NewNop(), 16, false, false)
+	got := make(chan miface.Message, 1)
+	sub, err := mq.Subscribe(context.Background(), "topic-h", func(msg miface.Message, err error) common.ConsumptionCode {
+		got <- msg
+		return common.ConsumeAck
+	})
+	if err != nil {
+		t.Fatalf("Subscribe error: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	before := time.Now()
+	if err := mq.Publish("topic-h", miface.WithJSON("hello"), miface.WithHeader("correlation-id", "c-1"),
+		miface.WithHeaders(map[string]string{common.HeaderContentType: "text/plain"}), miface.WithSchemaVersion("3")); err != nil {
+		t.Fatalf("Publish error: %v", err)
+	}
+
+	select {
+	case msg := <-got:
+		h := msg.Headers()
+		if h["correlation-id"] != "c-1" || h[common.HeaderContentType] != "text/plain" || h[common.HeaderSchemaVersion] != "3" {
+			t.Fatalf("unexpected headers: %v", h)
+		}
+		if msg.Timestamp().Before(before) || msg.Timestamp().After(time.Now()) {
+			t.Fatalf("unexpected timestamp: %v", msg.Timestamp())
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected message to be consumed")
+	}
+}
+
+func TestLocalSubscriptionEnds(t *testing.T) {
+	mq := NewMessageQueue(zap.NewNop(), 16, false, false)
+	var got atomic.Int32
+	final := func(miface.Message, error) common.ConsumptionCode {
+		got.Add(1)
+		return common.ConsumeAckFinal
+	}
+	sub, err := mq.Subscribe(context.Background(), "topic-f", final)
+	if err != nil {
+		t.Fatalf("Subscribe error: %v", err)
+	}
+	member, err := mq.Subscribe(context.Background(), "topic-f", final, miface.WithGroupId("g"))
+	if err != nil {
+		t.Fatalf("Subscribe error: %v", err)
+	}
+	for range 3 {
+		if err := mq.Publish("topic-f", miface.WithBytes([]byte("x"))); err != nil {
+			t.Fatalf("Publish error: %v", err)
+		}
+	}
+	for _, s := range []miface.Subscription{sub, member} {
+		select {
+		case <-s.Done():
+		case <-time.After(2 * time.Second):
+			t.Fatal("expected ConsumeAckFinal to end the subscription")
+		}
+		if s.IsValid() || s.Err() != nil {
+			t.Fatalf("unexpected end: valid %v, err %v", s.IsValid(), s.Err())
+		}
+	}
+	if n := got.Load(); n != 2 {
+		t.Fatalf("expected one message per subscription, got %d", n)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sub, err = mq.Subscribe(ctx, "topic-f", final)
+	if err != nil {
+		t.Fatalf("Subscribe error: %v", err)
+	}
+	cancel()
+	select {
+	case <-sub.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the context to end the subscription")
+	}
+	if sub.Err() != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", sub.Err())
+	}
+}
@@ -0,0 +1,106 @@
+package local
+
+import (
+	"context"
+	"slices"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"go.uber.org/zap"
+
+	"github.com/gstones/moke-kit/mq/common"
+	"github.com/gstones/moke-kit/mq/internal/qerrors"
+	"github.com/gstones/moke-kit/mq/miface"
+)
+
+// replyTopicPrefix starts the topics requests wait for their reply on.
+const replyTopicPrefix = "_reply."
+
+// Request publishes the request with a reply-to header naming a topic of its own, and waits for
+// the first message on it.
+func (m *MessageQueue) Request(ctx context.Context, topic string, pOpts ...miface.PubOption) (miface.Message, error) {
+	if topic == "" {
+		return nil, qerrors.ErrEmptyTopic
+	}
+	m.mu.Lock()
+	responders := m.responders[common.NamespaceTopic(topic)]
+	m.mu.Unlock()
+	if responders == 0 {
+		return nil, qerrors.ErrNoResponders
+	}
+
+	subCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	replies := make(chan miface.Message, 1)
+	replyTo := replyTopicPrefix + watermill.NewShortUUID()
+	if _, err := m.Subscribe(subCtx, replyTo, func(msg miface.Message, _ error) common.ConsumptionCode {
+		if msg == nil {
+			return common.ConsumeAck
+		}
+		select {
+		case replies <- msg:
+		default:
+		}
+		return common.ConsumeAck
+	}); err != nil {
+		return nil, err
+	}
+	if err := m.Publish(topic, append(slices.Clip(pOpts), miface.WithHeader(common.HeaderReplyTo, replyTo))...); err != nil {
+		return nil, err
+	}
+
+	select {
+	case msg := <-replies:
+		return msg, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Respond subscribes handler to topic and publishes its replies to the reply-to topic of the
+// requests, subscriptions sharing a group id split the requests between them.
+func (m *MessageQueue) Respond(
+	ctx context.Context,
+	topic string,
+	handler miface.RequestHandler,
+	opts ...miface.SubOption,
+) (miface.Subscription, error) {
+	if topic == "" {
+		return nil, qerrors.ErrEmptyTopic
+	}
+	key := common.NamespaceTopic(topic)
+	sub, err := m.Subscribe(ctx, topic, func(msg miface.Message, _ error) common.ConsumptionCode {
+		if msg == nil {
+			// the subscription ends with the error
+			return common.ConsumeAck
+		}
+		data, err := handler(msg)
+		replyTo := msg.Headers()[common.HeaderReplyTo]
+		if replyTo == "" {
+			return common.ConsumeAck
+		}
+		reply := []miface.PubOption{miface.WithBytes(data)}
+		if err != nil {
+			reply = []miface.PubOption{miface.WithHeader(common.HeaderError, err.Error())}
+		}
+		if err := m.Publish(replyTo, reply...); err != nil {
+			m.logger.Warn("local reply failure", zap.String("topic", topic), zap.Error(err))
+		}
+		return common.ConsumeAck
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	m.responders[key]++
+	m.mu.Unlock()
+	go func() {
+		<-sub.Done()
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if m.responders[key]--; m.responders[key] == 0 {
+			delete(m.responders, key)
+		}
+	}()
+	return sub, nil
+}
@@ -0,0 +1,71 @@
+package local
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/gstones/moke-kit/mq/common"
+	"github.com/gstones/moke-kit/mq/internal/qerrors"
+	"github.com/gstones/moke-kit/mq/miface"
+)
+
+func TestRequestReply(t *testing.T) {
+	mq := NewMessageQueue(zap.NewNop(), 16, false, false)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if _, err := mq.Request(ctx, "price", miface.WithBytes([]byte("sword"))); !errors.Is(err, qerrors.ErrNoResponders) {
+		t.Fatalf("expected no responders, got %v", err)
+	}
+
+	sub, err := mq.Respond(context.Background(), "price", func(msg miface.Message) ([]byte, error) {
+		if string(msg.Data()) == "shield" {
+			return nil, errors.New("out of stock")
+		}
+		return append([]byte("price of "), msg.Data()...), nil
+	}, miface.WithGroupId("shop"))
+	if err != nil {
+		t.Fatalf("Respond: %v", err)
+	}
+
+	reply, err := mq.Request(ctx, "price", miface.WithBytes([]byte("sword")))
+	if err != nil || string(reply.Data()) != "price of sword" {
+		t.Fatalf("Request: %v, %v", reply, err)
+	}
+	reply, err = mq.Request(ctx, "price", miface.WithBytes([]byte("shield")))
+	if err != nil || reply.Headers()[common.HeaderError] != "out of stock" {
+		t.Fatalf("expected the error reply, got %v, %v", reply, err)
+	}
+
+	if err := sub.Unsubscribe(); err != nil {
+		t.Fatalf("Unsubscribe: %v", err)
+	}
+	waitFor(t, "responder gone", func() bool {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err := mq.Request(ctx, "price")
+		return errors.Is(err, qerrors.ErrNoResponders)
+	})
+}
+
+func TestRequestTimesOut(t *testing.T) {
+	mq := NewMessageQueue(zap.NewNop(), 16, false, false)
+	sub, err := mq.Respond(context.Background(), "slow", func(msg miface.Message) ([]byte, error) {
+		time.Sleep(200 * time.Millisecond)
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatalf("Respond: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := mq.Request(ctx, "slow"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+}
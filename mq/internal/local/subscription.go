@@ -7,6 +7,8 @@ import (
 
 	"github.com/gstones/moke-kit/mq/common"
 	message2 "github.com/gstones/moke-kit/mq/internal/message"
+	"github.com/gstones/moke-kit/mq/internal/pool"
+	"github.com/gstones/moke-kit/mq/internal/qerrors"
 	"github.com/gstones/moke-kit/mq/internal/subscription"
 	"github.com/gstones/moke-kit/mq/miface"
 )
@@ -16,6 +18,7 @@ func CreateSubscription(
 	topic string,
 	handler miface.SubResponseHandler,
 	subscriber message.Subscriber,
+	options miface.SubOptions,
 ) (miface.Subscription, error) {
 	subCtx, cancel := context.WithCancel(ctx)
 	msgIn, err := subscriber.Subscribe(subCtx, topic)
@@ -25,15 +28,76 @@ func CreateSubscription(
 	}
 	sub := subscription.NewCancelSubscription(cancel)
 	go func() {
-		defer sub.Unsubscribe()
+		defer sub.Ended(ctx)
+		p := pool.ForSubscription(options)
+		defer p.Close()
 		for msg := range msgIn {
-			m := message2.Msg2Message(topic, msg)
-			if code := handler(m, nil); code == common.ConsumeNackTransientFailure {
+			if !sub.IsValid() {
 				msg.Nack()
-			} else {
-				msg.Ack()
+				return
 			}
+			dispatch(subCtx, p, topic, msg, handler, options, sub)
+		}
+		if subCtx.Err() == nil {
+			// the pubsub closed, the handler is told the subscription can't go on
+			handler(nil, qerrors.ErrSubscriptionClosed)
+			sub.Close(qerrors.ErrSubscriptionClosed)
 		}
 	}()
 	return sub, nil
 }
+
+// dispatch consumes msg, on a worker of p for a concurrent subscription. The gochannel subscriber
+// sends no message before the previous one was acked, so a concurrent subscription acks msg once
+// a worker took it and redelivers a nacked copy itself, like gochannel does, until ctx ends.
+func dispatch(
+	ctx context.Context,
+	p *pool.Pool,
+	topic string,
+	msg *message.Message,
+	handler miface.SubResponseHandler,
+	options miface.SubOptions,
+	sub *subscription.CancelSubscription,
+) {
+	if options.Concurrency <= 1 {
+		if consume(ctx, topic, msg, handler, options) == common.ConsumeAckFinal {
+			_ = sub.Unsubscribe()
+		}
+		return
+	}
+	p.Submit(options.Key(message2.Msg2Message(topic, msg)), func() {
+		for {
+			code := consume(ctx, topic, msg.Copy(), handler, options)
+			if code == common.ConsumeAckFinal {
+				_ = sub.Unsubscribe()
+			}
+			if code != common.ConsumeNackTransientFailure || ctx.Err() != nil {
+				return
+			}
+		}
+	})
+	msg.Ack()
+}
+
+// consume hands msg to handler in ctx, acking it first for at-most-once delivery and nacking it
+// for a redelivery on a transient failure otherwise.
+func consume(
+	ctx context.Context,
+	topic string,
+	msg *message.Message,
+	handler miface.SubResponseHandler,
+	options miface.SubOptions,
+) common.ConsumptionCode {
+	m := message2.WithContext(message2.Msg2Message(topic, msg), ctx)
+	if options.DeliverySemantics == common.AtMostOnce {
+		msg.Ack()
+		return handler(m, nil)
+	}
+	code := handler(m, nil)
+	if code == common.ConsumeNackTransientFailure {
+		msg.Nack()
+	} else {
+		msg.Ack()
+	}
+	return code
+}
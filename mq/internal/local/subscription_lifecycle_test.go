@@ -2,13 +2,17 @@ package local
 
 import (
 	"context"
+	"errors"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/pubsub/gochannel"
 	"go.uber.org/zap"
 
 	"github.com/gstones/moke-kit/mq/common"
+	"github.com/gstones/moke-kit/mq/internal/qerrors"
 	"github.com/gstones/moke-kit/mq/miface"
 )
 
@@ -55,3 +59,33 @@ func TestUnsubscribeDoesNotBreakOtherSubscriptions(t *testing.T) {
 		t.Fatalf("topic-a should not receive messages, got %d", aCount.Load())
 	}
 }
+
+func TestClosedPubSubFailsSubscriptions(t *testing.T) {
+	pubSub := gochannel.NewGoChannel(gochannel.Config{}, watermill.NopLogger{})
+	failures := make(chan error, 1)
+	sub, err := CreateSubscription(context.Background(), "topic", func(msg miface.Message, err error) common.ConsumptionCode {
+		if msg == nil {
+			failures <- err
+		}
+		return common.ConsumeAck
+	}, pubSub, miface.SubOptions{})
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+
+	if err := pubSub.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	select {
+	case err := <-failures:
+		if !errors.Is(err, qerrors.ErrSubscriptionClosed) {
+			t.Fatalf("expected ErrSubscriptionClosed, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler was not told about the closed pubsub")
+	}
+	<-sub.Done()
+	if !errors.Is(sub.Err(), qerrors.ErrSubscriptionClosed) {
+		t.Fatalf("expected ErrSubscriptionClosed, got %v", sub.Err())
+	}
+}
@@ -1,32 +1,62 @@
 package message
 
 import (
+	"context"
+	"maps"
+	"time"
+
 	wmsg "github.com/ThreeDotsLabs/watermill/message"
 
+	"github.com/gstones/moke-kit/mq/common"
 	"github.com/gstones/moke-kit/mq/miface"
 )
 
 type message struct {
-	id    string
-	topic string
-	data  []byte
-	vPtr  any
+	id          string
+	topic       string
+	data        []byte
+	vPtr        any
+	headers     map[string]string
+	publishedAt time.Time
 }
 
 // Msg2Message converts a watermill message to a message
 func Msg2Message(topic string, msg *wmsg.Message) miface.Message {
-	return NewMessage(msg.UUID, topic, msg.Payload, nil)
+	return NewMessage(msg.UUID, topic, msg.Payload, nil, msg.Metadata, PublishedAt(msg.Metadata))
+}
+
+// Msg2WatermillMessage converts published options to a watermill message carrying their headers
+func Msg2WatermillMessage(id string, options miface.PubOptions) *wmsg.Message {
+	msg := wmsg.NewMessage(id, options.Data)
+	msg.Metadata = Headers(options.Headers, time.Now())
+	return msg
 }
 
-func NewMessage(id string, topic string, data []byte, vPtr any) miface.Message {
+func NewMessage(id string, topic string, data []byte, vPtr any, headers map[string]string, publishedAt time.Time) miface.Message {
 	return &message{
-		id:    id,
-		topic: topic,
-		data:  data,
-		vPtr:  vPtr,
+		id:          id,
+		topic:       topic,
+		data:        data,
+		vPtr:        vPtr,
+		headers:     headers,
+		publishedAt: publishedAt,
 	}
 }
 
+// Headers returns a copy of headers with the published-at header set to now.
+func Headers(headers map[string]string, now time.Time) map[string]string {
+	h := make(map[string]string, len(headers)+1)
+	maps.Copy(h, headers)
+	h[common.HeaderPublishedAt] = now.UTC().Format(time.RFC3339Nano)
+	return h
+}
+
+// PublishedAt parses the published-at header, the zero time if it is missing or malformed.
+func PublishedAt(headers map[string]string) time.Time {
+	t, _ := time.Parse(time.RFC3339Nano, headers[common.HeaderPublishedAt])
+	return t
+}
+
 func (m *message) ID() string {
 	return m.id
 }
@@ -42,3 +72,76 @@ func (m *message) Data() []byte {
 func (m *message) VPtr() (vPtr any) {
 	return m.vPtr
 }
+
+func (m *message) Headers() map[string]string {
+	return m.headers
+}
+
+func (m *message) Timestamp() time.Time {
+	return m.publishedAt
+}
+
+func (m *message) Context() context.Context {
+	return context.Background()
+}
+
+type contextMessage struct {
+	miface.Message
+	ctx context.Context
+}
+
+// WithContext returns msg handled in ctx.
+func WithContext(msg miface.Message, ctx context.Context) miface.Message {
+	return &contextMessage{Message: msg, ctx: ctx}
+}
+
+func (m *contextMessage) Context() context.Context {
+	return m.ctx
+}
+
+type valueMessage struct {
+	miface.Message
+	vPtr any
+}
+
+// WithVPtr returns msg with the value its data was decoded into.
+func WithVPtr(msg miface.Message, vPtr any) miface.Message {
+	return &valueMessage{Message: msg, vPtr: vPtr}
+}
+
+func (m *valueMessage) VPtr() any {
+	return m.vPtr
+}
+
+type headersMessage struct {
+	miface.Message
+	headers map[string]string
+}
+
+// WithHeaders returns msg with headers in place of its own.
+func WithHeaders(msg miface.Message, headers map[string]string) miface.Message {
+	return &headersMessage{Message: msg, headers: headers}
+}
+
+func (m *headersMessage) Headers() map[string]string {
+	return m.headers
+}
+
+type deliveryMessage struct {
+	miface.Message
+	delivery int
+}
+
+// WithDelivery returns msg, the delivery-th delivery of the message by an mq implementation that
+// counts them and redelivers a nacked message after the delay of the retry policy of its subscription.
+func WithDelivery(msg miface.Message, delivery int) miface.Message {
+	return &deliveryMessage{Message: msg, delivery: delivery}
+}
+
+// Delivery returns the number of the delivery of msg, 0 when its mq implementation doesn't count them.
+func Delivery(msg miface.Message) int {
+	if m, ok := msg.(*deliveryMessage); ok {
+		return m.delivery
+	}
+	return 0
+}
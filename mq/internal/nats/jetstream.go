@@ -0,0 +1,192 @@
+package nats
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	nc "github.com/ThreeDotsLabs/watermill-nats/v2/pkg/nats"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/nats-io/nats.go"
+
+	"github.com/gstones/moke-kit/mq/miface"
+)
+
+const (
+	defaultAckWait  = 30 * time.Second
+	defaultNakDelay = 100 * time.Millisecond
+	// maxNakDelay caps the backoff between redeliveries of a nacked message.
+	maxNakDelay = time.Minute
+)
+
+// JetStream configures the durable mode of the nats message queue. Every topic is stored in a
+// stream of its own, created on first use, and subscriptions consume it through consumers:
+// subscriptions with a group id share the durable consumer named after it, which keeps its
+// position while no replica is running; the others get an ephemeral consumer receiving the
+// messages published after they subscribed.
+//
+// A message not acked within AckWait, or nacked, is redelivered after NakDelay doubling with
+// every attempt, or after the delay of the retry policy of its subscription, up to MaxDeliver
+// deliveries, which bound the attempts of the policy too; MaxDeliver 0 or less redelivers
+// forever. A durable consumer keeps the settings it was created with.
+type JetStream struct {
+	AckWait    time.Duration
+	MaxDeliver int
+	NakDelay   time.Duration
+}
+
+// Option configures a MessageQueue.
+type Option func(m *MessageQueue)
+
+// WithJetStream switches the message queue from core nats to JetStream.
+func WithJetStream(js JetStream) Option {
+	return func(m *MessageQueue) {
+		if js.AckWait <= 0 {
+			js.AckWait = defaultAckWait
+		}
+		if js.NakDelay <= 0 {
+			js.NakDelay = defaultNakDelay
+		}
+		if js.MaxDeliver <= 0 {
+			js.MaxDeliver = -1
+		}
+		m.jetStream = &js
+	}
+}
+
+// nakBackoff doubles the redelivery delay with every delivery of a message.
+type nakBackoff struct {
+	delay time.Duration
+}
+
+func (b nakBackoff) WaitTime(delivered uint64) time.Duration {
+	d := b.delay
+	for i := uint64(1); i < delivered && d < maxNakDelay; i++ {
+		d *= 2
+	}
+	return min(d, maxNakDelay)
+}
+
+// retryBackoff delays the redeliveries of a message like the retry policy of its subscription.
+type retryBackoff miface.RetryPolicy
+
+func (b retryBackoff) WaitTime(delivered uint64) time.Duration {
+	return miface.RetryPolicy(b).Delay(int(delivered))
+}
+
+// deliveredHeader carries the number of the delivery of a JetStream message from the unmarshaler
+// to the subscription, which removes it.
+const deliveredHeader = "_nats_delivered"
+
+// unmarshaler decodes the messages like marshaler, JetStream ones with their delivery number.
+type unmarshaler struct{}
+
+func (unmarshaler) Unmarshal(msg *nats.Msg) (*message.Message, error) {
+	m, err := marshaler.Unmarshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	if meta, err := msg.Metadata(); err == nil {
+		if m.Metadata == nil {
+			m.Metadata = message.Metadata{}
+		}
+		m.Metadata.Set(deliveredHeader, strconv.FormatUint(meta.NumDelivered, 10))
+	}
+	return m, nil
+}
+
+// jsName turns a topic or a group id into a stream or consumer name, which can't hold the
+// subject separators and wildcards.
+func jsName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '*', '>', '/', '\\', ' ', '\t', '\n', '\r':
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// ensureStream creates the stream of topic unless an earlier call already saw it.
+func (m *MessageQueue) ensureStream(topic string) (string, error) {
+	name := jsName(topic)
+	if _, ok := m.streams.Load(name); ok {
+		return name, nil
+	}
+	if _, err := m.js.StreamInfo(name); errors.Is(err, nats.ErrStreamNotFound) {
+		_, err = m.js.AddStream(&nats.StreamConfig{
+			Name:     name,
+			Subjects: []string{topic},
+			Storage:  nats.FileStorage,
+		})
+		// another replica may have created it meanwhile
+		if err != nil {
+			if _, infoErr := m.js.StreamInfo(name); infoErr != nil {
+				return "", err
+			}
+		}
+	} else if err != nil {
+		return "", err
+	}
+	m.streams.Store(name, struct{}{})
+	return name, nil
+}
+
+// ensureConsumer creates the durable consumer of group on stream. The library would delete a
+// durable it created itself on unsubscribe, so it is provisioned here and bound to instead.
+func (m *MessageQueue) ensureConsumer(stream, topic, group string) (string, error) {
+	durable := jsName(group)
+	if _, err := m.js.ConsumerInfo(stream, durable); errors.Is(err, nats.ErrConsumerNotFound) {
+		_, err = m.js.AddConsumer(stream, &nats.ConsumerConfig{
+			Durable:        durable,
+			DeliverSubject: nats.NewInbox(),
+			DeliverGroup:   group,
+			DeliverPolicy:  nats.DeliverAllPolicy,
+			AckPolicy:      nats.AckExplicitPolicy,
+			AckWait:        m.jetStream.AckWait,
+			MaxDeliver:     m.jetStream.MaxDeliver,
+			FilterSubject:  topic,
+		})
+		if err != nil {
+			if _, infoErr := m.js.ConsumerInfo(stream, durable); infoErr != nil {
+				return "", err
+			}
+		}
+	} else if err != nil {
+		return "", err
+	}
+	return durable, nil
+}
+
+// jetStreamSubscriber creates the subscriber consuming topic for one subscription, which naks
+// after the delay of its retry policy when it has one.
+func (m *MessageQueue) jetStreamSubscriber(topic string, options miface.SubOptions, subscribers int) (*nc.Subscriber, error) {
+	group := options.GroupId
+	stream, err := m.ensureStream(topic)
+	if err != nil {
+		return nil, err
+	}
+	cfg := nc.JetStreamConfig{}
+	if group != "" {
+		durable, err := m.ensureConsumer(stream, topic, group)
+		if err != nil {
+			return nil, err
+		}
+		cfg.SubscribeOptions = []nats.SubOpt{nats.Bind(stream, durable), nats.ManualAck()}
+		cfg.DurableCalculator = func(string, string) string { return durable }
+	} else {
+		cfg.SubscribeOptions = []nats.SubOpt{
+			nats.ManualAck(),
+			nats.AckExplicit(),
+			nats.AckWait(m.jetStream.AckWait),
+			nats.MaxDeliver(m.jetStream.MaxDeliver),
+			nats.DeliverNew(),
+		}
+	}
+	var nakDelay nc.Delay = nakBackoff{delay: m.jetStream.NakDelay}
+	if options.Retry.MaxAttempts > 0 {
+		nakDelay = retryBackoff(options.Retry)
+	}
+	return m.newSubscriber(cfg, group, subscribers, nakDelay)
+}
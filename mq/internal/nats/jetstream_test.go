@@ -0,0 +1,197 @@
+package nats
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	natsserver "github.com/nats-io/nats-server/v2/server"
+	"go.uber.org/zap"
+
+	"github.com/gstones/moke-kit/mq/common"
+	"github.com/gstones/moke-kit/mq/internal/message"
+	"github.com/gstones/moke-kit/mq/miface"
+)
+
+func startTestJetStream(t *testing.T, js JetStream) *MessageQueue {
+	t.Helper()
+	opts := &natsserver.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+	}
+	s, err := natsserver.NewServer(opts)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	go s.Start()
+	if !s.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats server not ready")
+	}
+	t.Cleanup(s.Shutdown)
+
+	mq, err := NewMessageQueue(zap.NewNop(), s.ClientURL(), WithJetStream(js))
+	if err != nil {
+		t.Fatalf("NewMessageQueue: %v", err)
+	}
+	return mq
+}
+
+type collected struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (c *collected) handler(msg miface.Message, _ error) common.ConsumptionCode {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.msgs = append(c.msgs, string(msg.Data()))
+	return common.ConsumeAck
+}
+
+func (c *collected) get() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.msgs...)
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func TestNakBackoff(t *testing.T) {
+	b := nakBackoff{delay: 100 * time.Millisecond}
+	for delivered, want := range map[uint64]time.Duration{
+		1:  100 * time.Millisecond,
+		2:  200 * time.Millisecond,
+		4:  800 * time.Millisecond,
+		50: maxNakDelay,
+	} {
+		if got := b.WaitTime(delivered); got != want {
+			t.Fatalf("WaitTime(%d) = %v, want %v", delivered, got, want)
+		}
+	}
+	if got := jsName("game.orders.*"); got != "game_orders__" {
+		t.Fatalf("jsName = %q", got)
+	}
+}
+
+func TestJetStreamDurableGroupResumes(t *testing.T) {
+	mq := startTestJetStream(t, JetStream{})
+
+	var c collected
+	sub, err := mq.Subscribe(context.Background(), "orders", c.handler, miface.WithGroupId("billing"))
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	if err := mq.Publish("orders", miface.WithBytes([]byte("a"))); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	waitFor(t, "first message", func() bool { return len(c.get()) == 1 })
+	if err := sub.Unsubscribe(); err != nil {
+		t.Fatalf("Unsubscribe: %v", err)
+	}
+	waitFor(t, "subscription invalid", func() bool { return !sub.IsValid() })
+
+	// published while the group has no subscriber, delivered once it is back
+	if err := mq.Publish("orders", miface.WithBytes([]byte("b"))); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	sub, err = mq.Subscribe(context.Background(), "orders", c.handler, miface.WithGroupId("billing"))
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer sub.Unsubscribe()
+	waitFor(t, "second message", func() bool { return len(c.get()) == 2 })
+	time.Sleep(100 * time.Millisecond)
+	if got := c.get(); len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Fatalf("got %v", got)
+	}
+}
+
+func TestJetStreamEphemeralReceivesNewMessages(t *testing.T) {
+	mq := startTestJetStream(t, JetStream{})
+
+	if err := mq.Publish("orders", miface.WithBytes([]byte("old"))); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	var c collected
+	sub, err := mq.Subscribe(context.Background(), "orders", c.handler)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer sub.Unsubscribe()
+	if err := mq.Publish("orders", miface.WithBytes([]byte("new"))); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	waitFor(t, "new message", func() bool { return len(c.get()) == 1 })
+	time.Sleep(100 * time.Millisecond)
+	if got := c.get(); len(got) != 1 || got[0] != "new" {
+		t.Fatalf("got %v", got)
+	}
+}
+
+func TestJetStreamNackRedelivers(t *testing.T) {
+	mq := startTestJetStream(t, JetStream{MaxDeliver: 3, NakDelay: 10 * time.Millisecond})
+
+	var attempts atomic.Int32
+	sub, err := mq.Subscribe(context.Background(), "orders", func(miface.Message, error) common.ConsumptionCode {
+		attempts.Add(1)
+		return common.ConsumeNackTransientFailure
+	}, miface.WithGroupId("billing"))
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	if err := mq.Publish("orders", miface.WithBytes([]byte("a"))); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	waitFor(t, "redeliveries", func() bool { return attempts.Load() == 3 })
+	time.Sleep(200 * time.Millisecond)
+	if n := attempts.Load(); n != 3 {
+		t.Fatalf("expected delivery to stop after 3 attempts, got %d", n)
+	}
+}
+
+func TestJetStreamCountsDeliveries(t *testing.T) {
+	mq := startTestJetStream(t, JetStream{})
+
+	deliveries := make(chan int, 3)
+	sub, err := mq.Subscribe(context.Background(), "orders", func(msg miface.Message, _ error) common.ConsumptionCode {
+		if _, ok := msg.Headers()[deliveredHeader]; ok {
+			t.Errorf("the delivery header leaked into %v", msg.Headers())
+		}
+		deliveries <- message.Delivery(msg)
+		return common.ConsumeNackTransientFailure
+	}, miface.WithGroupId("billing"), miface.WithRetryPolicy(miface.RetryPolicy{MaxAttempts: 3, Backoff: 10 * time.Millisecond}))
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	if err := mq.Publish("orders", miface.WithBytes([]byte("a"))); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	for want := 1; want <= 3; want++ {
+		select {
+		case got := <-deliveries:
+			if got != want {
+				t.Fatalf("expected delivery %d, got %d", want, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for delivery %d", want)
+		}
+	}
+}
@@ -2,14 +2,17 @@ package nats
 
 import (
 	"context"
+	"errors"
 	"sync/atomic"
 	"testing"
 	"time"
 
 	natsserver "github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
 	"go.uber.org/zap"
 
 	"github.com/gstones/moke-kit/mq/common"
+	"github.com/gstones/moke-kit/mq/internal/qerrors"
 	"github.com/gstones/moke-kit/mq/miface"
 )
 
@@ -101,3 +104,70 @@ func TestSubscribeEmptyTopic(t *testing.T) {
 		t.Fatal("expected empty topic error")
 	}
 }
+
+func TestCloseDrainsConnection(t *testing.T) {
+	addr, shutdown := startTestNATS(t)
+	defer shutdown()
+
+	mq, err := NewMessageQueue(zap.NewNop(), addr)
+	if err != nil {
+		t.Fatalf("NewMessageQueue: %v", err)
+	}
+	sub, err := mq.Subscribe(context.Background(), "close-topic", func(miface.Message, error) common.ConsumptionCode {
+		return common.ConsumeAck
+	})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	if err := mq.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	select {
+	case <-sub.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("subscription did not end with the connection")
+	}
+	if !errors.Is(sub.Err(), qerrors.ErrSubscriptionClosed) {
+		t.Fatalf("expected ErrSubscriptionClosed, got %v", sub.Err())
+	}
+	if !mq.conn.IsClosed() {
+		t.Fatal("expected the connection closed")
+	}
+	if err := mq.Close(); err != nil {
+		t.Fatalf("second Close: %v", err)
+	}
+}
+
+func TestConnectionCloseFailsSubscriptions(t *testing.T) {
+	addr, shutdown := startTestNATS(t)
+	defer shutdown()
+
+	mq, err := NewMessageQueue(zap.NewNop(), addr)
+	if err != nil {
+		t.Fatalf("NewMessageQueue: %v", err)
+	}
+	failures := make(chan error, 1)
+	sub, err := mq.Subscribe(context.Background(), "lost-topic", func(msg miface.Message, err error) common.ConsumptionCode {
+		if msg == nil {
+			failures <- err
+		}
+		return common.ConsumeAck
+	})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	mq.conn.Close()
+	select {
+	case err := <-failures:
+		if !errors.Is(err, nats.ErrConnectionClosed) {
+			t.Fatalf("expected ErrConnectionClosed, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler was not told about the connection")
+	}
+	<-sub.Done()
+	if !errors.Is(sub.Err(), nats.ErrConnectionClosed) {
+		t.Fatalf("expected ErrConnectionClosed, got %v", sub.Err())
+	}
+}
@@ -2,7 +2,11 @@ package nats
 
 import (
 	"context"
+	"errors"
 	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ThreeDotsLabs/watermill"
@@ -14,18 +18,31 @@ import (
 	"github.com/gstones/moke-kit/mq/common"
 	"github.com/gstones/moke-kit/mq/internal/logger"
 	message2 "github.com/gstones/moke-kit/mq/internal/message"
+	"github.com/gstones/moke-kit/mq/internal/pool"
 	"github.com/gstones/moke-kit/mq/internal/qerrors"
 	"github.com/gstones/moke-kit/mq/internal/subscription"
 	"github.com/gstones/moke-kit/mq/miface"
 )
 
+// MessageQueue publishes and consumes nats subjects, with core nats fire-and-forget delivery
+// unless WithJetStream asked for durable streams. Subscriptions sharing a group id form a queue
+// group, so each message goes to one of them; core nats rejects at-least-once subscriptions.
 type MessageQueue struct {
 	logger    *zap.Logger
+	conn      *nats.Conn
 	subscribe message.Subscriber
 	publisher message.Publisher
+
+	jetStream *JetStream
+	js        nats.JetStreamContext
+	streams   sync.Map // provisioned stream names
+
+	closing atomic.Bool
+	closed  chan struct{} // closed with the connection
 }
 
-func NewMessageQueue(logger *zap.Logger, address string) (*MessageQueue, error) {
+func NewMessageQueue(logger *zap.Logger, address string, opts ...Option) (*MessageQueue, error) {
+	closed := make(chan struct{})
 	if u, err := url.Parse(address); err != nil {
 		return nil, err
 	} else if conn, err := nats.Connect(
@@ -33,11 +50,20 @@ func NewMessageQueue(logger *zap.Logger, address string) (*MessageQueue, error)
 		nats.RetryOnFailedConnect(true),
 		nats.ReconnectWait(1*time.Second),
 		nats.Timeout(30*time.Second),
+		nats.ClosedHandler(func(*nats.Conn) { close(closed) }),
 	); err != nil {
 		return nil, err
 	} else {
-		mq := &MessageQueue{logger: logger}
-		if err := mq.newSubscribe(conn); err != nil {
+		mq := &MessageQueue{logger: logger, conn: conn, closed: closed}
+		for _, opt := range opts {
+			opt(mq)
+		}
+		if mq.jetStream != nil {
+			if mq.js, err = conn.JetStream(); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		} else if mq.subscribe, err = mq.newSubscriber(jsConfig, "", 1, nil); err != nil {
 			conn.Close()
 			return nil, err
 		}
@@ -49,30 +75,42 @@ func NewMessageQueue(logger *zap.Logger, address string) (*MessageQueue, error)
 	}
 }
 
-func (m *MessageQueue) newSubscribe(conn *nats.Conn) error {
-	subscriber, err := nc.NewSubscriberWithNatsConn(
-		conn,
-		nc.SubscriberSubscriptionConfig{
-			CloseTimeout:   30 * time.Second,
-			AckWaitTimeout: 30 * time.Second,
-			Unmarshaler:    marshaler,
-			JetStream:      jsConfig,
-		},
-		logger.NewZapLoggerAdapter(m.logger),
-	)
-	if err != nil {
-		return err
+// newSubscriber creates a subscriber of subscribers nats subscriptions, in queueGroup. JetStream
+// subscribers redeliver a nacked message after nakDelay.
+func (m *MessageQueue) newSubscriber(
+	js nc.JetStreamConfig,
+	queueGroup string,
+	subscribers int,
+	nakDelay nc.Delay,
+) (*nc.Subscriber, error) {
+	cfg := nc.SubscriberSubscriptionConfig{
+		CloseTimeout:     30 * time.Second,
+		AckWaitTimeout:   30 * time.Second,
+		Unmarshaler:      unmarshaler{},
+		JetStream:        js,
+		QueueGroupPrefix: queueGroup,
+		SubscribersCount: subscribers,
+		// the topic is the subject, and the queue group the group id
+		SubjectCalculator: nc.DefaultSubjectCalculator,
 	}
-	m.subscribe = subscriber
-	return nil
+	if m.jetStream != nil {
+		cfg.AckWaitTimeout = m.jetStream.AckWait
+		cfg.NakDelay = nakDelay
+	}
+	return nc.NewSubscriberWithNatsConn(m.conn, cfg, logger.NewZapLoggerAdapter(m.logger))
 }
 
 func (m *MessageQueue) newPublisher(conn *nats.Conn) error {
+	js := jsConfig
+	if m.jetStream != nil {
+		js = nc.JetStreamConfig{TrackMsgId: true}
+	}
 	publisher, err := nc.NewPublisherWithNatsConn(
 		conn,
 		nc.PublisherPublishConfig{
-			Marshaler: marshaler,
-			JetStream: jsConfig,
+			Marshaler:         marshaler,
+			JetStream:         js,
+			SubjectCalculator: nc.DefaultSubjectCalculator,
 		},
 		logger.NewZapLoggerAdapter(m.logger),
 	)
@@ -87,35 +125,124 @@ func (m *MessageQueue) Subscribe(
 	ctx context.Context,
 	topic string,
 	handler miface.SubResponseHandler,
-	_ ...miface.SubOption,
+	opts ...miface.SubOption,
 ) (miface.Subscription, error) {
 	if topic == "" {
 		return nil, qerrors.ErrEmptyTopic
 	}
 	topic = common.NamespaceTopic(topic)
+	options, err := miface.NewSubOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	// the subscriber hands over the next message of a nats subscription once the previous one was
+	// acked, concurrent subscriptions have as many nats subscriptions in a queue group instead
+	subscribers := max(options.Concurrency, 1)
+	subscriber := m.subscribe
+	if m.jetStream != nil {
+		if subscribers > 1 && options.GroupId == "" {
+			// every subscription of an ephemeral consumer would get every message
+			return nil, qerrors.ErrConcurrencyUnsupported
+		}
+		if subscriber, err = m.jetStreamSubscriber(topic, options, subscribers); err != nil {
+			return nil, err
+		}
+	} else if options.DeliverySemantics == common.AtLeastOnce {
+		// core nats forgets a message once it was sent, there is nothing to redeliver from
+		return nil, qerrors.ErrSemanticsUnsupported
+	} else if options.GroupId != "" || subscribers > 1 {
+		group := options.GroupId
+		if group == "" {
+			group = watermill.NewShortUUID()
+		}
+		if subscriber, err = m.newSubscriber(jsConfig, group, subscribers, nil); err != nil {
+			return nil, err
+		}
+	}
 
 	subCtx, cancel := context.WithCancel(ctx)
-	msgChan, err := m.subscribe.Subscribe(subCtx, topic)
+	msgChan, err := subscriber.Subscribe(subCtx, topic)
 	if err != nil {
 		cancel()
 		return nil, err
 	}
 
 	sub := subscription.NewCancelSubscription(cancel)
+	m.endOnClose(subCtx, sub, func(err error) { handler(nil, err) })
+	atMostOnce := options.DeliverySemantics == common.AtMostOnce
 	go func() {
-		defer sub.Unsubscribe()
+		defer sub.Ended(ctx)
+		p := pool.ForSubscription(options)
+		defer p.Close()
 		for msg := range msgChan {
-			ms := message2.Msg2Message(topic, msg)
-			if code := handler(ms, nil); code == common.ConsumeNackTransientFailure {
+			if !sub.IsValid() {
 				msg.Nack()
-			} else {
-				msg.Ack()
+				return
 			}
+			// JetStream counts the deliveries, a retry policy naks with its delay instead of in place
+			delivered, _ := strconv.Atoi(msg.Metadata.Get(deliveredHeader))
+			delete(msg.Metadata, deliveredHeader)
+			ms := message2.WithContext(message2.Msg2Message(topic, msg), subCtx)
+			if delivered > 0 && options.Retry.MaxAttempts > 0 && !atMostOnce {
+				ms = message2.WithDelivery(ms, delivered)
+			}
+			p.Submit(options.Key(ms), func() {
+				var code common.ConsumptionCode
+				if atMostOnce {
+					msg.Ack()
+					code = handler(ms, nil)
+				} else if code = handler(ms, nil); code == common.ConsumeNackTransientFailure {
+					msg.Nack()
+				} else {
+					msg.Ack()
+				}
+				if code == common.ConsumeAckFinal {
+					_ = sub.Unsubscribe()
+				}
+			})
 		}
 	}()
 	return sub, nil
 }
 
+// Close drains the connection: subscriptions stop taking messages, the messages they hold are
+// handled and the published ones flushed, within the drain timeout of the connection.
+func (m *MessageQueue) Close() error {
+	m.closing.Store(true)
+	if err := m.conn.Drain(); err != nil {
+		if errors.Is(err, nats.ErrConnectionClosed) {
+			return nil
+		}
+		return err
+	}
+	<-m.closed
+	return nil
+}
+
+// endOnClose ends sub once the connection closed: like the message queue stopping it after Close,
+// and with the error of the connection otherwise, which is passed to fail first.
+func (m *MessageQueue) endOnClose(subCtx context.Context, sub *subscription.CancelSubscription, fail func(err error)) {
+	go func() {
+		select {
+		case <-subCtx.Done():
+		case <-m.closed:
+			if m.closing.Load() {
+				sub.Close(qerrors.ErrSubscriptionClosed)
+				return
+			}
+			err := m.conn.LastError()
+			if err == nil {
+				err = nats.ErrConnectionClosed
+			}
+			if fail != nil {
+				fail(err)
+			}
+			sub.Close(err)
+		}
+	}()
+}
+
 func (m *MessageQueue) Publish(topic string, pOpts ...miface.PubOption) error {
 	if topic == "" {
 		return qerrors.ErrEmptyTopic
@@ -124,10 +251,19 @@ func (m *MessageQueue) Publish(topic string, pOpts ...miface.PubOption) error {
 
 	if options, err := miface.NewPubOptions(pOpts...); err != nil {
 		return err
-	} else if options.Delay != 0 {
+	} else if options.DelayFrom(time.Now()) > 0 {
 		return qerrors.ErrDelayedPublishUnsupported
 	} else {
-		msg := message.NewMessage(watermill.NewUUID(), options.Data)
+		if m.jetStream != nil {
+			if _, err := m.ensureStream(topic); err != nil {
+				return err
+			}
+		}
+		id := options.MessageId
+		if id == "" {
+			id = watermill.NewUUID()
+		}
+		msg := message2.Msg2WatermillMessage(id, options)
 		return m.publisher.Publish(topic, msg)
 	}
 }
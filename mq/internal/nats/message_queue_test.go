@@ -0,0 +1,144 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/gstones/moke-kit/mq/common"
+	"github.com/gstones/moke-kit/mq/internal/qerrors"
+	"github.com/gstones/moke-kit/mq/miface"
+)
+
+func TestQueueGroupSplitsMessages(t *testing.T) {
+	addr, shutdown := startTestNATS(t)
+	defer shutdown()
+
+	mq, err := NewMessageQueue(zap.NewNop(), addr)
+	if err != nil {
+		t.Fatalf("NewMessageQueue: %v", err)
+	}
+
+	var members [2]atomic.Int32
+	var broadcast atomic.Int32
+	for i := range members {
+		n := &members[i]
+		sub, err := mq.Subscribe(context.Background(), "orders", func(miface.Message, error) common.ConsumptionCode {
+			n.Add(1)
+			return common.ConsumeAck
+		}, miface.WithAtMostOnceDelivery("billing"))
+		if err != nil {
+			t.Fatalf("Subscribe: %v", err)
+		}
+		defer sub.Unsubscribe()
+	}
+	sub, err := mq.Subscribe(context.Background(), "orders", func(miface.Message, error) common.ConsumptionCode {
+		broadcast.Add(1)
+		return common.ConsumeAck
+	})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	const total = 20
+	for i := 0; i < total; i++ {
+		if err := mq.Publish("orders", miface.WithBytes([]byte("x"))); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+	waitFor(t, "every message", func() bool {
+		return broadcast.Load() == total && members[0].Load()+members[1].Load() == total
+	})
+	time.Sleep(100 * time.Millisecond)
+	if got := members[0].Load() + members[1].Load(); got != total {
+		t.Fatalf("queue group got %d of %d messages", got, total)
+	}
+}
+
+func TestCoreRejectsAtLeastOnce(t *testing.T) {
+	addr, shutdown := startTestNATS(t)
+	defer shutdown()
+
+	mq, err := NewMessageQueue(zap.NewNop(), addr)
+	if err != nil {
+		t.Fatalf("NewMessageQueue: %v", err)
+	}
+	_, err = mq.Subscribe(context.Background(), "orders", func(miface.Message, error) common.ConsumptionCode {
+		return common.ConsumeAck
+	}, miface.WithAtLeastOnceDelivery())
+	if err != qerrors.ErrSemanticsUnsupported {
+		t.Fatalf("expected ErrSemanticsUnsupported, got %v", err)
+	}
+}
+
+func TestPublishCarriesHeaders(t *testing.T) {
+	addr, shutdown := startTestNATS(t)
+	defer shutdown()
+
+	mq, err := NewMessageQueue(zap.NewNop(), addr)
+	if err != nil {
+		t.Fatalf("NewMessageQueue: %v", err)
+	}
+	got := make(chan miface.Message, 1)
+	sub, err := mq.Subscribe(context.Background(), "orders", func(msg miface.Message, _ error) common.ConsumptionCode {
+		got <- msg
+		return common.ConsumeAck
+	})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	if err := mq.Publish("orders", miface.WithJSON(1), miface.WithHeader("correlation-id", "c-1")); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	select {
+	case msg := <-got:
+		h := msg.Headers()
+		if h["correlation-id"] != "c-1" || h[common.HeaderContentType] != common.ContentTypeJSON || msg.Timestamp().IsZero() {
+			t.Fatalf("unexpected message: %v %v", h, msg.Timestamp())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the message")
+	}
+}
+
+func TestRequestReply(t *testing.T) {
+	addr, shutdown := startTestNATS(t)
+	defer shutdown()
+
+	mq, err := NewMessageQueue(zap.NewNop(), addr)
+	if err != nil {
+		t.Fatalf("NewMessageQueue: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if _, err := mq.Request(ctx, "price"); err != qerrors.ErrNoResponders {
+		t.Fatalf("expected ErrNoResponders, got %v", err)
+	}
+	sub, err := mq.Respond(context.Background(), "price", func(msg miface.Message) ([]byte, error) {
+		if msg.Headers()["item"] == "shield" {
+			return nil, errors.New("out of stock")
+		}
+		return []byte("42"), nil
+	}, miface.WithGroupId("shop"))
+	if err != nil {
+		t.Fatalf("Respond: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	reply, err := mq.Request(ctx, "price", miface.WithHeader("item", "sword"))
+	if err != nil || string(reply.Data()) != "42" || reply.Timestamp().IsZero() {
+		t.Fatalf("Request: %v, %v", reply, err)
+	}
+	reply, err = mq.Request(ctx, "price", miface.WithHeader("item", "shield"))
+	if err != nil || reply.Headers()[common.HeaderError] != "out of stock" {
+		t.Fatalf("expected the error reply, got %v, %v", reply, err)
+	}
+}
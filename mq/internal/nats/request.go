@@ -0,0 +1,122 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+
+	"github.com/gstones/moke-kit/mq/common"
+	message2 "github.com/gstones/moke-kit/mq/internal/message"
+	"github.com/gstones/moke-kit/mq/internal/qerrors"
+	"github.com/gstones/moke-kit/mq/internal/subscription"
+	"github.com/gstones/moke-kit/mq/miface"
+)
+
+// messageIDHeader carries the id of requests and replies, they bypass the watermill marshaler.
+const messageIDHeader = "message_id"
+
+// Request uses the request/reply of core nats, the reply comes back on an inbox of the connection.
+// Request topics should not be stored in JetStream streams, their publish acks would be taken for
+// replies.
+func (m *MessageQueue) Request(ctx context.Context, topic string, pOpts ...miface.PubOption) (miface.Message, error) {
+	if topic == "" {
+		return nil, qerrors.ErrEmptyTopic
+	}
+	topic = common.NamespaceTopic(topic)
+	options, err := miface.NewPubOptions(pOpts...)
+	if err != nil {
+		return nil, err
+	}
+	id := options.MessageId
+	if id == "" {
+		id = watermill.NewUUID()
+	}
+
+	reply, err := m.conn.RequestMsgWithContext(ctx, newMsg(topic, id, options.Data, options.Headers))
+	switch {
+	case errors.Is(err, nats.ErrNoResponders):
+		return nil, qerrors.ErrNoResponders
+	case errors.Is(err, nats.ErrTimeout):
+		return nil, context.DeadlineExceeded
+	case err != nil:
+		return nil, err
+	}
+	return fromMsg(topic, reply), nil
+}
+
+// Respond subscribes handler to topic, subscriptions sharing a group id form a queue group so each
+// request is answered once.
+func (m *MessageQueue) Respond(
+	ctx context.Context,
+	topic string,
+	handler miface.RequestHandler,
+	opts ...miface.SubOption,
+) (miface.Subscription, error) {
+	if topic == "" {
+		return nil, qerrors.ErrEmptyTopic
+	}
+	topic = common.NamespaceTopic(topic)
+	options, err := miface.NewSubOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	respond := func(msg *nats.Msg) {
+		data, err := handler(fromMsg(topic, msg))
+		if msg.Reply == "" {
+			return
+		}
+		var headers map[string]string
+		if err != nil {
+			data, headers = nil, map[string]string{common.HeaderError: err.Error()}
+		}
+		if err := msg.RespondMsg(newMsg(msg.Reply, watermill.NewUUID(), data, headers)); err != nil {
+			m.logger.Warn("nats reply failure", zap.String("topic", topic), zap.Error(err))
+		}
+	}
+	var ns *nats.Subscription
+	if options.GroupId != "" {
+		ns, err = m.conn.QueueSubscribe(topic, options.GroupId, respond)
+	} else {
+		ns, err = m.conn.Subscribe(topic, respond)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	subCtx, cancel := context.WithCancel(ctx)
+	sub := subscription.NewCancelSubscription(cancel)
+	m.endOnClose(subCtx, sub, nil)
+	go func() {
+		defer sub.Ended(ctx)
+		<-subCtx.Done()
+		if err := ns.Unsubscribe(); err != nil && !errors.Is(err, nats.ErrConnectionClosed) {
+			m.logger.Warn("nats unsubscribe failure", zap.String("topic", topic), zap.Error(err))
+		}
+	}()
+	return sub, nil
+}
+
+func newMsg(subject, id string, data []byte, headers map[string]string) *nats.Msg {
+	msg := nats.NewMsg(subject)
+	msg.Data = data
+	for k, v := range message2.Headers(headers, time.Now()) {
+		msg.Header.Set(k, v)
+	}
+	msg.Header.Set(messageIDHeader, id)
+	return msg
+}
+
+func fromMsg(topic string, msg *nats.Msg) miface.Message {
+	headers := make(map[string]string, len(msg.Header))
+	for k := range msg.Header {
+		if k != messageIDHeader {
+			headers[k] = msg.Header.Get(k)
+		}
+	}
+	return message2.NewMessage(msg.Header.Get(messageIDHeader), topic, msg.Data, nil, headers, message2.PublishedAt(headers))
+}
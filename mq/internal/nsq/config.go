@@ -0,0 +1,105 @@
+package nsq
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+	"go.uber.org/zap"
+
+	"github.com/gstones/moke-kit/mq/internal/qerrors"
+)
+
+// config is the connection part of an nsq url:
+//
+//	nsq://nsqd:4150[?lookupd=lookupd1:4161,lookupd2:4161&requeue_delay=1s&max_requeue_delay=1m&max_in_flight=1&max_attempts=0]
+//
+// Messages are published to nsqd. Consumers discover nsqd instances through lookupd when it is
+// set and connect to nsqd directly otherwise. A nacked message is requeued after requeue_delay
+// times its attempt count, capped by max_requeue_delay; max_attempts 0 retries forever.
+type config struct {
+	nsqd    string
+	lookupd []string
+	nsq     *nsq.Config
+}
+
+func parseURL(address string) (*config, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "nsq" || u.Host == "" {
+		return nil, qerrors.ErrInvalidNsqURL
+	}
+	c := &config{
+		nsqd: u.Host,
+		nsq:  nsq.NewConfig(),
+	}
+	c.nsq.DefaultRequeueDelay = time.Second
+	c.nsq.MaxRequeueDelay = time.Minute
+	c.nsq.MaxAttempts = 0
+
+	q := u.Query()
+	for _, l := range strings.Split(q.Get("lookupd"), ",") {
+		if l = strings.TrimSpace(l); l != "" {
+			c.lookupd = append(c.lookupd, l)
+		}
+	}
+	durations := map[string]*time.Duration{
+		"requeue_delay":     &c.nsq.DefaultRequeueDelay,
+		"max_requeue_delay": &c.nsq.MaxRequeueDelay,
+		"backoff":           &c.nsq.BackoffMultiplier,
+	}
+	for name, dst := range durations {
+		if v := q.Get(name); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return nil, qerrors.ErrInvalidNsqURL
+			}
+			*dst = d
+		}
+	}
+	if v := q.Get("max_in_flight"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, qerrors.ErrInvalidNsqURL
+		}
+		c.nsq.MaxInFlight = n
+	}
+	if v := q.Get("max_attempts"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 16)
+		if err != nil {
+			return nil, qerrors.ErrInvalidNsqURL
+		}
+		c.nsq.MaxAttempts = uint16(n)
+	}
+	if err := c.nsq.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// zapLogger adapts zap to the go-nsq logger, whose lines start with their level. The errors are
+// passed to onError too when it is set.
+type zapLogger struct {
+	logger  *zap.Logger
+	onError func(err error)
+}
+
+func (z zapLogger) Output(_ int, s string) error {
+	switch {
+	case strings.HasPrefix(s, "ERR"):
+		z.logger.Error(s)
+		if z.onError != nil {
+			z.onError(errors.New(strings.TrimSpace(strings.TrimPrefix(s, "ERR"))))
+		}
+	case strings.HasPrefix(s, "WRN"):
+		z.logger.Warn(s)
+	default:
+		z.logger.Debug(s)
+	}
+	return nil
+}
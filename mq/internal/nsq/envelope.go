@@ -0,0 +1,41 @@
+package nsq
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+)
+
+// envelopeMagic starts the body of the messages mq publishes, nsq messages have no headers of
+// their own so they travel in front of the data. Bodies without it are read as bare data.
+var envelopeMagic = []byte("\x00mqh1")
+
+// encode prefixes data with the magic, the length of the json encoded headers and the headers.
+func encode(headers map[string]string, data []byte) ([]byte, error) {
+	h, err := json.Marshal(headers)
+	if err != nil {
+		return nil, err
+	}
+	body := make([]byte, 0, len(envelopeMagic)+binary.MaxVarintLen64+len(h)+len(data))
+	body = append(body, envelopeMagic...)
+	body = binary.AppendUvarint(body, uint64(len(h)))
+	body = append(body, h...)
+	return append(body, data...), nil
+}
+
+// decode splits a body into its headers and data, a body that is not a valid envelope is all data.
+func decode(body []byte) (map[string]string, []byte) {
+	if !bytes.HasPrefix(body, envelopeMagic) {
+		return nil, body
+	}
+	rest := body[len(envelopeMagic):]
+	n, size := binary.Uvarint(rest)
+	if size <= 0 || n > uint64(len(rest)-size) {
+		return nil, body
+	}
+	var headers map[string]string
+	if err := json.Unmarshal(rest[size:size+int(n)], &headers); err != nil {
+		return nil, body
+	}
+	return headers, rest[size+int(n):]
+}
@@ -0,0 +1,185 @@
+package nsq
+
+import (
+	"context"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/nsqio/go-nsq"
+	"go.uber.org/zap"
+
+	"github.com/gstones/moke-kit/mq/common"
+	message2 "github.com/gstones/moke-kit/mq/internal/message"
+	"github.com/gstones/moke-kit/mq/internal/pool"
+	"github.com/gstones/moke-kit/mq/internal/qerrors"
+	"github.com/gstones/moke-kit/mq/internal/subscription"
+	"github.com/gstones/moke-kit/mq/miface"
+)
+
+// ephemeralSuffix marks channels nsqd deletes once their last consumer left.
+const ephemeralSuffix = "#ephemeral"
+
+// MessageQueue publishes to an nsqd and consumes through channels: subscriptions with a group
+// id share the channel of that name, so the replicas using it split the messages; without one
+// every subscription gets its own ephemeral channel receiving every message.
+//
+// At-least-once subscriptions, the default, finish a message after its handler acked it and
+// requeue nacked messages with backoff, or after the delay of their retry policy, capped by
+// max_requeue_delay, and with the attempts counted by nsqd. At-most-once subscriptions finish a message before
+// handling it. WithDelay publishes use nsqd deferred publishing.
+type MessageQueue struct {
+	logger   *zap.Logger
+	config   *config
+	producer *nsq.Producer
+}
+
+// NewMessageQueue creates an nsq message queue for an nsq:// url, see parseURL.
+func NewMessageQueue(logger *zap.Logger, address string) (*MessageQueue, error) {
+	c, err := parseURL(address)
+	if err != nil {
+		return nil, err
+	}
+	producer, err := nsq.NewProducer(c.nsqd, c.nsq)
+	if err != nil {
+		return nil, err
+	}
+	producer.SetLogger(zapLogger{logger: logger}, nsq.LogLevelWarning)
+	return &MessageQueue{
+		logger:   logger,
+		config:   c,
+		producer: producer,
+	}, nil
+}
+
+func (m *MessageQueue) Subscribe(
+	ctx context.Context,
+	topic string,
+	handler miface.SubResponseHandler,
+	opts ...miface.SubOption,
+) (miface.Subscription, error) {
+	if topic == "" {
+		return nil, qerrors.ErrEmptyTopic
+	}
+	topic = common.NamespaceTopic(topic)
+	options, err := miface.NewSubOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	channel := options.GroupId
+	if channel == "" {
+		channel = watermill.NewShortUUID() + ephemeralSuffix
+	}
+
+	cfg := m.config.nsq
+	if options.Concurrency > cfg.MaxInFlight {
+		// nsqd sends no more than max in flight unfinished messages to a consumer
+		c := *cfg
+		c.MaxInFlight = options.Concurrency
+		cfg = &c
+	}
+	consumer, err := nsq.NewConsumer(topic, channel, cfg)
+	if err != nil {
+		return nil, err
+	}
+	subCtx, cancel := context.WithCancel(ctx)
+	sub := subscription.NewCancelSubscription(cancel)
+	// the consumer reconnects by itself, its errors are told to the handler without ending sub
+	consumer.SetLogger(zapLogger{logger: m.logger, onError: func(err error) {
+		if sub.IsValid() {
+			handler(nil, err)
+		}
+	}}, nsq.LogLevelWarning)
+	atMostOnce := options.DeliverySemantics == common.AtMostOnce
+	p := pool.ForSubscription(options)
+	consumer.AddHandler(nsq.HandlerFunc(func(msg *nsq.Message) error {
+		msg.DisableAutoResponse()
+		if atMostOnce {
+			msg.Finish()
+		}
+		headers, data := decode(msg.Body)
+		publishedAt := message2.PublishedAt(headers)
+		if publishedAt.IsZero() {
+			publishedAt = time.Unix(0, msg.Timestamp)
+		}
+		ms := message2.WithContext(message2.NewMessage(string(msg.ID[:]), topic, data, nil, headers, publishedAt), subCtx)
+		// nsqd counts the deliveries, a retry policy requeues with its delay instead of in place
+		requeueDelay := time.Duration(-1)
+		if retry := options.Retry; retry.MaxAttempts > 0 && !atMostOnce {
+			ms = message2.WithDelivery(ms, int(msg.Attempts))
+			requeueDelay = min(retry.Delay(int(msg.Attempts)), m.config.nsq.MaxRequeueDelay)
+		}
+		p.Submit(options.Key(ms), func() {
+			code := handler(ms, nil)
+			if code == common.ConsumeNackTransientFailure && !atMostOnce {
+				msg.Requeue(requeueDelay)
+			} else if !atMostOnce {
+				msg.Finish()
+			}
+			if code == common.ConsumeAckFinal {
+				_ = sub.Unsubscribe()
+			}
+		})
+		return nil
+	}))
+
+	if len(m.config.lookupd) > 0 {
+		err = consumer.ConnectToNSQLookupds(m.config.lookupd)
+	} else {
+		err = consumer.ConnectToNSQD(m.config.nsqd)
+	}
+	if err != nil {
+		cancel()
+		consumer.Stop()
+		p.Close()
+		return nil, err
+	}
+
+	go func() {
+		defer sub.Ended(ctx)
+		// the consumer stopped its handler, which submits to p, before closing StopChan
+		defer p.Close()
+		select {
+		case <-subCtx.Done():
+			consumer.Stop()
+			<-consumer.StopChan
+		case <-consumer.StopChan:
+			if subCtx.Err() == nil {
+				handler(nil, qerrors.ErrSubscriptionClosed)
+				sub.Close(qerrors.ErrSubscriptionClosed)
+			}
+		}
+	}()
+	return sub, nil
+}
+
+func (m *MessageQueue) Publish(topic string, pOpts ...miface.PubOption) error {
+	if topic == "" {
+		return qerrors.ErrEmptyTopic
+	}
+	topic = common.NamespaceTopic(topic)
+
+	now := time.Now()
+	if options, err := miface.NewPubOptions(pOpts...); err != nil {
+		return err
+	} else if body, err := encode(message2.Headers(options.Headers, now), options.Data); err != nil {
+		return err
+	} else if delay := options.DelayFrom(now); delay > 0 {
+		return m.producer.DeferredPublish(topic, delay, body)
+	} else {
+		return m.producer.Publish(topic, body)
+	}
+}
+
+// Close stops the producer, subscriptions stop their own consumers.
+func (m *MessageQueue) Close() {
+	m.producer.Stop()
+}
+
+// Request is not supported, there is no way to address a reply to the requester.
+func (m *MessageQueue) Request(context.Context, string, ...miface.PubOption) (miface.Message, error) {
+	return nil, qerrors.ErrRequestUnsupported
+}
+
+func (m *MessageQueue) Respond(context.Context, string, miface.RequestHandler, ...miface.SubOption) (miface.Subscription, error) {
+	return nil, qerrors.ErrRequestUnsupported
+}
@@ -0,0 +1,411 @@
+package nsq
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/gstones/moke-kit/mq/common"
+	"github.com/gstones/moke-kit/mq/miface"
+)
+
+const (
+	frameTypeResponse int32 = 0
+	frameTypeMessage  int32 = 2
+)
+
+type fakeMessage struct {
+	id       [16]byte
+	attempts uint16
+	body     []byte
+}
+
+// fakeChannel queues the messages of one channel, its subscribers take turns reading them.
+type fakeChannel struct {
+	queue    chan *fakeMessage
+	inFlight sync.Map // id -> *fakeMessage
+}
+
+// fakeNsqd speaks enough of the nsqd tcp protocol for one producer and a few consumers.
+type fakeNsqd struct {
+	t        *testing.T
+	listener net.Listener
+	mu       sync.Mutex
+	topics   map[string]map[string]*fakeChannel
+	nextID   atomic.Uint64
+}
+
+func newFakeNsqd(t *testing.T) *fakeNsqd {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	d := &fakeNsqd{t: t, listener: l, topics: map[string]map[string]*fakeChannel{}}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go d.serve(conn)
+		}
+	}()
+	return d
+}
+
+func (d *fakeNsqd) url(query string) string {
+	return fmt.Sprintf("nsq://%s?%s", d.listener.Addr(), query)
+}
+
+func (d *fakeNsqd) channel(topic, name string) *fakeChannel {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	channels, ok := d.topics[topic]
+	if !ok {
+		channels = map[string]*fakeChannel{}
+		d.topics[topic] = channels
+	}
+	c, ok := channels[name]
+	if !ok {
+		c = &fakeChannel{queue: make(chan *fakeMessage, 100)}
+		channels[name] = c
+	}
+	return c
+}
+
+func (d *fakeNsqd) channelNames(topic string) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var names []string
+	for name := range d.topics[topic] {
+		names = append(names, name)
+	}
+	return names
+}
+
+func (d *fakeNsqd) publish(topic string, body []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, c := range d.topics[topic] {
+		m := &fakeMessage{body: body}
+		copy(m.id[:], fmt.Sprintf("%016x", d.nextID.Add(1)))
+		c.queue <- m
+	}
+}
+
+func (d *fakeNsqd) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != "  V2" {
+		return
+	}
+
+	var writeMu sync.Mutex
+	write := func(frameType int32, data []byte) {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		buf := make([]byte, 8, 8+len(data))
+		binary.BigEndian.PutUint32(buf, uint32(4+len(data)))
+		binary.BigEndian.PutUint32(buf[4:], uint32(frameType))
+		_, _ = conn.Write(append(buf, data...))
+	}
+	readBody := func() []byte {
+		var size int32
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			return nil
+		}
+		body := make([]byte, size)
+		_, _ = io.ReadFull(r, body)
+		return body
+	}
+
+	var rdy atomic.Int64
+	var sub *fakeChannel
+	done := make(chan struct{})
+	defer close(done)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		params := strings.Fields(line)
+		switch params[0] {
+		case "IDENTIFY":
+			readBody()
+			write(frameTypeResponse, []byte("OK"))
+		case "PUB":
+			d.publish(params[1], readBody())
+			write(frameTypeResponse, []byte("OK"))
+		case "DPUB":
+			ms, _ := strconv.Atoi(params[2])
+			body := readBody()
+			time.AfterFunc(time.Duration(ms)*time.Millisecond, func() { d.publish(params[1], body) })
+			write(frameTypeResponse, []byte("OK"))
+		case "SUB":
+			sub = d.channel(params[1], params[2])
+			write(frameTypeResponse, []byte("OK"))
+			go func(c *fakeChannel) {
+				for {
+					if rdy.Load() == 0 {
+						select {
+						case <-done:
+							return
+						case <-time.After(time.Millisecond):
+						}
+						continue
+					}
+					select {
+					case <-done:
+						return
+					case m := <-c.queue:
+						m.attempts++
+						c.inFlight.Store(m.id, m)
+						data := make([]byte, 26, 26+len(m.body))
+						binary.BigEndian.PutUint64(data, uint64(time.Now().UnixNano()))
+						binary.BigEndian.PutUint16(data[8:], m.attempts)
+						copy(data[10:], m.id[:])
+						write(frameTypeMessage, append(data, m.body...))
+					}
+				}
+			}(sub)
+		case "RDY":
+			n, _ := strconv.ParseInt(params[1], 10, 64)
+			rdy.Store(n)
+		case "FIN":
+			var id [16]byte
+			copy(id[:], params[1])
+			sub.inFlight.Delete(id)
+		case "REQ":
+			var id [16]byte
+			copy(id[:], params[1])
+			if m, ok := sub.inFlight.LoadAndDelete(id); ok {
+				sub.queue <- m.(*fakeMessage)
+			}
+		case "CLS":
+			write(frameTypeResponse, []byte("CLOSE_WAIT"))
+			return
+		case "TOUCH", "NOP":
+		default:
+			d.t.Errorf("unexpected command %q", line)
+			return
+		}
+	}
+}
+
+type received struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (r *received) add(s string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.msgs = append(r.msgs, s)
+}
+
+func (r *received) len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.msgs)
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func newTestQueue(t *testing.T, d *fakeNsqd) *MessageQueue {
+	mq, err := NewMessageQueue(zap.NewNop(), d.url("requeue_delay=10ms&backoff=10ms"))
+	if err != nil {
+		t.Fatalf("NewMessageQueue: %v", err)
+	}
+	t.Cleanup(mq.Close)
+	return mq
+}
+
+func TestParseURL(t *testing.T) {
+	c, err := parseURL("nsq://nsqd:4150?lookupd=l1:4161,l2:4161&requeue_delay=2s&max_in_flight=8&max_attempts=5")
+	if err != nil {
+		t.Fatalf("parseURL: %v", err)
+	}
+	if c.nsqd != "nsqd:4150" || len(c.lookupd) != 2 || c.lookupd[1] != "l2:4161" {
+		t.Fatalf("unexpected addresses: %+v", c)
+	}
+	if c.nsq.DefaultRequeueDelay != 2*time.Second || c.nsq.MaxInFlight != 8 || c.nsq.MaxAttempts != 5 {
+		t.Fatalf("unexpected config: %+v", c.nsq)
+	}
+
+	for _, raw := range []string{
+		"kafka://localhost:9092",
+		"nsq://",
+		"nsq://nsqd:4150?requeue_delay=soon",
+		"nsq://nsqd:4150?max_in_flight=0",
+		"nsq://nsqd:4150?max_attempts=-1",
+	} {
+		if _, err := parseURL(raw); err == nil {
+			t.Fatalf("expected error for %q", raw)
+		}
+	}
+}
+
+func TestLoggerPassesErrors(t *testing.T) {
+	var errs []error
+	l := zapLogger{logger: zap.NewNop(), onError: func(err error) { errs = append(errs, err) }}
+	_ = l.Output(2, "WRN    1 [orders/billing] backing off")
+	_ = l.Output(2, "ERR    1 [orders/billing] (nsqd:4150) IO error - EOF")
+	if len(errs) != 1 || errs[0].Error() != "1 [orders/billing] (nsqd:4150) IO error - EOF" {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+}
+
+func TestSubscribeChannels(t *testing.T) {
+	d := newFakeNsqd(t)
+	mq := newTestQueue(t, d)
+
+	var broadcast [2]received
+	var group received
+	for i := range broadcast {
+		r := &broadcast[i]
+		sub, err := mq.Subscribe(context.Background(), "orders", func(msg miface.Message, _ error) common.ConsumptionCode {
+			r.add(string(msg.Data()))
+			return common.ConsumeAck
+		})
+		if err != nil {
+			t.Fatalf("Subscribe: %v", err)
+		}
+		defer sub.Unsubscribe()
+	}
+	for i := 0; i < 2; i++ {
+		sub, err := mq.Subscribe(context.Background(), "orders", func(msg miface.Message, _ error) common.ConsumptionCode {
+			group.add(string(msg.Data()))
+			return common.ConsumeAck
+		}, miface.WithAtMostOnceDelivery("billing"))
+		if err != nil {
+			t.Fatalf("Subscribe: %v", err)
+		}
+		defer sub.Unsubscribe()
+	}
+
+	topic := common.NamespaceTopic("orders")
+	names := d.channelNames(topic)
+	ephemeral := 0
+	for _, name := range names {
+		if strings.HasSuffix(name, ephemeralSuffix) {
+			ephemeral++
+		}
+	}
+	if len(names) != 3 || ephemeral != 2 {
+		t.Fatalf("expected two ephemeral channels and the billing one: %v", names)
+	}
+
+	for _, v := range []string{"a", "b", "c"} {
+		if err := mq.Publish("orders", miface.WithBytes([]byte(v))); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+	waitFor(t, "every message", func() bool {
+		return broadcast[0].len() == 3 && broadcast[1].len() == 3 && group.len() == 3
+	})
+	time.Sleep(50 * time.Millisecond)
+	if group.len() != 3 {
+		t.Fatalf("billing group got %v, expected each message once", group.msgs)
+	}
+}
+
+func TestSubscribeRequeuesTransientFailures(t *testing.T) {
+	d := newFakeNsqd(t)
+	mq := newTestQueue(t, d)
+
+	var attempts atomic.Int32
+	var got received
+	sub, err := mq.Subscribe(context.Background(), "orders", func(msg miface.Message, _ error) common.ConsumptionCode {
+		if attempts.Add(1) == 1 {
+			return common.ConsumeNackTransientFailure
+		}
+		got.add(string(msg.Data()))
+		return common.ConsumeAck
+	})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	if err := mq.Publish("orders", miface.WithBytes([]byte("a"))); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	waitFor(t, "redelivery", func() bool { return got.len() == 1 })
+	if attempts.Load() != 2 {
+		t.Fatalf("expected two attempts, got %d", attempts.Load())
+	}
+
+	if err := sub.Unsubscribe(); err != nil {
+		t.Fatalf("Unsubscribe: %v", err)
+	}
+	waitFor(t, "subscription invalid", func() bool { return !sub.IsValid() })
+}
+
+func TestPublishDeferred(t *testing.T) {
+	d := newFakeNsqd(t)
+	mq := newTestQueue(t, d)
+
+	arrived := make(chan time.Time, 1)
+	sub, err := mq.Subscribe(context.Background(), "orders", func(msg miface.Message, _ error) common.ConsumptionCode {
+		if !bytes.Equal(msg.Data(), []byte("later")) {
+			t.Errorf("unexpected message %q", msg.Data())
+		}
+		arrived <- time.Now()
+		return common.ConsumeAck
+	})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	start := time.Now()
+	if err := mq.Publish("orders", miface.WithBytes([]byte("later")), miface.WithDelay(100*time.Millisecond)); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	select {
+	case at := <-arrived:
+		if at.Sub(start) < 100*time.Millisecond {
+			t.Fatalf("deferred message arrived after %v", at.Sub(start))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("deferred message never arrived")
+	}
+}
+
+func TestEnvelope(t *testing.T) {
+	body, err := encode(map[string]string{"correlation-id": "c-1"}, []byte("data"))
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	if headers, data := decode(body); headers["correlation-id"] != "c-1" || string(data) != "data" {
+		t.Fatalf("decode: %v %q", headers, data)
+	}
+	for _, raw := range [][]byte{[]byte("plain"), append(append([]byte{}, envelopeMagic...), 0xff)} {
+		if headers, data := decode(raw); headers != nil || !bytes.Equal(data, raw) {
+			t.Fatalf("decode(%q): %v %q", raw, headers, data)
+		}
+	}
+}
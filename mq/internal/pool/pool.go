@@ -0,0 +1,70 @@
+package pool
+
+import (
+	"hash/fnv"
+	"sync"
+
+	"github.com/gstones/moke-kit/mq/miface"
+)
+
+// Pool runs the messages of a subscription on a fixed number of workers. Submit waits for a
+// worker to take the task, so a busy pool stops the subscription from pulling more messages.
+type Pool struct {
+	queues []chan func()
+	wg     sync.WaitGroup
+}
+
+// New creates a pool of n workers. With ordered set, tasks submitted with the same key run one
+// after another in submission order, each key always going to the same worker; otherwise any free
+// worker takes the next task. A pool of one worker or less runs tasks in Submit.
+func New(n int, ordered bool) *Pool {
+	p := &Pool{}
+	if n <= 1 {
+		return p
+	}
+	queues := 1
+	if ordered {
+		queues = n
+	}
+	for i := 0; i < queues; i++ {
+		p.queues = append(p.queues, make(chan func()))
+	}
+	p.wg.Add(n)
+	for i := 0; i < n; i++ {
+		q := p.queues[i%queues]
+		go func() {
+			defer p.wg.Done()
+			for task := range q {
+				task()
+			}
+		}()
+	}
+	return p
+}
+
+// Submit runs task on a worker, the one of key in an ordered pool.
+func (p *Pool) Submit(key string, task func()) {
+	switch len(p.queues) {
+	case 0:
+		task()
+	case 1:
+		p.queues[0] <- task
+	default:
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(key))
+		p.queues[h.Sum32()%uint32(len(p.queues))] <- task
+	}
+}
+
+// Close waits for the submitted tasks to finish, nothing may be submitted afterwards.
+func (p *Pool) Close() {
+	for _, q := range p.queues {
+		close(q)
+	}
+	p.wg.Wait()
+}
+
+// ForSubscription creates the pool of a subscription with options.
+func ForSubscription(options miface.SubOptions) *Pool {
+	return New(options.Concurrency, options.OrderingKey != nil)
+}
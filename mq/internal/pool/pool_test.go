@@ -0,0 +1,71 @@
+package pool
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPoolBoundsConcurrency(t *testing.T) {
+	p := New(3, false)
+	var running, peak atomic.Int32
+	for i := 0; i < 12; i++ {
+		p.Submit("", func() {
+			n := running.Add(1)
+			for {
+				old := peak.Load()
+				if n <= old || peak.CompareAndSwap(old, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			running.Add(-1)
+		})
+	}
+	p.Close()
+	if running.Load() != 0 {
+		t.Fatalf("Close returned with %d tasks running", running.Load())
+	}
+	if peak.Load() < 2 || peak.Load() > 3 {
+		t.Fatalf("peak concurrency=%d want 2..3", peak.Load())
+	}
+}
+
+func TestPoolOrdersByKey(t *testing.T) {
+	p := New(4, true)
+	var mu sync.Mutex
+	got := map[string][]int{}
+	for i := 0; i < 40; i++ {
+		key := strconv.Itoa(i % 5)
+		i := i
+		p.Submit(key, func() {
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			got[key] = append(got[key], i)
+			mu.Unlock()
+		})
+	}
+	p.Close()
+	for key, seq := range got {
+		for j := 1; j < len(seq); j++ {
+			if seq[j] < seq[j-1] {
+				t.Fatalf("key %s handled out of order: %v", key, seq)
+			}
+		}
+	}
+	if len(got) != 5 {
+		t.Fatalf("keys=%d want 5", len(got))
+	}
+}
+
+func TestPoolOfOneRunsInSubmit(t *testing.T) {
+	p := New(1, true)
+	ran := false
+	p.Submit("k", func() { ran = true })
+	if !ran {
+		t.Fatal("expected the task to run in Submit")
+	}
+	p.Close()
+}
@@ -21,12 +21,40 @@ var (
 	ErrNoLocalQueue = errors.New("ErrNoLocalQueue")
 	// ErrInvalidSubscription invalid Subscription
 	ErrInvalidSubscription = errors.New("ErrInvalidSubscription")
+	// ErrSubscriptionClosed the message queue ended the subscription, e.g. on a lost connection
+	ErrSubscriptionClosed = errors.New("ErrSubscriptionClosed")
 	// ErrDataAlreadySet data payload already set for PubOptions object
 	ErrDataAlreadySet = errors.New("ErrDataAlreadySet")
 	// ErrEmptyTopic empty topic value passed in as argument
 	ErrEmptyTopic = errors.New("ErrEmptyTopic")
 	// ErrSemanticsAlreadySet delivery semantics already set
 	ErrSemanticsAlreadySet = errors.New("ErrSemanticsAlreadySet")
+	// ErrSemanticsUnsupported delivery semantics not supported by the mq implementation
+	ErrSemanticsUnsupported = errors.New("ErrSemanticsUnsupported")
 	// ErrDelayedPublishUnsupported Delayed publishing not supported.
 	ErrDelayedPublishUnsupported = errors.New("ErrDelayedPublishUnsupported")
+	// ErrScheduledMessageNotFound no pending scheduled message with that id
+	ErrScheduledMessageNotFound = errors.New("ErrScheduledMessageNotFound")
+	// ErrSchedulerStoreUnsupported unknown scheduler store, or its client was not provided
+	ErrSchedulerStoreUnsupported = errors.New("ErrSchedulerStoreUnsupported")
+	// ErrRequestUnsupported request/reply not supported by the mq implementation
+	ErrRequestUnsupported = errors.New("ErrRequestUnsupported")
+	// ErrNoResponders no responder subscribed to the topic of a request
+	ErrNoResponders = errors.New("ErrNoResponders")
+	// ErrRequestTimeout no reply received before the request deadline
+	ErrRequestTimeout = errors.New("ErrRequestTimeout")
+	// ErrRequestFailure the responder failed the request, the error carries its message
+	ErrRequestFailure = errors.New("ErrRequestFailure")
+	// ErrRequestUnhandled the middlewares of the responder stopped the request, e.g. recovering a panic
+	ErrRequestUnhandled = errors.New("ErrRequestUnhandled")
+	// ErrUnknownContentType no codec registered for the content type of a message
+	ErrUnknownContentType = errors.New("ErrUnknownContentType")
+	// ErrNotProtoMessage the protobuf codec was given a value that is not a proto.Message
+	ErrNotProtoMessage = errors.New("ErrNotProtoMessage")
+	// ErrConcurrencyUnsupported the subscription cannot be concurrent with the mq implementation
+	ErrConcurrencyUnsupported = errors.New("ErrConcurrencyUnsupported")
+	// ErrInvalidKafkaURL kafka url could not be parsed
+	ErrInvalidKafkaURL = errors.New("ErrInvalidKafkaURL")
+	// ErrInvalidNsqURL nsq url could not be parsed
+	ErrInvalidNsqURL = errors.New("ErrInvalidNsqURL")
 )
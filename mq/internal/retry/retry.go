@@ -0,0 +1,125 @@
+package retry
+
+import (
+	"context"
+	"maps"
+	"strconv"
+	"time"
+
+	"github.com/gstones/moke-kit/mq/common"
+	"github.com/gstones/moke-kit/mq/internal/message"
+	"github.com/gstones/moke-kit/mq/miface"
+)
+
+// PublishFunc publishes a dead letter, usually the Publish of the mq routing by topic scheme.
+type PublishFunc func(topic string, opts ...miface.PubOption) error
+
+// Handler applies the retry policy and the dead-letter topic of options to handler, subscribed to
+// topic. It returns handler itself when options have neither.
+//
+// Transient failures are retried until the policy runs out of attempts, the message is then
+// dead-lettered like the persistent failures. The messages of mq implementations counting their
+// deliveries (see message.WithDelivery) are nacked and redelivered after the delay of the policy, so
+// their attempts add up across redeliveries and restarts. The others are retried in place, waiting
+// in the context of the message, which the mq implementations end with the subscription; the retries
+// start over when such a message is redelivered.
+//
+// A dead-lettered message is acked once published, and nacked for a redelivery by the mq
+// implementation when its publishing failed. Without a dead-letter topic the failed message is dropped.
+func Handler(
+	publish PublishFunc,
+	topic string,
+	options miface.SubOptions,
+	handler miface.SubResponseHandler,
+) miface.SubResponseHandler {
+	policy, deadLetterTopic := options.Retry, options.DeadLetterTopic
+	if policy.MaxAttempts <= 0 && deadLetterTopic == "" {
+		return handler
+	}
+
+	deadLetter := func(msg miface.Message, reason string, attempts int) common.ConsumptionCode {
+		if deadLetterTopic == "" {
+			return common.ConsumeNackPersistentFailure
+		}
+		headers := maps.Clone(msg.Headers())
+		delete(headers, common.HeaderPublishedAt)
+		err := publish(deadLetterTopic,
+			miface.WithBytes(msg.Data()),
+			miface.WithMessageId(msg.ID()),
+			miface.WithHeaders(headers),
+			miface.WithHeader(common.HeaderDeadLetterTopic, topic),
+			miface.WithHeader(common.HeaderDeadLetterReason, reason),
+			miface.WithHeader(common.HeaderAttempt, strconv.Itoa(attempts)),
+		)
+		if err != nil {
+			return common.ConsumeNackTransientFailure
+		}
+		return common.ConsumeAck
+	}
+
+	return func(msg miface.Message, err error) common.ConsumptionCode {
+		if msg == nil {
+			return handler(msg, err)
+		}
+		if delivery := message.Delivery(msg); delivery > 0 {
+			switch code := handler(withAttempt(msg, delivery), err); code {
+			case common.ConsumeNackPersistentFailure:
+				return deadLetter(msg, common.DeadLetterPersistentFailure, delivery)
+			case common.ConsumeNackTransientFailure:
+				if delivery >= policy.MaxAttempts {
+					return deadLetter(msg, common.DeadLetterRetriesExhausted, delivery)
+				}
+				return code
+			default:
+				return code
+			}
+		}
+		for attempt := 1; ; attempt++ {
+			m := msg
+			if policy.MaxAttempts > 0 {
+				m = withAttempt(msg, attempt)
+			}
+
+			switch code := handler(m, err); code {
+			case common.ConsumeNackPersistentFailure:
+				return deadLetter(msg, common.DeadLetterPersistentFailure, attempt)
+			case common.ConsumeNackTransientFailure:
+				if policy.MaxAttempts <= 0 {
+					return code
+				}
+				if attempt >= policy.MaxAttempts {
+					return deadLetter(msg, common.DeadLetterRetriesExhausted, attempt)
+				}
+			default:
+				return code
+			}
+
+			if !wait(msg.Context(), policy.Delay(attempt)) {
+				// left to the redelivery of the mq implementation
+				return common.ConsumeNackTransientFailure
+			}
+		}
+	}
+}
+
+// withAttempt returns msg with the attempt header.
+func withAttempt(msg miface.Message, attempt int) miface.Message {
+	headers := maps.Clone(msg.Headers())
+	if headers == nil {
+		headers = map[string]string{}
+	}
+	headers[common.HeaderAttempt] = strconv.Itoa(attempt)
+	return message.WithHeaders(msg, headers)
+}
+
+// wait waits for delay, it reports false if ctx ended first.
+func wait(ctx context.Context, delay time.Duration) bool {
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
@@ -0,0 +1,151 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gstones/moke-kit/mq/common"
+	"github.com/gstones/moke-kit/mq/internal/message"
+	"github.com/gstones/moke-kit/mq/miface"
+)
+
+type deadLetters struct {
+	topics []string
+	opts   []miface.PubOptions
+	fail   bool
+}
+
+func (d *deadLetters) publish(topic string, opts ...miface.PubOption) error {
+	if d.fail {
+		return errors.New("unavailable")
+	}
+	o, err := miface.NewPubOptions(opts...)
+	if err != nil {
+		return err
+	}
+	d.topics = append(d.topics, topic)
+	d.opts = append(d.opts, o)
+	return nil
+}
+
+func failing(code common.ConsumptionCode, attempts *[]string) miface.SubResponseHandler {
+	return func(msg miface.Message, _ error) common.ConsumptionCode {
+		*attempts = append(*attempts, msg.Headers()[common.HeaderAttempt])
+		return code
+	}
+}
+
+func newMsg() miface.Message {
+	return message.NewMessage("m-1", "orders", []byte("x"), nil, map[string]string{"correlation-id": "c-1"}, time.Now())
+}
+
+func TestRetriesThenDeadLetters(t *testing.T) {
+	var d deadLetters
+	var attempts []string
+	options := miface.SubOptions{
+		Retry:           miface.RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond},
+		DeadLetterTopic: "local://orders.dlq",
+	}
+	h := Handler(d.publish, "local://orders", options,
+		failing(common.ConsumeNackTransientFailure, &attempts))
+
+	if code := h(newMsg(), nil); code != common.ConsumeAck {
+		t.Fatalf("expected the dead-lettered message acked, got %v", code)
+	}
+	if len(attempts) != 3 || attempts[0] != "1" || attempts[2] != "3" {
+		t.Fatalf("unexpected attempts %v", attempts)
+	}
+	if len(d.topics) != 1 || d.topics[0] != "local://orders.dlq" {
+		t.Fatalf("unexpected dead letters %v", d.topics)
+	}
+	o := d.opts[0]
+	if string(o.Data) != "x" || o.MessageId != "m-1" || o.Headers["correlation-id"] != "c-1" ||
+		o.Headers[common.HeaderDeadLetterTopic] != "local://orders" ||
+		o.Headers[common.HeaderDeadLetterReason] != common.DeadLetterRetriesExhausted ||
+		o.Headers[common.HeaderAttempt] != "3" {
+		t.Fatalf("unexpected dead letter %+v", o)
+	}
+
+	d.fail = true
+	if code := h(newMsg(), nil); code != common.ConsumeNackTransientFailure {
+		t.Fatalf("expected a redelivery when dead-lettering failed, got %v", code)
+	}
+}
+
+func TestPersistentFailureDeadLettersAtOnce(t *testing.T) {
+	var d deadLetters
+	var attempts []string
+	h := Handler(d.publish, "local://orders",
+		miface.SubOptions{DeadLetterTopic: "local://orders.dlq"},
+		failing(common.ConsumeNackPersistentFailure, &attempts))
+
+	if code := h(newMsg(), nil); code != common.ConsumeAck || len(attempts) != 1 {
+		t.Fatalf("unexpected code %v after %d attempts", code, len(attempts))
+	}
+	if len(d.opts) != 1 || d.opts[0].Headers[common.HeaderDeadLetterReason] != common.DeadLetterPersistentFailure {
+		t.Fatalf("unexpected dead letters %+v", d.opts)
+	}
+
+	// without a retry policy transient failures are left to the mq implementation
+	h = Handler(d.publish, "local://orders",
+		miface.SubOptions{DeadLetterTopic: "local://orders.dlq"},
+		failing(common.ConsumeNackTransientFailure, &attempts))
+	if code := h(newMsg(), nil); code != common.ConsumeNackTransientFailure || len(d.opts) != 1 {
+		t.Fatalf("unexpected code %v", code)
+	}
+}
+
+func TestRetryStopsWithContext(t *testing.T) {
+	var d deadLetters
+	var attempts []string
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	h := Handler(d.publish, "local://orders",
+		miface.SubOptions{Retry: miface.RetryPolicy{MaxAttempts: 5, Backoff: time.Hour}},
+		failing(common.ConsumeNackTransientFailure, &attempts))
+	if code := h(message.WithContext(newMsg(), ctx), nil); code != common.ConsumeNackTransientFailure || len(attempts) != 1 {
+		t.Fatalf("unexpected code %v after %d attempts", code, len(attempts))
+	}
+}
+
+func TestRedeliveriesCountAttempts(t *testing.T) {
+	var d deadLetters
+	var attempts []string
+	options := miface.SubOptions{
+		Retry:           miface.RetryPolicy{MaxAttempts: 3, Backoff: time.Hour},
+		DeadLetterTopic: "local://orders.dlq",
+	}
+	h := Handler(d.publish, "local://orders", options, failing(common.ConsumeNackTransientFailure, &attempts))
+
+	// the mq implementation redelivers the message, nothing waits in place
+	if code := h(message.WithDelivery(newMsg(), 2), nil); code != common.ConsumeNackTransientFailure {
+		t.Fatalf("expected a redelivery, got %v", code)
+	}
+	if code := h(message.WithDelivery(newMsg(), 3), nil); code != common.ConsumeAck {
+		t.Fatalf("expected the dead-lettered message acked, got %v", code)
+	}
+	if len(attempts) != 2 || attempts[0] != "2" || attempts[1] != "3" {
+		t.Fatalf("unexpected attempts %v", attempts)
+	}
+	if len(d.opts) != 1 || d.opts[0].Headers[common.HeaderAttempt] != "3" ||
+		d.opts[0].Headers[common.HeaderDeadLetterReason] != common.DeadLetterRetriesExhausted {
+		t.Fatalf("unexpected dead letters %+v", d.opts)
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := miface.RetryPolicy{Backoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	for attempt, want := range map[int]time.Duration{
+		1:  10 * time.Millisecond,
+		2:  20 * time.Millisecond,
+		3:  40 * time.Millisecond,
+		4:  50 * time.Millisecond,
+		60: 50 * time.Millisecond,
+	} {
+		if got := p.Delay(attempt); got != want {
+			t.Fatalf("Delay(%d) = %v, want %v", attempt, got, want)
+		}
+	}
+}
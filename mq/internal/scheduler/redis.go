@@ -0,0 +1,107 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/redis/go-redis/v9"
+)
+
+// claimScript moves the due entries of the sorted set KEYS[1] to the end of their lease and
+// returns their encodings from the hash KEYS[2].
+var claimScript = redis.NewScript(`
+local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[3])
+local claimed = {}
+for _, id in ipairs(ids) do
+	local v = redis.call('HGET', KEYS[2], id)
+	if v then
+		redis.call('ZADD', KEYS[1], ARGV[2], id)
+		table.insert(claimed, v)
+	else
+		redis.call('ZREM', KEYS[1], id)
+	end
+end
+return claimed
+`)
+
+// doneScript removes the entry ARGV[1] unless it was scheduled again since it was claimed.
+var doneScript = redis.NewScript(`
+if redis.call('HGET', KEYS[2], ARGV[1]) == ARGV[2] then
+	redis.call('HDEL', KEYS[2], ARGV[1])
+	redis.call('ZREM', KEYS[1], ARGV[1])
+end
+return 0
+`)
+
+// redisEntry is the stored encoding of an Entry, Token makes every scheduling distinct.
+type redisEntry struct {
+	*Entry
+	Token string `json:"token"`
+}
+
+// RedisStore keeps entries in a sorted set of ids by due time and a hash of their encodings.
+// Both keys share a hash tag, so it works on redis cluster.
+type RedisStore struct {
+	client redis.UniversalClient
+	due    string
+	msgs   string
+}
+
+// NewRedisStore creates a RedisStore keeping its keys under prefix.
+func NewRedisStore(client redis.UniversalClient, prefix string) *RedisStore {
+	return &RedisStore{
+		client: client,
+		due:    "{" + prefix + "}:due",
+		msgs:   "{" + prefix + "}:msgs",
+	}
+}
+
+func (s *RedisStore) Add(ctx context.Context, e *Entry) error {
+	v, err := json.Marshal(redisEntry{Entry: e, Token: watermill.NewShortUUID()})
+	if err != nil {
+		return err
+	}
+	_, err = s.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.HSet(ctx, s.msgs, e.ID, v)
+		p.ZAdd(ctx, s.due, redis.Z{Score: float64(e.At.UnixMilli()), Member: e.ID})
+		return nil
+	})
+	return err
+}
+
+func (s *RedisStore) Claim(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*Entry, error) {
+	vs, err := claimScript.Run(ctx, s.client, []string{s.due, s.msgs},
+		now.UnixMilli(), now.Add(lease).UnixMilli(), limit).StringSlice()
+	if err != nil {
+		return nil, err
+	}
+	claimed := make([]*Entry, 0, len(vs))
+	for _, v := range vs {
+		e := redisEntry{Entry: &Entry{}}
+		if err := json.Unmarshal([]byte(v), &e); err != nil {
+			return nil, err
+		}
+		e.Entry.token = v
+		claimed = append(claimed, e.Entry)
+	}
+	return claimed, nil
+}
+
+func (s *RedisStore) Done(ctx context.Context, e *Entry) error {
+	return doneScript.Run(ctx, s.client, []string{s.due, s.msgs}, e.ID, e.token).Err()
+}
+
+func (s *RedisStore) Cancel(ctx context.Context, id string) (bool, error) {
+	var removed *redis.IntCmd
+	_, err := s.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		removed = p.HDel(ctx, s.msgs, id)
+		p.ZRem(ctx, s.due, id)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return removed.Val() > 0, nil
+}
@@ -0,0 +1,176 @@
+package scheduler
+
+import (
+	"context"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"go.uber.org/zap"
+
+	"github.com/gstones/moke-kit/mq/internal/qerrors"
+	"github.com/gstones/moke-kit/mq/internal/tracing"
+	"github.com/gstones/moke-kit/mq/miface"
+)
+
+// Option configures a Scheduler.
+type Option func(s *Scheduler)
+
+// WithInterval sets how often the scheduler looks for due messages (default 100ms), a non-positive
+// interval keeps the default.
+func WithInterval(d time.Duration) Option {
+	return func(s *Scheduler) {
+		if d > 0 {
+			s.interval = d
+		}
+	}
+}
+
+// WithLease sets how long a claimed message stays hidden from other replicas before it is
+// claimed again when its publishing did not finish (default 30s).
+func WithLease(d time.Duration) Option {
+	return func(s *Scheduler) {
+		s.lease = d
+	}
+}
+
+// WithBatchSize sets how many due messages are published per poll (default 100).
+func WithBatchSize(n int) Option {
+	return func(s *Scheduler) {
+		s.batchSize = n
+	}
+}
+
+// PublishFunc publishes a due message, usually the Publish of the mq routing by topic scheme.
+type PublishFunc func(topic string, opts ...miface.PubOption) error
+
+// Scheduler implements miface.Scheduler on top of a Store. Replicas sharing a store take turns
+// publishing due messages; a message is removed after its publishing succeeded, so it is
+// published at least once, possibly late by up to the poll interval.
+type Scheduler struct {
+	store   Store
+	publish PublishFunc
+	logger  *zap.Logger
+
+	interval  time.Duration
+	lease     time.Duration
+	batchSize int
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New creates a Scheduler keeping pending messages in store and releasing them with publish.
+func New(store Store, publish PublishFunc, logger *zap.Logger, opts ...Option) *Scheduler {
+	s := &Scheduler{
+		store:     store,
+		publish:   publish,
+		logger:    logger,
+		interval:  100 * time.Millisecond,
+		lease:     30 * time.Second,
+		batchSize: 100,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *Scheduler) Schedule(ctx context.Context, topic string, at time.Time, opts ...miface.PubOption) (string, error) {
+	if topic == "" {
+		return "", qerrors.ErrEmptyTopic
+	}
+	options, err := miface.NewPubOptions(opts...)
+	if err != nil {
+		return "", err
+	}
+	// the trace and context values of the scheduling are kept for the handlers of the message
+	headers := maps.Clone(options.Headers)
+	if headers == nil {
+		headers = map[string]string{}
+	}
+	tracing.Inject(ctx, headers)
+	e := &Entry{
+		ID:      options.MessageId,
+		Topic:   topic,
+		Data:    options.Data,
+		Key:     options.Key,
+		Headers: headers,
+		At:      at,
+	}
+	if e.ID == "" {
+		e.ID = watermill.NewUUID()
+	}
+	if err := s.store.Add(ctx, e); err != nil {
+		return "", err
+	}
+	return e.ID, nil
+}
+
+func (s *Scheduler) Cancel(ctx context.Context, id string) error {
+	if ok, err := s.store.Cancel(ctx, id); err != nil {
+		return err
+	} else if !ok {
+		return qerrors.ErrScheduledMessageNotFound
+	}
+	return nil
+}
+
+// Start releases due messages in the background until Stop.
+func (s *Scheduler) Start(context.Context) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// a full batch means more messages may be due already
+				for s.release(ctx) == s.batchSize {
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop ends the background loop, a message being published is claimed again after its lease.
+func (s *Scheduler) Stop(context.Context) error {
+	if s.cancel != nil {
+		s.cancel()
+		s.wg.Wait()
+	}
+	return nil
+}
+
+// release publishes one batch of due messages and returns how many were claimed.
+func (s *Scheduler) release(ctx context.Context) int {
+	entries, err := s.store.Claim(ctx, time.Now(), s.lease, s.batchSize)
+	if err != nil {
+		if ctx.Err() == nil {
+			s.logger.Error("scheduled messages claim failure", zap.Error(err))
+		}
+		return 0
+	}
+	for _, e := range entries {
+		opts := []miface.PubOption{miface.WithBytes(e.Data), miface.WithMessageId(e.ID), miface.WithHeaders(e.Headers)}
+		if e.Key != "" {
+			opts = append(opts, miface.WithPartitionKey(e.Key))
+		}
+		if err := s.publish(e.Topic, opts...); err != nil {
+			s.logger.Warn("scheduled message publish failure",
+				zap.String("id", e.ID), zap.String("topic", e.Topic), zap.Error(err))
+			continue
+		}
+		if err := s.store.Done(ctx, e); err != nil {
+			s.logger.Warn("scheduled message removal failure", zap.String("id", e.ID), zap.Error(err))
+		}
+	}
+	return len(entries)
+}
@@ -0,0 +1,189 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	"github.com/gstones/moke-kit/mq/internal/qerrors"
+	"github.com/gstones/moke-kit/mq/miface"
+)
+
+type published struct {
+	topic string
+	opts  miface.PubOptions
+}
+
+type recorder struct {
+	mu   sync.Mutex
+	msgs []published
+	fail bool
+}
+
+func (r *recorder) publish(topic string, opts ...miface.PubOption) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.fail {
+		return errors.New("unavailable")
+	}
+	o, err := miface.NewPubOptions(opts...)
+	if err != nil {
+		return err
+	}
+	r.msgs = append(r.msgs, published{topic: topic, opts: o})
+	return nil
+}
+
+func (r *recorder) get() []published {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]published(nil), r.msgs...)
+}
+
+func stores(t *testing.T) map[string]Store {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	return map[string]Store{
+		"memory": NewMemoryStore(),
+		"redis":  NewRedisStore(client, "test:scheduled"),
+	}
+}
+
+func TestScheduleReleasesDueMessages(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			r := &recorder{}
+			s := New(store, r.publish, zap.NewNop())
+
+			now := time.Now()
+			later, err := s.Schedule(ctx, "local://auction", now.Add(time.Hour), miface.WithBytes([]byte("later")))
+			if err != nil {
+				t.Fatalf("Schedule: %v", err)
+			}
+			id, err := s.Schedule(ctx, "local://auction", now.Add(-time.Millisecond),
+				miface.WithBytes([]byte("due")), miface.WithMessageId("auction-1"), miface.WithPartitionKey("k"),
+				miface.WithHeader("correlation-id", "c-1"))
+			if err != nil || id != "auction-1" {
+				t.Fatalf("Schedule: %q, %v", id, err)
+			}
+
+			if n := s.release(ctx); n != 1 {
+				t.Fatalf("released %d messages", n)
+			}
+			got := r.get()
+			if len(got) != 1 || got[0].topic != "local://auction" || string(got[0].opts.Data) != "due" ||
+				got[0].opts.MessageId != "auction-1" || got[0].opts.Key != "k" ||
+				got[0].opts.Headers["correlation-id"] != "c-1" {
+				t.Fatalf("published %+v", got)
+			}
+			if n := s.release(ctx); n != 0 {
+				t.Fatalf("released %d messages twice", n)
+			}
+
+			if err := s.Cancel(ctx, later); err != nil {
+				t.Fatalf("Cancel: %v", err)
+			}
+			if err := s.Cancel(ctx, later); !errors.Is(err, qerrors.ErrScheduledMessageNotFound) {
+				t.Fatalf("expected not found after cancel, got %v", err)
+			}
+			if err := s.Cancel(ctx, "auction-1"); !errors.Is(err, qerrors.ErrScheduledMessageNotFound) {
+				t.Fatalf("expected not found after publish, got %v", err)
+			}
+		})
+	}
+}
+
+func TestScheduleRetriesFailedPublishAfterLease(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			r := &recorder{fail: true}
+			s := New(store, r.publish, zap.NewNop(), WithLease(50*time.Millisecond))
+
+			if _, err := s.Schedule(ctx, "local://energy", time.Now(), miface.WithBytes([]byte("refill"))); err != nil {
+				t.Fatalf("Schedule: %v", err)
+			}
+			if n := s.release(ctx); n != 1 {
+				t.Fatalf("released %d messages", n)
+			}
+			r.mu.Lock()
+			r.fail = false
+			r.mu.Unlock()
+			if n := s.release(ctx); n != 0 {
+				t.Fatalf("claimed %d messages during their lease", n)
+			}
+			time.Sleep(60 * time.Millisecond)
+			if n := s.release(ctx); n != 1 || len(r.get()) != 1 {
+				t.Fatalf("expected the message published after its lease, got %+v", r.get())
+			}
+		})
+	}
+}
+
+func TestRescheduleDuringPublishIsKept(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			if err := store.Add(ctx, &Entry{ID: "a", Topic: "local://t", At: time.Now()}); err != nil {
+				t.Fatalf("Add: %v", err)
+			}
+			claimed, err := store.Claim(ctx, time.Now(), time.Minute, 10)
+			if err != nil || len(claimed) != 1 {
+				t.Fatalf("Claim: %v, %v", claimed, err)
+			}
+			// scheduled again while the claimed copy is being published
+			if err := store.Add(ctx, &Entry{ID: "a", Topic: "local://t", At: time.Now().Add(time.Hour)}); err != nil {
+				t.Fatalf("Add: %v", err)
+			}
+			if err := store.Done(ctx, claimed[0]); err != nil {
+				t.Fatalf("Done: %v", err)
+			}
+			if ok, err := store.Cancel(ctx, "a"); err != nil || !ok {
+				t.Fatalf("expected the rescheduled message kept: %v, %v", ok, err)
+			}
+		})
+	}
+}
+
+func TestStartStop(t *testing.T) {
+	r := &recorder{}
+	s := New(NewMemoryStore(), r.publish, zap.NewNop(), WithInterval(5*time.Millisecond))
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer s.Stop(context.Background())
+
+	at := time.Now().Add(50 * time.Millisecond)
+	if _, err := s.Schedule(context.Background(), "local://t", at, miface.WithBytes([]byte("x"))); err != nil {
+		t.Fatalf("Schedule: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(r.get()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if len(r.get()) != 1 || time.Now().Before(at) {
+		t.Fatalf("expected the message published once due, got %+v", r.get())
+	}
+}
+
+func TestNonPositiveIntervalKeepsDefault(t *testing.T) {
+	r := &recorder{}
+	s := New(NewMemoryStore(), r.publish, zap.NewNop(), WithInterval(0))
+	if s.interval != 100*time.Millisecond {
+		t.Fatalf("unexpected interval %v", s.interval)
+	}
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if err := s.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package scheduler
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Entry is a message held back until At.
+type Entry struct {
+	ID      string            `json:"id"`
+	Topic   string            `json:"topic"`
+	Data    []byte            `json:"data,omitempty"`
+	Key     string            `json:"key,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	At      time.Time         `json:"at"`
+
+	// token identifies the scheduling of ID the entry was claimed from, so Done leaves a
+	// message scheduled again under the same id in place.
+	token string
+}
+
+// Store keeps the pending entries of a Scheduler.
+type Store interface {
+	// Add stores e, replacing a pending entry with the same id.
+	Add(ctx context.Context, e *Entry) error
+	// Claim returns up to limit entries due at now and hides them from other claims for lease,
+	// so an entry whose publishing failed or was interrupted is claimed again afterwards.
+	Claim(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*Entry, error)
+	// Done removes a claimed entry once it was published.
+	Done(ctx context.Context, e *Entry) error
+	// Cancel removes the entry with id and reports whether it was pending.
+	Cancel(ctx context.Context, id string) (bool, error)
+}
+
+type memoryEntry struct {
+	entry *Entry
+	due   time.Time
+}
+
+// MemoryStore keeps entries in process memory, they are lost on restart.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]*memoryEntry
+	seq     int
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: map[string]*memoryEntry{}}
+}
+
+func (s *MemoryStore) Add(_ context.Context, e *Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := *e
+	s.seq++
+	c.token = strconv.Itoa(s.seq)
+	s.entries[e.ID] = &memoryEntry{entry: &c, due: e.At}
+	return nil
+}
+
+func (s *MemoryStore) Claim(_ context.Context, now time.Time, lease time.Duration, limit int) ([]*Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var due []*memoryEntry
+	for _, m := range s.entries {
+		if !m.due.After(now) {
+			due = append(due, m)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].due.Before(due[j].due) })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	claimed := make([]*Entry, 0, len(due))
+	for _, m := range due {
+		m.due = now.Add(lease)
+		c := *m.entry
+		claimed = append(claimed, &c)
+	}
+	return claimed, nil
+}
+
+func (s *MemoryStore) Done(_ context.Context, e *Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m, ok := s.entries[e.ID]; ok && m.entry.token == e.token {
+		delete(s.entries, e.ID)
+	}
+	return nil
+}
+
+func (s *MemoryStore) Cancel(_ context.Context, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.entries[id]
+	delete(s.entries, id)
+	return ok, nil
+}
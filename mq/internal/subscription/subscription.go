@@ -1,7 +1,10 @@
 package subscription
 
 import (
+	"context"
 	"sync/atomic"
+
+	"github.com/gstones/moke-kit/mq/internal/qerrors"
 )
 
 // CancelSubscription implements miface.Subscription by cancelling a per-subscribe
@@ -10,11 +13,14 @@ type CancelSubscription struct {
 	cancel func()
 	valid  atomic.Bool
 	closed atomic.Bool
+	ended  atomic.Bool
+	done   chan struct{}
+	err    error
 }
 
 // NewCancelSubscription creates a valid subscription that cancels via cancel.
 func NewCancelSubscription(cancel func()) *CancelSubscription {
-	s := &CancelSubscription{cancel: cancel}
+	s := &CancelSubscription{cancel: cancel, done: make(chan struct{})}
 	s.valid.Store(true)
 	return s
 }
@@ -24,17 +30,55 @@ func (s *CancelSubscription) IsValid() bool {
 	return s != nil && s.valid.Load()
 }
 
-// Unsubscribe cancels the subscription. It is safe to call more than once.
+// Unsubscribe cancels the subscription, the handlers in flight still finish before Done
+// is closed. It is safe to call more than once.
 func (s *CancelSubscription) Unsubscribe() error {
+	s.Close(nil)
+	return nil
+}
+
+// Close ends the subscription for err, which Err reports afterwards. Only the first
+// Close or Unsubscribe counts.
+func (s *CancelSubscription) Close(err error) {
 	if s == nil {
-		return nil
+		return
 	}
 	if !s.closed.CompareAndSwap(false, true) {
-		return nil
+		return
 	}
 	s.valid.Store(false)
+	s.err = err
 	if s.cancel != nil {
 		s.cancel()
 	}
-	return nil
+}
+
+// Ended closes a subscription whose consuming stopped, once its handlers returned: with
+// the error of ctx, the context it was subscribed with, when that ended, and with
+// ErrSubscriptionClosed when the message queue stopped it. A subscription already
+// unsubscribed keeps its error. Done is closed afterwards.
+func (s *CancelSubscription) Ended(ctx context.Context) {
+	if err := ctx.Err(); err != nil {
+		s.Close(err)
+	} else {
+		s.Close(qerrors.ErrSubscriptionClosed)
+	}
+	if s.ended.CompareAndSwap(false, true) {
+		close(s.done)
+	}
+}
+
+// Done is closed once the subscription ended and its handlers returned.
+func (s *CancelSubscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns why the subscription ended, nil while it is active or after Unsubscribe.
+func (s *CancelSubscription) Err() error {
+	select {
+	case <-s.done:
+		return s.err
+	default:
+		return nil
+	}
 }
@@ -1,9 +1,13 @@
 package subscription
 
 import (
+	"context"
+	"errors"
 	"sync"
 	"sync/atomic"
 	"testing"
+
+	"github.com/gstones/moke-kit/mq/internal/qerrors"
 )
 
 func TestCancelSubscription(t *testing.T) {
@@ -52,3 +56,38 @@ func TestCancelSubscriptionConcurrentUnsubscribe(t *testing.T) {
 		t.Fatal("expected invalid after concurrent unsubscribe")
 	}
 }
+
+func TestCancelSubscriptionDoneAndErr(t *testing.T) {
+	sub := NewCancelSubscription(nil)
+	select {
+	case <-sub.Done():
+		t.Fatal("done while active")
+	default:
+	}
+	_ = sub.Unsubscribe()
+	select {
+	case <-sub.Done():
+		t.Fatal("done before the consuming ended")
+	default:
+	}
+	// the first end counts
+	sub.Ended(context.Background())
+	<-sub.Done()
+	if sub.Err() != nil {
+		t.Fatalf("expected no error after Unsubscribe, got %v", sub.Err())
+	}
+
+	sub = NewCancelSubscription(nil)
+	sub.Ended(context.Background())
+	if !errors.Is(sub.Err(), qerrors.ErrSubscriptionClosed) || sub.IsValid() {
+		t.Fatalf("expected ErrSubscriptionClosed, got %v", sub.Err())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	sub = NewCancelSubscription(nil)
+	sub.Ended(ctx)
+	if !errors.Is(sub.Err(), context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", sub.Err())
+	}
+}
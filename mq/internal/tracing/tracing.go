@@ -0,0 +1,120 @@
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/gstones/moke-kit/mq/common"
+	"github.com/gstones/moke-kit/mq/internal/message"
+	"github.com/gstones/moke-kit/mq/miface"
+	"github.com/gstones/moke-kit/utility"
+)
+
+const instrumentationName = "github.com/gstones/moke-kit/mq"
+
+// Inject writes the trace context of ctx, with the global propagator, and its common.ContextKeys
+// values into headers.
+func Inject(ctx context.Context, headers map[string]string) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.MapCarrier(headers))
+	for _, key := range common.ContextKeys {
+		if v, ok := utility.FromContext(ctx, key); ok {
+			headers[common.HeaderContextPrefix+key.String()] = v
+		}
+	}
+}
+
+// Extract returns ctx with the trace context and the common.ContextKeys values found in headers.
+func Extract(ctx context.Context, headers map[string]string) context.Context {
+	ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(headers))
+	for _, key := range common.ContextKeys {
+		if v, ok := headers[common.HeaderContextPrefix+key.String()]; ok {
+			ctx = utility.NewContext(ctx, key, v)
+		}
+	}
+	return ctx
+}
+
+// StartPublish starts the producer span of a message published to topic of the system message queue,
+// the returned context is to be injected into the message.
+func StartPublish(ctx context.Context, system, topic string) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, topic+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystem(system),
+			semconv.MessagingDestinationName(topic),
+			semconv.MessagingOperationPublish,
+		),
+	)
+}
+
+// End records err on span and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Handler runs handler in a consumer span of topic, child of and linked to the producer span the
+// message carries, with the message context holding the span and the propagated context values. It
+// derives from the context the mq implementation handed the message in, which ends with the
+// subscription.
+func Handler(system, topic string, handler miface.SubResponseHandler) miface.SubResponseHandler {
+	tracer := otel.Tracer(instrumentationName)
+	return func(msg miface.Message, err error) common.ConsumptionCode {
+		if msg == nil {
+			return handler(msg, err)
+		}
+		msg, span := startProcess(tracer, system, topic, msg)
+		defer span.End()
+		if err != nil {
+			span.RecordError(err)
+		}
+
+		code := handler(msg, err)
+		if code == common.ConsumeNackTransientFailure || code == common.ConsumeNackPersistentFailure {
+			span.SetStatus(codes.Error, "message nacked")
+		}
+		return code
+	}
+}
+
+// Responder runs handler in a consumer span like Handler does.
+func Responder(system, topic string, handler miface.RequestHandler) miface.RequestHandler {
+	tracer := otel.Tracer(instrumentationName)
+	return func(msg miface.Message) ([]byte, error) {
+		msg, span := startProcess(tracer, system, topic, msg)
+		reply, err := handler(msg)
+		End(span, err)
+		return reply, err
+	}
+}
+
+func startProcess(tracer trace.Tracer, system, topic string, msg miface.Message) (miface.Message, trace.Span) {
+	ctx := msg.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	// the span of the context the subscription was made in is neither parent nor producer
+	ctx = Extract(trace.ContextWithSpanContext(ctx, trace.SpanContext{}), msg.Headers())
+	opts := []trace.SpanStartOption{
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystem(system),
+			semconv.MessagingSourceName(topic),
+			semconv.MessagingOperationProcess,
+			semconv.MessagingMessageID(msg.ID()),
+		),
+	}
+	if producer := trace.SpanContextFromContext(ctx); producer.IsValid() {
+		opts = append(opts, trace.WithLinks(trace.Link{SpanContext: producer}))
+	}
+	ctx, span := tracer.Start(ctx, topic+" process", opts...)
+	return message.WithContext(msg, ctx), span
+}
@@ -0,0 +1,120 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/gstones/moke-kit/mq/common"
+	"github.com/gstones/moke-kit/mq/internal/message"
+	"github.com/gstones/moke-kit/mq/miface"
+	"github.com/gstones/moke-kit/utility"
+)
+
+func setup(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	prevTP, prevProp := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prevTP)
+		otel.SetTextMapPropagator(prevProp)
+	})
+	return recorder
+}
+
+func TestHandlerContinuesPublishTrace(t *testing.T) {
+	recorder := setup(t)
+
+	ctx := utility.NewContext(context.Background(), utility.UIDContextKey, "10001")
+	ctx = utility.NewContext(ctx, utility.TokenContextKey, "secret")
+	ctx, publish := StartPublish(ctx, "local", "orders")
+	headers := map[string]string{}
+	Inject(ctx, headers)
+	End(publish, nil)
+	if headers["traceparent"] == "" || headers[common.HeaderContextPrefix+"uid"] != "10001" {
+		t.Fatalf("unexpected headers: %v", headers)
+	}
+	if _, ok := headers[common.HeaderContextPrefix+"bearer"]; ok {
+		t.Fatalf("bearer token propagated: %v", headers)
+	}
+
+	var handled context.Context
+	handler := Handler("local", "orders", func(msg miface.Message, err error) common.ConsumptionCode {
+		handled = msg.Context()
+		return common.ConsumeAck
+	})
+	handler(message.NewMessage("id", "orders", nil, nil, headers, time.Now()), nil)
+
+	if uid, _ := utility.FromContext(handled, utility.UIDContextKey); uid != "10001" {
+		t.Fatalf("uid not restored: %q", uid)
+	}
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected publish and process spans, got %d", len(spans))
+	}
+	producer, consumer := spans[0], spans[1]
+	if consumer.SpanKind() != trace.SpanKindConsumer || consumer.Name() != "orders process" {
+		t.Fatalf("unexpected consumer span %s %v", consumer.Name(), consumer.SpanKind())
+	}
+	if consumer.Parent().SpanID() != producer.SpanContext().SpanID() ||
+		consumer.SpanContext().TraceID() != producer.SpanContext().TraceID() {
+		t.Fatal("consumer span not a child of the producer span")
+	}
+	if links := consumer.Links(); len(links) != 1 || links[0].SpanContext.SpanID() != producer.SpanContext().SpanID() {
+		t.Fatalf("consumer span not linked to the producer span: %v", links)
+	}
+	if trace.SpanContextFromContext(handled).SpanID() != consumer.SpanContext().SpanID() {
+		t.Fatal("handler context does not carry the consumer span")
+	}
+}
+
+func TestHandlerWithoutTraceStartsRoot(t *testing.T) {
+	recorder := setup(t)
+
+	handler := Handler("nats", "orders", func(msg miface.Message, err error) common.ConsumptionCode {
+		if _, ok := utility.FromContext(msg.Context(), utility.UIDContextKey); ok {
+			t.Error("unexpected uid")
+		}
+		return common.ConsumeNackPersistentFailure
+	})
+	handler(message.NewMessage("id", "orders", nil, nil, nil, time.Time{}), nil)
+
+	spans := recorder.Ended()
+	if len(spans) != 1 || spans[0].Parent().IsValid() || len(spans[0].Links()) != 0 {
+		t.Fatalf("expected a single root span, got %v", spans)
+	}
+}
+
+func TestHandlerKeepsMessageContext(t *testing.T) {
+	recorder := setup(t)
+
+	// the span the subscription was made in is not the parent of the consumer span
+	subCtx, subscribe := otel.Tracer("test").Start(context.Background(), "subscribe")
+	subCtx, cancel := context.WithCancel(subCtx)
+	var handled context.Context
+	handler := Handler("local", "orders", func(msg miface.Message, err error) common.ConsumptionCode {
+		handled = msg.Context()
+		return common.ConsumeAck
+	})
+	handler(message.WithContext(message.NewMessage("id", "orders", nil, nil, nil, time.Time{}), subCtx), nil)
+	subscribe.End()
+
+	cancel()
+	select {
+	case <-handled.Done():
+	default:
+		t.Fatal("handler context not ended with the message context")
+	}
+	spans := recorder.Ended()
+	if len(spans) != 2 || spans[0].Parent().IsValid() || len(spans[0].Links()) != 0 {
+		t.Fatalf("expected a root consumer span, got %v", spans)
+	}
+}
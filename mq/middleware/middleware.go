@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"context"
+	"runtime/debug"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+	"go.uber.org/zap"
+
+	"github.com/gstones/moke-kit/mq/common"
+	"github.com/gstones/moke-kit/mq/miface"
+)
+
+const meterName = "github.com/gstones/moke-kit/mq"
+
+// Chain wraps handler in mws, the first one outermost.
+func Chain(handler miface.SubResponseHandler, mws ...miface.Middleware) miface.SubResponseHandler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		handler = mws[i](handler)
+	}
+	return handler
+}
+
+// Recovery turns a panicking handler into a persistent failure of its message, which is
+// dead-lettered WithDeadLetterTopic, instead of the end of the subscription.
+func Recovery(logger *zap.Logger) miface.Middleware {
+	return func(next miface.SubResponseHandler) miface.SubResponseHandler {
+		return func(msg miface.Message, err error) (code common.ConsumptionCode) {
+			defer func() {
+				if r := recover(); r != nil {
+					logger.Error("mq handler panic",
+						messageFields(msg,
+							zap.Any("panic", r),
+							zap.ByteString("stack", debug.Stack()),
+						)...,
+					)
+					code = common.ConsumeNackPersistentFailure
+				}
+			}()
+			return next(msg, err)
+		}
+	}
+}
+
+// Logging logs the messages nacked by the handler and the errors of the message queue it is told
+// about, and every handled message at debug level.
+func Logging(logger *zap.Logger) miface.Middleware {
+	return func(next miface.SubResponseHandler) miface.SubResponseHandler {
+		return func(msg miface.Message, err error) common.ConsumptionCode {
+			start := time.Now()
+			code := next(msg, err)
+			fields := messageFields(msg, zap.Stringer("code", code), zap.Duration("duration", time.Since(start)))
+			switch {
+			case err != nil:
+				logger.Error("mq handler error", append(fields, zap.Error(err))...)
+			case code == common.ConsumeNackTransientFailure || code == common.ConsumeNackPersistentFailure:
+				logger.Warn("mq message nacked", fields...)
+			default:
+				logger.Debug("mq message handled", fields...)
+			}
+			return code
+		}
+	}
+}
+
+// Metrics counts the handled messages by topic and consumption code, in mq.consume.messages, and
+// records how long their handling took in mq.consume.duration, with the global meter provider.
+func Metrics() miface.Middleware {
+	meter := otel.Meter(meterName)
+	messages, err := meter.Int64Counter("mq.consume.messages",
+		metric.WithDescription("Messages handled by subscribers"))
+	if err != nil {
+		otel.Handle(err)
+		messages = noop.Int64Counter{}
+	}
+	duration, err := meter.Float64Histogram("mq.consume.duration",
+		metric.WithDescription("Time subscribers took to handle a message"), metric.WithUnit("s"))
+	if err != nil {
+		otel.Handle(err)
+		duration = noop.Float64Histogram{}
+	}
+	return func(next miface.SubResponseHandler) miface.SubResponseHandler {
+		return func(msg miface.Message, err error) common.ConsumptionCode {
+			if msg == nil {
+				return next(msg, err)
+			}
+			start := time.Now()
+			code := next(msg, err)
+			ctx := msg.Context()
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			attrs := metric.WithAttributes(
+				attribute.String("topic", msg.Topic()),
+				attribute.String("code", code.String()),
+			)
+			messages.Add(ctx, 1, attrs)
+			duration.Record(ctx, time.Since(start).Seconds(), attrs)
+			return code
+		}
+	}
+}
+
+func messageFields(msg miface.Message, fields ...zap.Field) []zap.Field {
+	if msg == nil {
+		return fields
+	}
+	return append([]zap.Field{zap.String("topic", msg.Topic()), zap.String("id", msg.ID())}, fields...)
+}
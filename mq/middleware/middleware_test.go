@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/gstones/moke-kit/mq/common"
+	"github.com/gstones/moke-kit/mq/internal/message"
+	"github.com/gstones/moke-kit/mq/miface"
+)
+
+func testMessage() miface.Message {
+	return message.NewMessage("id-1", "orders", []byte("x"), nil, nil, time.Time{})
+}
+
+func TestChainOrder(t *testing.T) {
+	var order []string
+	mark := func(name string) miface.Middleware {
+		return func(next miface.SubResponseHandler) miface.SubResponseHandler {
+			return func(msg miface.Message, err error) common.ConsumptionCode {
+				order = append(order, name)
+				return next(msg, err)
+			}
+		}
+	}
+	h := Chain(func(miface.Message, error) common.ConsumptionCode {
+		order = append(order, "handler")
+		return common.ConsumeAck
+	}, mark("a"), mark("b"))
+	h(testMessage(), nil)
+	if len(order) != 3 || order[0] != "a" || order[1] != "b" || order[2] != "handler" {
+		t.Fatalf("order=%v", order)
+	}
+}
+
+func TestRecoveryAndLogging(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	logger := zap.New(core)
+	h := Chain(func(msg miface.Message, err error) common.ConsumptionCode {
+		panic("boom")
+	}, Logging(logger), Recovery(logger))
+
+	if code := h(testMessage(), nil); code != common.ConsumeNackPersistentFailure {
+		t.Fatalf("code=%v want nack_persistent", code)
+	}
+	if n := logs.FilterMessage("mq handler panic").Len(); n != 1 {
+		t.Fatalf("panic logged %d times", n)
+	}
+	nacked := logs.FilterMessage("mq message nacked").All()
+	if len(nacked) != 1 || nacked[0].ContextMap()["topic"] != "orders" {
+		t.Fatalf("nack logs=%v", nacked)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	prev := otel.GetMeterProvider()
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	defer otel.SetMeterProvider(prev)
+
+	h := Metrics()(func(miface.Message, error) common.ConsumptionCode { return common.ConsumeAck })
+	h(testMessage(), nil)
+	h(testMessage(), nil)
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != "mq.consume.messages" {
+				continue
+			}
+			sum := m.Data.(metricdata.Sum[int64])
+			if len(sum.DataPoints) != 1 || sum.DataPoints[0].Value != 2 {
+				t.Fatalf("data points=%+v", sum.DataPoints)
+			}
+			return
+		}
+	}
+	t.Fatal("mq.consume.messages not recorded")
+}
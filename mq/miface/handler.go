@@ -4,4 +4,18 @@ import (
 	"github.com/gstones/moke-kit/mq/common"
 )
 
+// SubResponseHandler handles the messages of a subscription. The message queue calls it with a nil
+// msg and the error instead to tell about a failure of its own, e.g. a lost connection; the
+// subscription ends with that error, Err, unless the message queue recovers from it by itself.
 type SubResponseHandler = func(msg Message, err error) common.ConsumptionCode
+
+// RequestHandler answers a request with the data of its reply, an error is returned to the requester
+// as ErrRequestFailure.
+type RequestHandler = func(msg Message) (reply []byte, err error)
+
+// DecodeErrorHandler decides what becomes of a message the typed subscribers could not decode, in
+// place of their handler.
+type DecodeErrorHandler = func(msg Message, err error) common.ConsumptionCode
+
+// Middleware wraps the handler of a subscription, to run code around the handling of every message.
+type Middleware = func(next SubResponseHandler) SubResponseHandler
@@ -1,15 +1,32 @@
 package miface
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 type MessageQueue interface {
 	Subscribe(context context.Context, topic string, handler SubResponseHandler, opts ...SubOption) (Subscription, error)
 	Publish(topic string, opts ...PubOption) error
+	// Request publishes a request to topic and waits for the reply of one responder until ctx ends,
+	// or for DefaultRequestTimeout when ctx has no deadline.
+	Request(ctx context.Context, topic string, opts ...PubOption) (Message, error)
+	// Respond subscribes handler to the requests of topic and replies with what it returns.
+	Respond(ctx context.Context, topic string, handler RequestHandler, opts ...SubOption) (Subscription, error)
 }
 
+// DefaultRequestTimeout bounds the requests made with a context without deadline.
+const DefaultRequestTimeout = 5 * time.Second
+
 type Subscription interface {
 	IsValid() bool
 	Unsubscribe() error
+	// Done is closed once the subscription ended, unsubscribed, after a ConsumeAckFinal, with the
+	// context it was subscribed with or stopped by the message queue, and its handlers returned.
+	Done() <-chan struct{}
+	// Err returns why the subscription ended: nil while it is active and after Unsubscribe or a
+	// ConsumeAckFinal, the error of its context or the error that stopped it otherwise.
+	Err() error
 }
 
 type Message interface {
@@ -17,4 +34,24 @@ type Message interface {
 	Topic() string
 	Data() []byte
 	VPtr() (vPtr any)
+	// Headers returns the headers the message was published with, including the standard
+	// common.Header* ones, the map must not be modified.
+	Headers() map[string]string
+	// Timestamp returns when the message was published, or the zero time if the message queue
+	// does not tell.
+	Timestamp() time.Time
+	// Context returns the context of the handling of the message, carrying its consumer span and the
+	// utility context values it was published WithContext.
+	Context() context.Context
+}
+
+// Scheduler holds delayed messages back until they are due, for the message queues without delayed
+// delivery of their own.
+type Scheduler interface {
+	// Schedule publishes a message to topic at the given time and returns its id, scheduling an id
+	// given WithMessageId again replaces the pending message.
+	Schedule(ctx context.Context, topic string, at time.Time, opts ...PubOption) (string, error)
+	// Cancel drops a pending message, it fails with ErrScheduledMessageNotFound once the message
+	// was published or cancelled.
+	Cancel(ctx context.Context, id string) error
 }
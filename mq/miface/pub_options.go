@@ -1,16 +1,23 @@
 package miface
 
 import (
+	"context"
 	"encoding/json"
 	"time"
 
+	"github.com/gstones/moke-kit/mq/common"
 	"github.com/gstones/moke-kit/mq/internal/qerrors"
 )
 
 // PubOptions contains all the various options that the provided WithXyz functions construct.
 type PubOptions struct {
-	Data  []byte
-	Delay time.Duration
+	Context   context.Context
+	Data      []byte
+	Delay     time.Duration
+	DeliverAt time.Time
+	Key       string
+	MessageId string
+	Headers   map[string]string
 }
 
 // PubOption is a closure that updates PubOptions.
@@ -45,12 +52,25 @@ func WithJSON(data any) PubOption {
 		if len(o.Data) != 0 {
 			return qerrors.ErrDataAlreadySet
 		} else {
-			o.Data, err = json.Marshal(data)
+			if o.Data, err = json.Marshal(data); err == nil {
+				if _, ok := o.Headers[common.HeaderContentType]; !ok {
+					o.setHeader(common.HeaderContentType, common.ContentTypeJSON)
+				}
+			}
 			return
 		}
 	}
 }
 
+// WithContext Use WithContext to publish the message from ctx, its trace and utility context values (see
+// common.ContextKeys) are carried to the context of the handlers, Message.Context().
+func WithContext(ctx context.Context) PubOption {
+	return func(o *PubOptions) error {
+		o.Context = ctx
+		return nil
+	}
+}
+
 // WithDelay Use WithDelay to set the value to defer the message. Deferring is to be done by the message queue, not mq.
 func WithDelay(deferAmt time.Duration) PubOption {
 	return func(o *PubOptions) error {
@@ -58,3 +78,72 @@ func WithDelay(deferAmt time.Duration) PubOption {
 		return nil
 	}
 }
+
+// WithDeliverAt Use WithDeliverAt to hold the message back until t, a time in the past publishes it right away.
+func WithDeliverAt(t time.Time) PubOption {
+	return func(o *PubOptions) error {
+		o.DeliverAt = t
+		return nil
+	}
+}
+
+// WithMessageId Use WithMessageId to set the id of the message instead of a generated one, a delayed message can be
+// cancelled with it until it is due.
+func WithMessageId(id string) PubOption {
+	return func(o *PubOptions) error {
+		o.MessageId = id
+		return nil
+	}
+}
+
+// DelayFrom returns how long after now the message is due, WithDeliverAt taking precedence over WithDelay.
+func (o PubOptions) DelayFrom(now time.Time) time.Duration {
+	if !o.DeliverAt.IsZero() {
+		return max(o.DeliverAt.Sub(now), 0)
+	}
+	return max(o.Delay, 0)
+}
+
+// WithPartitionKey Use WithPartitionKey to publish messages with the same key to the same partition, so they keep
+// their order. Backends without partitions ignore it.
+func WithPartitionKey(key string) PubOption {
+	return func(o *PubOptions) error {
+		o.Key = key
+		return nil
+	}
+}
+
+// WithHeader Use WithHeader to set a header of the message, subscribers read it from Message.Headers().
+func WithHeader(key, value string) PubOption {
+	return func(o *PubOptions) error {
+		o.setHeader(key, value)
+		return nil
+	}
+}
+
+// WithHeaders Use WithHeaders to set several headers of the message at once, on top of the ones already set.
+func WithHeaders(headers map[string]string) PubOption {
+	return func(o *PubOptions) error {
+		for k, v := range headers {
+			o.setHeader(k, v)
+		}
+		return nil
+	}
+}
+
+// WithContentType Use WithContentType to set the media type of the message data, WithJSON sets application/json.
+func WithContentType(contentType string) PubOption {
+	return WithHeader(common.HeaderContentType, contentType)
+}
+
+// WithSchemaVersion Use WithSchemaVersion to set the version of the schema the message data was encoded with.
+func WithSchemaVersion(version string) PubOption {
+	return WithHeader(common.HeaderSchemaVersion, version)
+}
+
+func (o *PubOptions) setHeader(key, value string) {
+	if o.Headers == nil {
+		o.Headers = map[string]string{}
+	}
+	o.Headers[key] = value
+}
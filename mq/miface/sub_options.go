@@ -1,6 +1,8 @@
 package miface
 
 import (
+	"time"
+
 	"github.com/gstones/moke-kit/mq/common"
 	"github.com/gstones/moke-kit/mq/internal/qerrors"
 )
@@ -9,6 +11,31 @@ import (
 type SubOptions struct {
 	DeliverySemantics common.DeliverySemantics
 	GroupId           string
+	DecodeError       DecodeErrorHandler
+	Retry             RetryPolicy
+	DeadLetterTopic   string
+	Concurrency       int
+	OrderingKey       func(msg Message) string
+}
+
+// RetryPolicy retries the messages nacked as transient failures in the subscription, before they are
+// dead-lettered. Retries wait Backoff, doubled after each attempt up to MaxBackoff when set.
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+}
+
+// Delay returns how long to wait after the failed attempt before the next one.
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < attempt && (p.MaxBackoff <= 0 || d < p.MaxBackoff); i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return d
 }
 
 // SubOption is a closure that updates SubOptions.
@@ -20,9 +47,14 @@ func NewSubOptions(opts ...SubOption) (options SubOptions, err error) {
 
 	for _, opt := range opts {
 		if err = opt(o); err != nil {
-			break
+			return
 		}
 	}
+	switch o.DeliverySemantics {
+	case common.Unset, common.AtLeastOnce, common.AtMostOnce:
+	default:
+		err = qerrors.ErrSemanticsUnsupported
+	}
 	return
 }
 
@@ -55,3 +87,68 @@ func WithAtMostOnceDelivery(groupId common.GroupId) SubOption {
 		}
 	}
 }
+
+// Configures the group the subscription consumes as, whatever its delivery semantics.
+// Subscriptions sharing a group split the messages of the topic between them, and mq
+// implementations with durable consumers keep the position of the group between subscriptions.
+func WithGroupId(groupId common.GroupId) SubOption {
+	return func(o *SubOptions) error {
+		o.GroupId = string(groupId)
+		return nil
+	}
+}
+
+// Configures what typed subscriptions do with the messages they fail to decode, they are nacked as
+// persistent failures by default.
+func WithDecodeErrorHandler(handler DecodeErrorHandler) SubOption {
+	return func(o *SubOptions) error {
+		o.DecodeError = handler
+		return nil
+	}
+}
+
+// Configures the subscription to retry the messages its handler nacks as transient failures up to
+// policy.MaxAttempts times in all, redelivered after the delay of policy by the mq implementations
+// counting deliveries and retried in place by the others. The handler reads the attempt from the
+// common.HeaderAttempt header.
+func WithRetryPolicy(policy RetryPolicy) SubOption {
+	return func(o *SubOptions) error {
+		o.Retry = policy
+		return nil
+	}
+}
+
+// Configures the topic the messages nacked as persistent failures, or exhausting the retry policy,
+// are published to, with headers telling the topic and why (see the deadletter package).
+func WithDeadLetterTopic(topic string) SubOption {
+	return func(o *SubOptions) error {
+		o.DeadLetterTopic = topic
+		return nil
+	}
+}
+
+// Configures the subscription to handle up to n messages at once, it stops pulling messages while
+// all n are busy. Unsubscribe lets the handlers in flight finish, Done is closed after them.
+func WithConcurrency(n int) SubOption {
+	return func(o *SubOptions) error {
+		o.Concurrency = n
+		return nil
+	}
+}
+
+// Configures a concurrent subscription to handle the messages with the same key one after another,
+// in the order they arrive, while messages with other keys are handled in parallel.
+func WithOrderingKey(key func(msg Message) string) SubOption {
+	return func(o *SubOptions) error {
+		o.OrderingKey = key
+		return nil
+	}
+}
+
+// Key returns the ordering key of msg, empty without WithOrderingKey.
+func (o SubOptions) Key(msg Message) string {
+	if o.OrderingKey == nil {
+		return ""
+	}
+	return o.OrderingKey(msg)
+}
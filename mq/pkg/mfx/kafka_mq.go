@@ -0,0 +1,48 @@
+package mfx
+
+import (
+	"context"
+
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+
+	"github.com/gstones/moke-kit/mq/internal/kafka"
+	"github.com/gstones/moke-kit/mq/miface"
+	"github.com/gstones/moke-kit/utility"
+)
+
+type KafkaResult struct {
+	fx.Out
+	KafkaMQ miface.MessageQueue `name:"KafkaMQ"`
+}
+
+func (k *KafkaResult) init(lc fx.Lifecycle, logger *zap.Logger, s SettingsParams) error {
+	mq, err := kafka.NewMessageQueue(logger, s.KafkaUrl)
+	if err != nil {
+		logger.Error("Kafka message queue create failure:",
+			zap.Error(err),
+			zap.String("address", utility.RedactURL(s.KafkaUrl)))
+		return err
+	}
+	lc.Append(fx.Hook{
+		OnStop: func(context.Context) error {
+			return mq.Close()
+		},
+	})
+	k.KafkaMQ = mq
+	return nil
+}
+
+// CreateKafkaModule creates a new kafka message queue module, closed when the app stops.
+func CreateKafkaModule(lc fx.Lifecycle, l *zap.Logger, s SettingsParams) (KafkaResult, error) {
+	out := KafkaResult{}
+	err := out.init(lc, l, s)
+	return out, err
+}
+
+// KafkaModule is the module for kafka message queue
+var KafkaModule = fx.Provide(
+	func(lc fx.Lifecycle, l *zap.Logger, s SettingsParams) (KafkaResult, error) {
+		return CreateKafkaModule(lc, l, s)
+	},
+)
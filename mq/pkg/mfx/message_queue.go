@@ -2,10 +2,12 @@ package mfx
 
 import (
 	"go.uber.org/fx"
+	"go.uber.org/zap"
 
 	"github.com/gstones/moke-kit/fxmain/pkg/mfx"
 	"github.com/gstones/moke-kit/mq/common"
 	"github.com/gstones/moke-kit/mq/internal"
+	"github.com/gstones/moke-kit/mq/middleware"
 	"github.com/gstones/moke-kit/mq/miface"
 	"github.com/gstones/moke-kit/utility"
 )
@@ -31,23 +33,62 @@ type MQImplementations struct {
 	LocalMQ miface.MessageQueue `name:"LocalMQ" optional:"true"`
 }
 
-func (g *MessageQueueResult) init(mqs MQImplementations) (err error) {
-	g.MessageQueue = internal.NewMessageQueue(mqs.KafkaMQ, mqs.NatsMQ, mqs.NsqMQ, mqs.LocalMQ)
+// MiddlewareParams are the subscriber middlewares applications provide with a MiddlewareResult.
+type MiddlewareParams struct {
+	fx.In
+
+	Middlewares []miface.Middleware `group:"MqMiddleware"`
+}
+
+// MiddlewareResult adds a middleware to the handlers of every subscription and responder, inside
+// the default logging, metrics and recovery ones. Middlewares of the group are applied in no given
+// order, so they must not depend on each other: provide the ones that do as one, with middleware.Chain.
+type MiddlewareResult struct {
+	fx.Out
+
+	Middleware miface.Middleware `group:"MqMiddleware"`
+}
+
+func (g *MessageQueueResult) init(
+	logger *zap.Logger,
+	mqs MQImplementations,
+	sp SchedulerParams,
+	mp MiddlewareParams,
+) (err error) {
+	// recovery is inside logging and metrics, so that they see the panics it turns into nacks
+	mws := append([]miface.Middleware{
+		middleware.Logging(logger),
+		middleware.Metrics(),
+		middleware.Recovery(logger),
+	}, mp.Middlewares...)
+	g.MessageQueue = internal.NewMessageQueue(mqs.KafkaMQ, mqs.NatsMQ, mqs.NsqMQ, mqs.LocalMQ, sp.Scheduler, mws...)
 	return nil
 }
 
 // CreateMessageQueueModule creates a new message queue module.
-func CreateMessageQueueModule(deploy utility.Deployments, mqs MQImplementations) (MessageQueueResult, error) {
+func CreateMessageQueueModule(
+	logger *zap.Logger,
+	deploy utility.Deployments,
+	mqs MQImplementations,
+	sp SchedulerParams,
+	mp MiddlewareParams,
+) (MessageQueueResult, error) {
 	common.SetNamespace(deploy.String())
 	out := MessageQueueResult{}
-	err := out.init(mqs)
+	err := out.init(logger, mqs, sp, mp)
 	return out, err
 }
 
 // MqModule is a module that provides the message queue.
 var MqModule = fx.Provide(
-	func(ap mfx.AppParams, mqs MQImplementations) (out MessageQueueResult, err error) {
+	func(
+		ap mfx.AppParams,
+		logger *zap.Logger,
+		mqs MQImplementations,
+		sp SchedulerParams,
+		mp MiddlewareParams,
+	) (out MessageQueueResult, err error) {
 		deployment := utility.ParseDeployments(ap.Deployment)
-		return CreateMessageQueueModule(deployment, mqs)
+		return CreateMessageQueueModule(logger, deployment, mqs, sp, mp)
 	},
 )
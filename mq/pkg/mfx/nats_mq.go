@@ -1,6 +1,8 @@
 package mfx
 
 import (
+	"context"
+
 	"go.uber.org/fx"
 	"go.uber.org/zap"
 
@@ -14,28 +16,41 @@ type NatsResult struct {
 	NatsMQ miface.MessageQueue `name:"NatsMQ"`
 }
 
-func (k *NatsResult) init(logger *zap.Logger, s SettingsParams) error {
-	mq, err := nats.NewMessageQueue(logger, s.NatsUrl)
+func (k *NatsResult) init(lc fx.Lifecycle, logger *zap.Logger, s SettingsParams) error {
+	var opts []nats.Option
+	if s.NatsJetStream {
+		opts = append(opts, nats.WithJetStream(nats.JetStream{
+			AckWait:    s.NatsAckWait,
+			MaxDeliver: s.NatsMaxDeliver,
+			NakDelay:   s.NatsNakDelay,
+		}))
+	}
+	mq, err := nats.NewMessageQueue(logger, s.NatsUrl, opts...)
 	if err != nil {
 		logger.Error("Nats message queue connect failure:",
 			zap.Error(err),
 			zap.String("address", utility.RedactURL(s.NatsUrl)))
 		return err
 	}
+	lc.Append(fx.Hook{
+		OnStop: func(context.Context) error {
+			return mq.Close()
+		},
+	})
 	k.NatsMQ = mq
 	return nil
 }
 
-// CreateNatsModule creates a new nats message queue module.
-func CreateNatsModule(l *zap.Logger, s SettingsParams) (NatsResult, error) {
+// CreateNatsModule creates a new nats message queue module, drained when the app stops.
+func CreateNatsModule(lc fx.Lifecycle, l *zap.Logger, s SettingsParams) (NatsResult, error) {
 	out := NatsResult{}
-	err := out.init(l, s)
+	err := out.init(lc, l, s)
 	return out, err
 }
 
 // NatsModule is the module for nats message queue
 var NatsModule = fx.Provide(
-	func(l *zap.Logger, s SettingsParams) (NatsResult, error) {
-		return CreateNatsModule(l, s)
+	func(lc fx.Lifecycle, l *zap.Logger, s SettingsParams) (NatsResult, error) {
+		return CreateNatsModule(lc, l, s)
 	},
 )
@@ -0,0 +1,49 @@
+package mfx
+
+import (
+	"context"
+
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+
+	"github.com/gstones/moke-kit/mq/internal/nsq"
+	"github.com/gstones/moke-kit/mq/miface"
+	"github.com/gstones/moke-kit/utility"
+)
+
+type NsqResult struct {
+	fx.Out
+	NsqMQ miface.MessageQueue `name:"NsqMQ"`
+}
+
+func (k *NsqResult) init(lc fx.Lifecycle, logger *zap.Logger, s SettingsParams) error {
+	mq, err := nsq.NewMessageQueue(logger, s.NsqUrl)
+	if err != nil {
+		logger.Error("Nsq message queue create failure:",
+			zap.Error(err),
+			zap.String("address", utility.RedactURL(s.NsqUrl)))
+		return err
+	}
+	lc.Append(fx.Hook{
+		OnStop: func(context.Context) error {
+			mq.Close()
+			return nil
+		},
+	})
+	k.NsqMQ = mq
+	return nil
+}
+
+// CreateNsqModule creates a new nsq message queue module, closed when the app stops.
+func CreateNsqModule(lc fx.Lifecycle, l *zap.Logger, s SettingsParams) (NsqResult, error) {
+	out := NsqResult{}
+	err := out.init(lc, l, s)
+	return out, err
+}
+
+// NsqModule is the module for nsq message queue
+var NsqModule = fx.Provide(
+	func(lc fx.Lifecycle, l *zap.Logger, s SettingsParams) (NsqResult, error) {
+		return CreateNsqModule(lc, l, s)
+	},
+)
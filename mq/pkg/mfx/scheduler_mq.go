@@ -0,0 +1,92 @@
+package mfx
+
+import (
+	goredis "github.com/redis/go-redis/v9"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+
+	"github.com/gstones/moke-kit/mq/internal"
+	"github.com/gstones/moke-kit/mq/internal/qerrors"
+	"github.com/gstones/moke-kit/mq/internal/scheduler"
+	"github.com/gstones/moke-kit/mq/miface"
+)
+
+type SchedulerParams struct {
+	fx.In
+
+	Scheduler miface.Scheduler `name:"MessageScheduler" optional:"true"`
+}
+
+type SchedulerResult struct {
+	fx.Out
+
+	Scheduler miface.Scheduler `name:"MessageScheduler"`
+}
+
+// SchedulerStoreParams are the clients a scheduler store may need, the redis one comes from the
+// orm redis module.
+type SchedulerStoreParams struct {
+	fx.In
+
+	Redis goredis.UniversalClient `name:"Redis" optional:"true"`
+}
+
+func (sr *SchedulerResult) init(
+	logger *zap.Logger,
+	lc fx.Lifecycle,
+	s SettingsParams,
+	mqs MQImplementations,
+	sp SchedulerStoreParams,
+) error {
+	var store scheduler.Store
+	switch s.SchedulerStore {
+	case "memory":
+		store = scheduler.NewMemoryStore()
+	case "redis":
+		if sp.Redis == nil {
+			logger.Error("message scheduler needs the redis module")
+			return qerrors.ErrSchedulerStoreUnsupported
+		}
+		store = scheduler.NewRedisStore(sp.Redis, s.SchedulerPrefix)
+	default:
+		logger.Error("unknown message scheduler store", zap.String("store", s.SchedulerStore))
+		return qerrors.ErrSchedulerStoreUnsupported
+	}
+
+	// due messages are published straight to the message queues, not back to the scheduler
+	mq := internal.NewMessageQueue(mqs.KafkaMQ, mqs.NatsMQ, mqs.NsqMQ, mqs.LocalMQ, nil)
+	sch := scheduler.New(store, mq.Publish, logger, scheduler.WithInterval(s.SchedulerInterval))
+	lc.Append(fx.Hook{
+		OnStart: sch.Start,
+		OnStop:  sch.Stop,
+	})
+	sr.Scheduler = sch
+	return nil
+}
+
+// CreateSchedulerModule creates the scheduler holding back delayed messages.
+func CreateSchedulerModule(
+	l *zap.Logger,
+	lc fx.Lifecycle,
+	s SettingsParams,
+	mqs MQImplementations,
+	sp SchedulerStoreParams,
+) (SchedulerResult, error) {
+	out := SchedulerResult{}
+	err := out.init(l, lc, s, mqs, sp)
+	return out, err
+}
+
+// SchedulerModule provides the MessageScheduler, MqModule then hands it the delayed messages of the
+// message queues without delayed delivery of their own.
+var SchedulerModule = fx.Provide(
+	func(
+		l *zap.Logger,
+		lc fx.Lifecycle,
+		s SettingsParams,
+		mqs MQImplementations,
+		sp SchedulerStoreParams,
+	) (SchedulerResult, error) {
+		return CreateSchedulerModule(l, lc, s, mqs, sp)
+	},
+)
@@ -1,6 +1,8 @@
 package mfx
 
 import (
+	"time"
+
 	"go.uber.org/fx"
 
 	"github.com/gstones/moke-kit/utility"
@@ -17,6 +19,22 @@ type SettingsParams struct {
 
 	// NatsUrl is the URL of the NATS server.
 	NatsUrl string `name:"NatsUrl"`
+	// NatsJetStream stores topics in JetStream streams instead of core NATS subjects.
+	NatsJetStream  bool          `name:"NatsJetStream"`
+	NatsAckWait    time.Duration `name:"NatsAckWait"`
+	NatsMaxDeliver int           `name:"NatsMaxDeliver"`
+	NatsNakDelay   time.Duration `name:"NatsNakDelay"`
+
+	// KafkaUrl lists the Kafka brokers: kafka://[user:password@]host1:9092,host2:9092[?tls=true&sasl=plain]
+	KafkaUrl string `name:"KafkaUrl"`
+
+	// NsqUrl is the nsqd to publish to: nsq://host:4150[?lookupd=host:4161,...&requeue_delay=1s]
+	NsqUrl string `name:"NsqUrl"`
+
+	// SchedulerStore keeps the messages of SchedulerModule: memory or redis.
+	SchedulerStore    string        `name:"SchedulerStore"`
+	SchedulerPrefix   string        `name:"SchedulerPrefix"`
+	SchedulerInterval time.Duration `name:"SchedulerInterval"`
 }
 
 type SettingsResult struct {
@@ -30,6 +48,22 @@ type SettingsResult struct {
 
 	// NatsUrl is the URL of the NATS server.
 	NatsUrl string `name:"NatsUrl" envconfig:"NATS_URL" default:"nats://localhost:4222"`
+	// NatsJetStream enables durable streams, redelivering messages not acked within NatsAckWait.
+	NatsJetStream  bool          `name:"NatsJetStream" envconfig:"NATS_JETSTREAM" default:"false"`
+	NatsAckWait    time.Duration `name:"NatsAckWait" envconfig:"NATS_ACK_WAIT" default:"30s"`
+	NatsMaxDeliver int           `name:"NatsMaxDeliver" envconfig:"NATS_MAX_DELIVER" default:"0"`
+	NatsNakDelay   time.Duration `name:"NatsNakDelay" envconfig:"NATS_NAK_DELAY" default:"100ms"`
+
+	// KafkaUrl lists the Kafka brokers.
+	KafkaUrl string `name:"KafkaUrl" envconfig:"KAFKA_URL" default:"kafka://localhost:9092"`
+
+	// NsqUrl is the nsqd to publish to, and to consume from without lookupd.
+	NsqUrl string `name:"NsqUrl" envconfig:"NSQ_URL" default:"nsq://localhost:4150"`
+
+	// SchedulerStore is memory, lost on restart, or redis, shared by the replicas.
+	SchedulerStore    string        `name:"SchedulerStore" envconfig:"MQ_SCHEDULER_STORE" default:"redis"`
+	SchedulerPrefix   string        `name:"SchedulerPrefix" envconfig:"MQ_SCHEDULER_PREFIX" default:"mq:scheduled"`
+	SchedulerInterval time.Duration `name:"SchedulerInterval" envconfig:"MQ_SCHEDULER_INTERVAL" default:"100ms"`
 }
 
 func (ar *SettingsResult) loadFromEnv() (err error) {
@@ -0,0 +1,88 @@
+package typed
+
+import (
+	"encoding/json"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/gstones/moke-kit/mq/common"
+	"github.com/gstones/moke-kit/mq/internal/qerrors"
+)
+
+// Codec encodes the values of typed messages, subscribers pick it by the content-type header.
+type Codec interface {
+	ContentType() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+var (
+	// JSON encodes values with encoding/json.
+	JSON Codec = jsonCodec{}
+	// Protobuf encodes proto.Message values in the protobuf wire format.
+	Protobuf Codec = protoCodec{}
+)
+
+var codecs = sync.Map{}
+
+func init() {
+	RegisterCodec(JSON)
+	RegisterCodec(Protobuf)
+}
+
+// RegisterCodec makes c the codec of its content type, replacing the codec registered before.
+func RegisterCodec(c Codec) {
+	codecs.Store(c.ContentType(), c)
+}
+
+// CodecFor returns the codec registered for contentType.
+func CodecFor(contentType string) (Codec, bool) {
+	if c, ok := codecs.Load(contentType); ok {
+		return c.(Codec), true
+	}
+	return nil, false
+}
+
+// defaultCodec is the codec of v when no content type says otherwise, Protobuf for proto messages
+// and JSON for anything else.
+func defaultCodec(v any) Codec {
+	if _, ok := v.(proto.Message); ok {
+		return Protobuf
+	}
+	return JSON
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string {
+	return common.ContentTypeJSON
+}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+type protoCodec struct{}
+
+func (protoCodec) ContentType() string {
+	return common.ContentTypeProtobuf
+}
+
+func (protoCodec) Marshal(v any) ([]byte, error) {
+	if m, ok := v.(proto.Message); ok {
+		return proto.Marshal(m)
+	}
+	return nil, qerrors.ErrNotProtoMessage
+}
+
+func (protoCodec) Unmarshal(data []byte, v any) error {
+	if m, ok := v.(proto.Message); ok {
+		return proto.Unmarshal(data, m)
+	}
+	return qerrors.ErrNotProtoMessage
+}
@@ -0,0 +1,83 @@
+package typed
+
+import (
+	"context"
+	"slices"
+
+	"github.com/gstones/moke-kit/mq/common"
+	"github.com/gstones/moke-kit/mq/internal/message"
+	"github.com/gstones/moke-kit/mq/internal/qerrors"
+	"github.com/gstones/moke-kit/mq/miface"
+)
+
+// Handler handles a message whose data was decoded into v, msg.VPtr() returns v as well. Like
+// miface.SubResponseHandler it is given the errors of the message queue, with a nil v.
+type Handler[T any] func(msg miface.Message, v *T, err error) common.ConsumptionCode
+
+// Publish encodes v with the codec of the content type set in opts, or with the default codec of
+// v, Protobuf for proto messages and JSON otherwise, and publishes it to topic.
+func Publish[T any](mq miface.MessageQueue, topic string, v T, opts ...miface.PubOption) error {
+	options, err := miface.NewPubOptions(opts...)
+	if err != nil {
+		return err
+	}
+	codec := defaultCodec(v)
+	if contentType, ok := options.Headers[common.HeaderContentType]; ok {
+		if codec, ok = CodecFor(contentType); !ok {
+			return qerrors.ErrUnknownContentType
+		}
+	}
+	data, err := codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return mq.Publish(topic, append(slices.Clip(opts), miface.WithBytes(data), miface.WithContentType(codec.ContentType()))...)
+}
+
+// Subscribe subscribes handler to topic, decoding the messages with the codec of their content-type
+// header, or with the default codec of *T for messages without one. Messages failing to decode go to
+// the WithDecodeErrorHandler of opts instead of handler, and are nacked as persistent failures
+// without one.
+func Subscribe[T any](
+	ctx context.Context,
+	mq miface.MessageQueue,
+	topic string,
+	handler Handler[T],
+	opts ...miface.SubOption,
+) (miface.Subscription, error) {
+	options, err := miface.NewSubOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	onDecodeError := options.DecodeError
+	if onDecodeError == nil {
+		onDecodeError = func(miface.Message, error) common.ConsumptionCode {
+			return common.ConsumeNackPersistentFailure
+		}
+	}
+	return mq.Subscribe(ctx, topic, func(msg miface.Message, err error) common.ConsumptionCode {
+		if msg == nil || err != nil {
+			return handler(msg, nil, err)
+		}
+		v, err := Decode[T](msg)
+		if err != nil {
+			return onDecodeError(msg, err)
+		}
+		return handler(message.WithVPtr(msg, v), v, nil)
+	}, opts...)
+}
+
+// Decode decodes the data of msg into a new T with the codec of its content-type header.
+func Decode[T any](msg miface.Message) (*T, error) {
+	v := new(T)
+	codec := defaultCodec(v)
+	if contentType, ok := msg.Headers()[common.HeaderContentType]; ok {
+		if codec, ok = CodecFor(contentType); !ok {
+			return nil, qerrors.ErrUnknownContentType
+		}
+	}
+	if err := codec.Unmarshal(msg.Data(), v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
@@ -0,0 +1,112 @@
+package typed
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/gstones/moke-kit/mq/common"
+	"github.com/gstones/moke-kit/mq/internal/local"
+	"github.com/gstones/moke-kit/mq/miface"
+)
+
+type order struct {
+	ID    string `json:"id"`
+	Price int    `json:"price"`
+}
+
+func receive[T any](t *testing.T, ch <-chan T) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the message")
+	}
+	panic("unreachable")
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	mq := local.NewMessageQueue(zap.NewNop(), 16, false, false)
+	got := make(chan miface.Message, 1)
+	sub, err := Subscribe(context.Background(), mq, "orders", func(msg miface.Message, v *order, err error) common.ConsumptionCode {
+		if err != nil || v.ID != "o-1" || v.Price != 100 || msg.VPtr() != v {
+			t.Errorf("unexpected message: %+v, %v", v, err)
+		}
+		got <- msg
+		return common.ConsumeAck
+	})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	if err := Publish(mq, "orders", order{ID: "o-1", Price: 100}); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if msg := receive(t, got); msg.Headers()[common.HeaderContentType] != common.ContentTypeJSON {
+		t.Fatalf("unexpected headers: %v", msg.Headers())
+	}
+}
+
+func TestProtobufRoundTrip(t *testing.T) {
+	mq := local.NewMessageQueue(zap.NewNop(), 16, false, false)
+	got := make(chan string, 1)
+	sub, err := Subscribe(context.Background(), mq, "names", func(msg miface.Message, v *wrapperspb.StringValue, err error) common.ConsumptionCode {
+		got <- v.GetValue()
+		return common.ConsumeAck
+	})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	if err := Publish(mq, "names", wrapperspb.String("sword")); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if v := receive(t, got); v != "sword" {
+		t.Fatalf("got %q", v)
+	}
+	// a JSON content type overrides the default codec of proto messages
+	if err := Publish(mq, "names", wrapperspb.String("shield"), miface.WithContentType(common.ContentTypeJSON)); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if v := receive(t, got); v != "shield" {
+		t.Fatalf("got %q", v)
+	}
+	if err := Publish(mq, "names", "x", miface.WithContentType("text/plain")); err == nil {
+		t.Fatal("expected unknown content type to be rejected")
+	}
+}
+
+func TestDecodeErrorsSkipHandler(t *testing.T) {
+	mq := local.NewMessageQueue(zap.NewNop(), 16, false, false)
+	failed := make(chan error, 2)
+	sub, err := Subscribe(context.Background(), mq, "orders", func(miface.Message, *order, error) common.ConsumptionCode {
+		t.Error("handler called with an undecodable message")
+		return common.ConsumeAck
+	}, miface.WithDecodeErrorHandler(func(msg miface.Message, err error) common.ConsumptionCode {
+		failed <- err
+		return common.ConsumeAck
+	}))
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	if err := mq.Publish("orders", miface.WithBytes([]byte("{")), miface.WithContentType(common.ContentTypeJSON)); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if err := receive(t, failed); err == nil {
+		t.Fatal("expected a decode error")
+	}
+	if err := mq.Publish("orders", miface.WithBytes([]byte("{}")), miface.WithContentType("text/csv")); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if err := receive(t, failed); err == nil {
+		t.Fatal("expected an unknown content type error")
+	}
+}
@@ -0,0 +1,39 @@
+package jsonx
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// Decode unmarshals JSON into generic values like json.Unmarshal, except that integral numbers
+// become int64 instead of float64, so the value survives a round trip through any document store.
+func Decode(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return Normalize(v), nil
+}
+
+// Normalize replaces every json.Number within v by an int64 or, failing that, a float64.
+func Normalize(v any) any {
+	switch t := v.(type) {
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i
+		}
+		f, _ := t.Float64()
+		return f
+	case []any:
+		for i := range t {
+			t[i] = Normalize(t[i])
+		}
+	case map[string]any:
+		for k := range t {
+			t[k] = Normalize(t[k])
+		}
+	}
+	return v
+}
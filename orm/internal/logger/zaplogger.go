@@ -0,0 +1,80 @@
+package logger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// ZapLoggerAdapter routes GORM logs through a zap logger.
+type ZapLoggerAdapter struct {
+	logger        *zap.Logger
+	level         gormlogger.LogLevel
+	slowThreshold time.Duration
+}
+
+// NewZapLoggerAdapter new gorm logger adapter, queries slower than slowThreshold are logged as warnings.
+func NewZapLoggerAdapter(logger *zap.Logger, slowThreshold time.Duration) *ZapLoggerAdapter {
+	return &ZapLoggerAdapter{
+		logger:        logger.WithOptions(zap.AddCallerSkip(3)),
+		level:         gormlogger.Warn,
+		slowThreshold: slowThreshold,
+	}
+}
+
+// LogMode returns a copy of the adapter logging at level.
+func (z *ZapLoggerAdapter) LogMode(level gormlogger.LogLevel) gormlogger.Interface {
+	l := *z
+	l.level = level
+	return &l
+}
+
+// Info gorm logger adapter info
+func (z *ZapLoggerAdapter) Info(_ context.Context, msg string, data ...any) {
+	if z.level >= gormlogger.Info {
+		z.logger.Info(fmt.Sprintf(msg, data...))
+	}
+}
+
+// Warn gorm logger adapter warn
+func (z *ZapLoggerAdapter) Warn(_ context.Context, msg string, data ...any) {
+	if z.level >= gormlogger.Warn {
+		z.logger.Warn(fmt.Sprintf(msg, data...))
+	}
+}
+
+// Error gorm logger adapter error
+func (z *ZapLoggerAdapter) Error(_ context.Context, msg string, data ...any) {
+	if z.level >= gormlogger.Error {
+		z.logger.Error(fmt.Sprintf(msg, data...))
+	}
+}
+
+// Trace logs failed and slow statements, and every statement at Info level.
+func (z *ZapLoggerAdapter) Trace(_ context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if z.level <= gormlogger.Silent {
+		return
+	}
+	elapsed := time.Since(begin)
+	fields := func() []zap.Field {
+		sql, rows := fc()
+		return []zap.Field{
+			zap.String("sql", sql),
+			zap.Int64("rows", rows),
+			zap.Duration("elapsed", elapsed),
+		}
+	}
+	switch {
+	case err != nil && z.level >= gormlogger.Error && !errors.Is(err, gorm.ErrRecordNotFound):
+		z.logger.Error("gorm query failed", append(fields(), zap.Error(err))...)
+	case z.slowThreshold > 0 && elapsed > z.slowThreshold && z.level >= gormlogger.Warn:
+		z.logger.Warn("gorm slow query", append(fields(), zap.Duration("threshold", z.slowThreshold))...)
+	case z.level >= gormlogger.Info:
+		z.logger.Debug("gorm query", fields()...)
+	}
+}
@@ -0,0 +1,83 @@
+package tracing
+
+import (
+	"errors"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const (
+	instrumentationName = "github.com/gstones/moke-kit/orm"
+	spanInstanceKey     = "otel:span"
+)
+
+// Plugin is a gorm.Plugin that wraps every statement in an OTel client span.
+// Spans are children of the span found in the statement context (db.WithContext).
+type Plugin struct {
+	tracer trace.Tracer
+}
+
+// NewPlugin creates a tracing plugin using the global tracer provider.
+func NewPlugin() *Plugin {
+	return &Plugin{tracer: otel.Tracer(instrumentationName)}
+}
+
+// Name implements gorm.Plugin.
+func (p *Plugin) Name() string {
+	return "otel"
+}
+
+// Initialize implements gorm.Plugin by registering before/after callbacks around every operation.
+func (p *Plugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	return errors.Join(
+		cb.Create().Before("gorm:create").Register("otel:before_create", p.before("create")),
+		cb.Create().After("gorm:create").Register("otel:after_create", p.after),
+		cb.Query().Before("gorm:query").Register("otel:before_query", p.before("query")),
+		cb.Query().After("gorm:query").Register("otel:after_query", p.after),
+		cb.Update().Before("gorm:update").Register("otel:before_update", p.before("update")),
+		cb.Update().After("gorm:update").Register("otel:after_update", p.after),
+		cb.Delete().Before("gorm:delete").Register("otel:before_delete", p.before("delete")),
+		cb.Delete().After("gorm:delete").Register("otel:after_delete", p.after),
+		cb.Row().Before("gorm:row").Register("otel:before_row", p.before("row")),
+		cb.Row().After("gorm:row").Register("otel:after_row", p.after),
+		cb.Raw().Before("gorm:raw").Register("otel:before_raw", p.before("raw")),
+		cb.Raw().After("gorm:raw").Register("otel:after_raw", p.after),
+	)
+}
+
+func (p *Plugin) before(op string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		ctx, span := p.tracer.Start(db.Statement.Context, "gorm."+op, trace.WithSpanKind(trace.SpanKindClient))
+		db.Statement.Context = ctx
+		db.InstanceSet(spanInstanceKey, span)
+	}
+}
+
+func (p *Plugin) after(db *gorm.DB) {
+	v, ok := db.InstanceGet(spanInstanceKey)
+	if !ok {
+		return
+	}
+	span, ok := v.(trace.Span)
+	if !ok {
+		return
+	}
+	defer span.End()
+
+	span.SetAttributes(
+		semconv.DBSystemKey.String(db.Dialector.Name()),
+		semconv.DBStatementKey.String(db.Statement.SQL.String()),
+		attribute.String("db.sql.table", db.Statement.Table),
+		attribute.Int64("db.rows_affected", db.Statement.RowsAffected),
+	)
+	if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+		span.RecordError(db.Error)
+		span.SetStatus(codes.Error, db.Error.Error())
+	}
+}
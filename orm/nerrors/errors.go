@@ -5,16 +5,32 @@ import (
 )
 
 var (
-	ErrNotFound           = errors.New("ErrNotFound")
-	ErrVersionNotMatch    = errors.New("ErrVersionNotMatch")
-	ErrMissingNosqlURL    = errors.New("ErrMissingNosqlURL")
-	ErrInvalidNosqlURL    = errors.New("ErrInvalidNosqlURL")
-	ErrAnyVersionConflict = errors.New("ErrAnyVersionConflict")
-	ErrDestIsNil          = errors.New("ErrDestIsNil")
-	ErrDestMustBePointer  = errors.New("ErrDestMustBePointer")
-	ErrKeyNotFound        = errors.New("ErrKeyNotFound")
-	ErrDocumentStoreIsNil = errors.New("ErrDocumentStoreIsNil")
-	ErrSourceIsNil        = errors.New("ErrSourceIsNil")
-	ErrTooManyRetries     = errors.New("ErrTooManyRetries")
-	ErrUpdateLogicFailed  = errors.New("ErrUpdateLogicFailed")
+	ErrNotFound             = errors.New("ErrNotFound")
+	ErrVersionNotMatch      = errors.New("ErrVersionNotMatch")
+	ErrMissingNosqlURL      = errors.New("ErrMissingNosqlURL")
+	ErrInvalidNosqlURL      = errors.New("ErrInvalidNosqlURL")
+	ErrAnyVersionConflict   = errors.New("ErrAnyVersionConflict")
+	ErrDestIsNil            = errors.New("ErrDestIsNil")
+	ErrDestMustBePointer    = errors.New("ErrDestMustBePointer")
+	ErrKeyNotFound          = errors.New("ErrKeyNotFound")
+	ErrDocumentStoreIsNil   = errors.New("ErrDocumentStoreIsNil")
+	ErrSourceIsNil          = errors.New("ErrSourceIsNil")
+	ErrTooManyRetries       = errors.New("ErrTooManyRetries")
+	ErrUpdateLogicFailed    = errors.New("ErrUpdateLogicFailed")
+	ErrNoFallbackProvider   = errors.New("ErrNoFallbackProvider")
+	ErrNoRouteProvider      = errors.New("ErrNoRouteProvider")
+	ErrInvalidRoutePrefix   = errors.New("ErrInvalidRoutePrefix")
+	ErrInvalidRouteSpec     = errors.New("ErrInvalidRouteSpec")
+	ErrMissingGormDB        = errors.New("ErrMissingGormDB")
+	ErrMissingMongoClient   = errors.New("ErrMissingMongoClient")
+	ErrInvalidSqlURL        = errors.New("ErrInvalidSqlURL")
+	ErrInvalidPageSize      = errors.New("ErrInvalidPageSize")
+	ErrScanNotSupported     = errors.New("ErrScanNotSupported")
+	ErrInvalidBackup        = errors.New("ErrInvalidBackup")
+	ErrInvalidEncryptionKey = errors.New("ErrInvalidEncryptionKey")
+	ErrUnknownKeyID         = errors.New("ErrUnknownKeyID")
+	ErrDecryptFailed        = errors.New("ErrDecryptFailed")
+	ErrCorruptEventLog      = errors.New("ErrCorruptEventLog")
+	ErrEventPublishFailed   = errors.New("ErrEventPublishFailed")
+	ErrOutboxNotSupported   = errors.New("ErrOutboxNotSupported")
 )
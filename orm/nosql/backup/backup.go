@@ -0,0 +1,251 @@
+// Package backup exports documents of an ICollection to JSON Lines and restores them.
+//
+// Every line is a Record holding the key, the version at export time and the JSON data.
+// Versions are informational: a restore writes through ICollection.Set, so restored
+// documents start a new version history. Mongo collections export data as relaxed
+// extended JSON; {"$date": ...} values are restored as dates.
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/gstones/moke-kit/orm/internal/jsonx"
+	"github.com/gstones/moke-kit/orm/nerrors"
+	"github.com/gstones/moke-kit/orm/nosql/diface"
+	"github.com/gstones/moke-kit/orm/nosql/key"
+	"github.com/gstones/moke-kit/orm/nosql/noptions"
+)
+
+// maxLineSize bounds a single exported document.
+const maxLineSize = 16 << 20
+
+// Record is one line of a backup.
+type Record struct {
+	Key     string           `json:"key"`
+	Version noptions.Version `json:"version"`
+	Data    json.RawMessage  `json:"data"`
+}
+
+// Mode decides what a restore does with documents that already exist.
+type Mode int
+
+const (
+	// CreateOnly fails the restore on the first document that already exists.
+	CreateOnly Mode = iota
+	// Overwrite replaces existing documents.
+	Overwrite
+	// SkipExisting keeps existing documents and restores the rest.
+	SkipExisting
+)
+
+// Options configures Export and Import.
+type Options struct {
+	Gzip          bool
+	Mode          Mode
+	FromNamespace string
+	ToNamespace   string
+}
+
+// Option is a closure that updates Options.
+type Option func(o *Options)
+
+// WithGzip compresses the export. Import detects gzip input by itself.
+func WithGzip() Option {
+	return func(o *Options) {
+		o.Gzip = true
+	}
+}
+
+// WithMode sets the restore Mode, CreateOnly by default.
+func WithMode(m Mode) Option {
+	return func(o *Options) {
+		o.Mode = m
+	}
+}
+
+// WithNamespace rewrites keys whose first section is from to start with to instead,
+// e.g. to copy /staging/... documents into /prod/... . Other keys are kept as they are.
+func WithNamespace(from, to string) Option {
+	return func(o *Options) {
+		o.FromNamespace = from
+		o.ToNamespace = to
+	}
+}
+
+func newOptions(opts ...Option) Options {
+	var o Options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// Result counts what a restore did.
+type Result struct {
+	Read    int
+	Written int
+	Skipped int
+}
+
+// Export writes every document of coll under prefix (see diface.IScanner) to w and returns
+// how many were written. WithNamespace rewrites keys on the way out.
+// It fails with ErrScanNotSupported if coll cannot scan.
+func Export(ctx context.Context, coll diface.ICollection, w io.Writer, prefix string, opts ...Option) (int, error) {
+	scanner, ok := coll.(diface.IScanner)
+	if !ok {
+		return 0, nerrors.ErrScanNotSupported
+	}
+	o := newOptions(opts...)
+
+	out := w
+	var zw *gzip.Writer
+	if o.Gzip {
+		zw = gzip.NewWriter(w)
+		out = zw
+	}
+	bw := bufio.NewWriter(out)
+	enc := json.NewEncoder(bw)
+
+	count := 0
+	err := scanner.Scan(ctx, prefix, func(k key.Key, version noptions.Version, data []byte) error {
+		count++
+		return enc.Encode(Record{
+			Key:     o.rewrite(k.String()),
+			Version: version,
+			Data:    data,
+		})
+	})
+	if err != nil {
+		return count, err
+	}
+	if err := bw.Flush(); err != nil {
+		return count, err
+	}
+	if zw != nil {
+		return count, zw.Close()
+	}
+	return count, nil
+}
+
+// Import restores the records read from r into coll according to the Mode.
+// WithNamespace rewrites keys on the way in.
+func Import(ctx context.Context, coll diface.ICollection, r io.Reader, opts ...Option) (Result, error) {
+	o := newOptions(opts...)
+	var res Result
+
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return res, err
+		}
+		defer zr.Close()
+		br = bufio.NewReader(zr)
+	}
+
+	lines := bufio.NewScanner(br)
+	lines.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	for line := 1; lines.Scan(); line++ {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		if len(bytes.TrimSpace(lines.Bytes())) == 0 {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(lines.Bytes(), &rec); err != nil {
+			return res, errors.Wrapf(nerrors.ErrInvalidBackup, "line %d: %v", line, err)
+		}
+		k, err := key.NewKeyFromString(o.rewrite(rec.Key))
+		if err != nil {
+			return res, errors.Wrapf(nerrors.ErrInvalidBackup, "line %d: key %q: %v", line, rec.Key, err)
+		}
+		src, err := decodeData(rec.Data)
+		if err != nil {
+			return res, errors.Wrapf(nerrors.ErrInvalidBackup, "line %d: %v", line, err)
+		}
+		res.Read++
+
+		setOpts := []noptions.Option{noptions.WithSource(src)}
+		if o.Mode == Overwrite {
+			setOpts = append(setOpts, noptions.WithAnyVersion())
+		}
+		if _, err := coll.Set(ctx, k, setOpts...); err != nil {
+			if o.Mode == SkipExisting && errors.Is(err, nerrors.ErrVersionNotMatch) {
+				res.Skipped++
+				continue
+			}
+			return res, errors.Wrapf(err, "restore %s", k.String())
+		}
+		res.Written++
+	}
+	return res, lines.Err()
+}
+
+func (o Options) rewrite(k string) string {
+	if o.FromNamespace == "" {
+		return k
+	}
+	from := key.KeySeparator + o.FromNamespace + key.KeySeparator
+	if !strings.HasPrefix(k, from) {
+		return k
+	}
+	return key.KeySeparator + o.ToNamespace + key.KeySeparator + k[len(from):]
+}
+
+// decodeData turns exported JSON into a value every driver stores faithfully: integral
+// numbers become int64 rather than float64, and extended JSON dates become time.Time.
+func decodeData(data json.RawMessage) (any, error) {
+	if len(data) == 0 {
+		return nil, nerrors.ErrSourceIsNil
+	}
+	v, err := jsonx.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	return dates(v), nil
+}
+
+func dates(v any) any {
+	switch t := v.(type) {
+	case []any:
+		for i := range t {
+			t[i] = dates(t[i])
+		}
+	case map[string]any:
+		if d, ok := t["$date"]; ok && len(t) == 1 {
+			if ts, ok := extDate(d); ok {
+				return ts
+			}
+		}
+		for k := range t {
+			t[k] = dates(t[k])
+		}
+	}
+	return v
+}
+
+// extDate reads the relaxed {"$date":"RFC 3339"} and canonical {"$date":{"$numberLong":"ms"}} forms.
+func extDate(v any) (time.Time, bool) {
+	switch d := v.(type) {
+	case string:
+		ts, err := time.Parse(time.RFC3339Nano, d)
+		return ts, err == nil
+	case map[string]any:
+		if n, ok := d["$numberLong"].(string); ok {
+			ms, err := strconv.ParseInt(n, 10, 64)
+			return time.UnixMilli(ms).UTC(), err == nil
+		}
+	}
+	return time.Time{}, false
+}
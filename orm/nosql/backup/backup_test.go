@@ -0,0 +1,137 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/gstones/moke-kit/orm/nerrors"
+	"github.com/gstones/moke-kit/orm/nosql/diface"
+	"github.com/gstones/moke-kit/orm/nosql/key"
+	"github.com/gstones/moke-kit/orm/nosql/mock"
+	"github.com/gstones/moke-kit/orm/nosql/noptions"
+)
+
+type player struct {
+	Name  string    `json:"name"`
+	Level int       `json:"level"`
+	Seen  time.Time `json:"seen"`
+}
+
+func openMock(t *testing.T) diface.ICollection {
+	t.Helper()
+	coll, err := mock.NewMockDriverProvider(zap.NewNop()).OpenDbDriver("game")
+	require.NoError(t, err)
+	return coll
+}
+
+func seed(t *testing.T, coll diface.ICollection, docs map[string]player) {
+	t.Helper()
+	for k, p := range docs {
+		_, err := coll.Set(context.Background(), key.NewKey(k), noptions.WithSource(p))
+		require.NoError(t, err)
+	}
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	seen := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	src := openMock(t)
+	seed(t, src, map[string]player{
+		"/staging/player/1":      {Name: "alice", Level: 3, Seen: seen},
+		"/staging/player/2":      {Name: "bob", Level: 7, Seen: seen},
+		"/staging/player/2/bag":  {Name: "bag"},
+		"/staging/playerx/3":     {Name: "not below /staging/player"},
+		"/staging/guild/blue/hq": {Name: "hq"},
+	})
+
+	for _, gz := range []bool{false, true} {
+		var buf bytes.Buffer
+		var opts []Option
+		if gz {
+			opts = append(opts, WithGzip())
+		}
+		n, err := Export(ctx, src, &buf, "/staging/player", opts...)
+		require.NoError(t, err)
+		require.Equal(t, 3, n)
+		if !gz {
+			require.Equal(t, 3, strings.Count(buf.String(), "\n"))
+			require.Contains(t, buf.String(), `"key":"/staging/player/1"`)
+		}
+
+		dst := openMock(t)
+		res, err := Import(ctx, dst, &buf, WithNamespace("staging", "prod"))
+		require.NoError(t, err)
+		require.Equal(t, Result{Read: 3, Written: 3}, res)
+
+		var got player
+		_, err = dst.Get(ctx, key.NewKey("/prod/player/2"), noptions.WithDestination(&got))
+		require.NoError(t, err)
+		require.Equal(t, player{Name: "bob", Level: 7, Seen: seen}, got)
+		_, err = dst.Get(ctx, key.NewKey("/prod/playerx/3"))
+		require.ErrorIs(t, err, nerrors.ErrNotFound)
+	}
+}
+
+func TestImportModes(t *testing.T) {
+	ctx := context.Background()
+	src := openMock(t)
+	seed(t, src, map[string]player{
+		"/game/player/1": {Name: "backup-1"},
+		"/game/player/2": {Name: "backup-2"},
+	})
+	var buf bytes.Buffer
+	_, err := Export(ctx, src, &buf, "")
+	require.NoError(t, err)
+	dump := buf.String()
+
+	restore := func(mode Mode) (diface.ICollection, Result, error) {
+		dst := openMock(t)
+		seed(t, dst, map[string]player{"/game/player/1": {Name: "live"}})
+		res, err := Import(ctx, dst, strings.NewReader(dump), WithMode(mode))
+		return dst, res, err
+	}
+	name := func(coll diface.ICollection, k string) string {
+		var p player
+		_, err := coll.Get(ctx, key.NewKey(k), noptions.WithDestination(&p))
+		require.NoError(t, err)
+		return p.Name
+	}
+
+	_, _, err = restore(CreateOnly)
+	require.ErrorIs(t, err, nerrors.ErrVersionNotMatch)
+
+	dst, res, err := restore(SkipExisting)
+	require.NoError(t, err)
+	require.Equal(t, Result{Read: 2, Written: 1, Skipped: 1}, res)
+	require.Equal(t, "live", name(dst, "/game/player/1"))
+	require.Equal(t, "backup-2", name(dst, "/game/player/2"))
+
+	dst, res, err = restore(Overwrite)
+	require.NoError(t, err)
+	require.Equal(t, Result{Read: 2, Written: 2}, res)
+	require.Equal(t, "backup-1", name(dst, "/game/player/1"))
+}
+
+func TestImportInvalid(t *testing.T) {
+	ctx := context.Background()
+	_, err := Import(ctx, openMock(t), strings.NewReader("{\"key\":\"bad\",\"data\":{}}\n"))
+	require.ErrorIs(t, err, nerrors.ErrInvalidBackup)
+	_, err = Import(ctx, openMock(t), strings.NewReader("not json\n"))
+	require.ErrorIs(t, err, nerrors.ErrInvalidBackup)
+}
+
+func TestDecodeData(t *testing.T) {
+	v, err := decodeData([]byte(`{"n":3,"f":1.5,"d":{"$date":"2024-05-01T12:00:00Z"},"l":[{"$date":{"$numberLong":"0"}}]}`))
+	require.NoError(t, err)
+	m := v.(map[string]any)
+	require.Equal(t, int64(3), m["n"])
+	require.Equal(t, 1.5, m["f"])
+	require.Equal(t, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), m["d"])
+	require.Equal(t, time.Unix(0, 0).UTC(), m["l"].([]any)[0])
+}
@@ -21,17 +21,17 @@ var (
 // RedisCache is a redis cache
 type RedisCache struct {
 	logger *zap.Logger
-	*redis.Client
+	redis.UniversalClient
 }
 
 // CreateRedisCache creates a redis cache
-func CreateRedisCache(logger *zap.Logger, client *redis.Client) *RedisCache {
+func CreateRedisCache(logger *zap.Logger, client redis.UniversalClient) *RedisCache {
 	return &RedisCache{logger, client}
 }
 
 // GetCache gets cache
 func (c *RedisCache) GetCache(ctx context.Context, key key.Key, doc any) bool {
-	if c == nil || c.Client == nil {
+	if c == nil || c.UniversalClient == nil {
 		return false
 	}
 	if res := c.Get(ctx, key.String()); res.Err() != nil {
@@ -46,7 +46,7 @@ func (c *RedisCache) GetCache(ctx context.Context, key key.Key, doc any) bool {
 
 // SetCache sets cache
 func (c *RedisCache) SetCache(ctx context.Context, key key.Key, doc any, expire time.Duration) {
-	if c == nil || c.Client == nil {
+	if c == nil || c.UniversalClient == nil {
 		return
 	}
 	if data, err := json.Marshal(doc); err != nil {
@@ -58,7 +58,7 @@ func (c *RedisCache) SetCache(ctx context.Context, key key.Key, doc any, expire
 
 // DeleteCache deletes cache
 func (c *RedisCache) DeleteCache(ctx context.Context, key key.Key) {
-	if c == nil || c.Client == nil {
+	if c == nil || c.UniversalClient == nil {
 		return
 	}
 	if res := c.Del(ctx, key.String()); res.Err() != nil {
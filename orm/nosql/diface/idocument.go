@@ -34,3 +34,11 @@ type ICollection interface {
 	// Incr increments a nosql from the nosql store. (tips: can not be used for document,because the version)
 	Incr(ctx context.Context, key key.Key, field string, amount int32) (int64, error)
 }
+
+// IScanner is implemented by collections that can list their documents, e.g. for backups.
+type IScanner interface {
+	// Scan calls fn for every document whose key equals prefix or lies below it ("/player" matches
+	// "/player/1" and "/player/1/inventory"), passing the key, version and JSON encoded data.
+	// An empty prefix scans every document. Returning an error from fn stops the scan.
+	Scan(ctx context.Context, prefix string, fn func(k key.Key, version noptions.Version, data []byte) error) error
+}
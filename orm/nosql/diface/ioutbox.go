@@ -0,0 +1,36 @@
+package diface
+
+import (
+	"context"
+	"time"
+)
+
+// OutboxMessage is a message staged for publishing to the message queue.
+type OutboxMessage struct {
+	ID        string    `json:"id" bson:"_id"`
+	Topic     string    `json:"topic" bson:"topic"`
+	Data      []byte    `json:"data" bson:"data"`
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+	// Attempts counts failed publish attempts, NextAttempt is when the relay tries again.
+	Attempts    int       `json:"attempts" bson:"attempts"`
+	NextAttempt time.Time `json:"nextAttempt" bson:"nextAttempt"`
+	LastError   string    `json:"lastError,omitempty" bson:"lastError,omitempty"`
+}
+
+// IOutbox stages messages atomically with document writes, for a relay to publish them later.
+type IOutbox interface {
+	// Commit runs write and stages msgs as one unit: if write fails nothing is staged, and if staging
+	// fails the writes are rolled back. write must pass the ctx it is given to the ICollection calls.
+	// Messages without an ID get one.
+	Commit(ctx context.Context, write func(ctx context.Context) error, msgs ...OutboxMessage) error
+	// Pending returns up to limit unsent messages whose NextAttempt has passed, oldest first.
+	Pending(ctx context.Context, limit int) ([]OutboxMessage, error)
+	// Claim returns up to limit pending messages like Pending, and hides them from other claims for
+	// lease by moving their NextAttempt, so relays of several replicas do not publish the same
+	// messages and a message whose publishing was interrupted is claimed again afterwards.
+	Claim(ctx context.Context, limit int, lease time.Duration) ([]OutboxMessage, error)
+	// MarkSent records that a message was published, so it is not returned by Pending again.
+	MarkSent(ctx context.Context, id string) error
+	// MarkFailed records a failed publish attempt and when to retry it.
+	MarkFailed(ctx context.Context, id string, cause error, next time.Time) error
+}
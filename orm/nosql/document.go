@@ -2,21 +2,18 @@ package nosql
 
 import (
 	"context"
-	"math"
-	"math/rand"
 	"time"
 
-	"github.com/pkg/errors"
-
 	"github.com/gstones/moke-kit/orm/nerrors"
 	"github.com/gstones/moke-kit/orm/nosql/diface"
 	"github.com/gstones/moke-kit/orm/nosql/key"
 	"github.com/gstones/moke-kit/orm/nosql/noptions"
+	"github.com/gstones/moke-kit/orm/retry"
 )
 
 const (
-	// MaxRetries is the maximum number of retries for update operations
-	MaxRetries = 5
+	// MaxRetries is the default maximum number of attempts of update operations, see SetRetryPolicy
+	MaxRetries = retry.MaxRetries
 	// DefaultCacheTTL is the default cache TTL for read-through caching
 	DefaultCacheTTL = 30 * time.Minute
 )
@@ -32,6 +29,9 @@ type DocumentBase struct {
 	DocumentStore diface.ICollection
 	cache         diface.ICache
 	ctx           context.Context
+
+	behind *writeBehindEntry
+	retry  *retry.Policy
 }
 
 // Init performs an in-place initialization of a DocumentBase.
@@ -80,6 +80,9 @@ func (d *DocumentBase) Create() error {
 		return err
 	}
 	d.version = version
+	if d.behind != nil {
+		d.behind.reset(version)
+	}
 	return nil
 }
 
@@ -91,6 +94,11 @@ type VersionCache struct {
 
 // Load implements Read-Through caching
 func (d *DocumentBase) Load() error {
+	if d.behind != nil {
+		if ok, err := d.behind.restore(d); ok {
+			return err
+		}
+	}
 	d.clear()
 	cache := &VersionCache{
 		Version: &d.version,
@@ -99,6 +107,9 @@ func (d *DocumentBase) Load() error {
 
 	// Try cache first
 	if d.cache.GetCache(d.ctx, d.Key, cache) {
+		if d.behind != nil {
+			d.behind.loaded(d.version)
+		}
 		return nil
 	}
 
@@ -113,6 +124,9 @@ func (d *DocumentBase) Load() error {
 	}
 
 	d.version = version
+	if d.behind != nil {
+		d.behind.loaded(version)
+	}
 	// Update cache after loading from database
 	d.cache.SetCache(d.ctx, d.Key, &VersionCache{
 		Version: d.version,
@@ -122,8 +136,11 @@ func (d *DocumentBase) Load() error {
 	return nil
 }
 
-// Save implements synchronous write with cache update
+// Save implements synchronous write with cache update, or a deferred one with EnableWriteBehind.
 func (d *DocumentBase) Save() error {
+	if d.behind != nil {
+		return d.behind.save(d)
+	}
 	// 直接同步写入数据库
 	version, err := d.DocumentStore.Set(
 		d.ctx,
@@ -145,36 +162,51 @@ func (d *DocumentBase) Save() error {
 	return nil
 }
 
+// SetRetryPolicy sets how Update retries this document, instead of retry.Default().
+func (d *DocumentBase) SetRetryPolicy(p retry.Policy) {
+	d.retry = &p
+}
+
+// RetryPolicy returns the policy Update retries with.
+func (d *DocumentBase) RetryPolicy() retry.Policy {
+	if d.retry != nil {
+		return *d.retry
+	}
+	return retry.Default()
+}
+
 func (d *DocumentBase) doUpdate(f func() bool, u func() error) error {
-	var lastErr error
-	for r := 0; r < MaxRetries; r++ {
+	p := d.RetryPolicy()
+	start := time.Now()
+	for attempt := 0; ; attempt++ {
 		if !f() {
 			return nerrors.ErrUpdateLogicFailed
 		}
 
-		if err := u(); err == nil {
+		err := u()
+		if err == nil {
 			return nil
-		} else {
-			lastErr = err
-			// Exponential backoff with jitter
-			backoff := time.Duration(math.Pow(2, float64(r))) * time.Millisecond
-			jitter := time.Duration(rand.Float64() * float64(backoff))
-			time.Sleep(backoff + jitter)
-
-			if err := d.Load(); err != nil {
-				return err
-			}
+		} else if !p.IsRetryable(err) {
+			return err
+		}
+		delay, ok := p.Next(attempt, start)
+		if !ok {
+			return retry.NewError(attempt+1, err)
+		}
+		if err := retry.Wait(d.ctx, delay); err != nil {
+			return err
+		}
+
+		if err := d.Load(); err != nil {
+			return err
 		}
 	}
-	if lastErr != nil {
-		return errors.Wrap(nerrors.ErrTooManyRetries, lastErr.Error())
-	}
-	return errors.Wrap(nerrors.ErrTooManyRetries, "no underlying error")
 }
 
 // Update change the data with the given function and CAS(compare and swap) save it to the database.
 // If the function returns false, the update will be aborted.
-// If the update CAS fails, the function will be retried up to MaxRetries times with a randomized backoff.
+// If the update CAS fails, the document is reloaded and the function retried as the RetryPolicy allows;
+// when it gives up, the returned *retry.Error holds the attempt count and the last conflict.
 func (d *DocumentBase) Update(f func() bool) error {
 	if err := d.doUpdate(f, func() error {
 		return d.Save()
@@ -187,9 +219,57 @@ func (d *DocumentBase) Update(f func() bool) error {
 
 // Delete delete data from the database.
 func (d *DocumentBase) Delete() error {
+	if d.behind != nil {
+		d.behind.reset(noptions.NoVersion)
+	}
 	if err := d.DocumentStore.Delete(d.ctx, d.Key); err != nil {
 		return err
 	}
 	d.cache.DeleteCache(d.ctx, d.Key)
 	return nil
 }
+
+// CreateWithOutbox creates the document and stages msgs in the same outbox commit, so the messages
+// are published if and only if the document is created.
+func (d *DocumentBase) CreateWithOutbox(ob diface.IOutbox, msgs ...diface.OutboxMessage) error {
+	var version noptions.Version
+	if err := ob.Commit(d.ctx, func(ctx context.Context) (err error) {
+		version, err = d.DocumentStore.Set(ctx, d.Key, noptions.WithSource(d.data))
+		return
+	}, msgs...); err != nil {
+		return err
+	}
+	d.version = version
+	return nil
+}
+
+// SaveWithOutbox CAS saves the document and stages msgs in the same outbox commit. The version and
+// cache are only updated once the commit succeeded.
+func (d *DocumentBase) SaveWithOutbox(ob diface.IOutbox, msgs ...diface.OutboxMessage) error {
+	var version noptions.Version
+	if err := ob.Commit(d.ctx, func(ctx context.Context) (err error) {
+		version, err = d.DocumentStore.Set(
+			ctx,
+			d.Key,
+			noptions.WithSource(d.data),
+			noptions.WithVersion(d.version),
+		)
+		return
+	}, msgs...); err != nil {
+		return err
+	}
+	d.version = version
+
+	d.cache.SetCache(d.ctx, d.Key, &VersionCache{
+		Version: d.version,
+		Data:    d.data,
+	}, DefaultCacheTTL)
+	return nil
+}
+
+// UpdateWithOutbox is Update with the final save going through SaveWithOutbox.
+func (d *DocumentBase) UpdateWithOutbox(ob diface.IOutbox, f func() bool, msgs ...diface.OutboxMessage) error {
+	return d.doUpdate(f, func() error {
+		return d.SaveWithOutbox(ob, msgs...)
+	})
+}
@@ -11,9 +11,11 @@ import (
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap/zaptest"
 
+	"github.com/gstones/moke-kit/orm/nerrors"
 	"github.com/gstones/moke-kit/orm/nosql/key"
 	"github.com/gstones/moke-kit/orm/nosql/mock"
 	"github.com/gstones/moke-kit/orm/nosql/noptions"
+	"github.com/gstones/moke-kit/orm/retry"
 )
 
 type docPayload struct {
@@ -115,3 +117,75 @@ func TestDocumentBase_ConcurrentUpdates(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, noptions.Version(workers+1), ver, "expected version after create + %d updates", workers)
 }
+
+func TestDocumentBase_RetryPolicy(t *testing.T) {
+	t.Parallel()
+
+	coll, err := mock.NewMockDriverProvider(zaptest.NewLogger(t)).OpenDbDriver("doc-retry")
+	require.NoError(t, err)
+	k, err := key.NewKeyFromParts("guild", "1")
+	require.NoError(t, err)
+
+	seed := &testDoc{Data: &docPayload{Message: "seed"}}
+	seed.Init(context.Background(), &seed.Data, func() { seed.Data = nil }, coll, k)
+	require.NoError(t, seed.Create())
+
+	// every attempt loses against a concurrent writer
+	doc := &testDoc{Data: &docPayload{}}
+	doc.Init(context.Background(), &doc.Data, func() { doc.Data = nil }, coll, k)
+	require.NoError(t, doc.Load())
+	doc.SetRetryPolicy(retry.Policy{
+		MaxAttempts: 3,
+		BaseDelay:   time.Microsecond,
+		Jitter:      retry.NoJitter,
+		Retryable:   retry.OnConflict,
+	})
+	calls := 0
+	err = doc.Update(func() bool {
+		calls++
+		require.NoError(t, seed.Update(func() bool {
+			seed.Data.Message = fmt.Sprintf("other %d", calls)
+			return true
+		}))
+		doc.Data.Message = "mine"
+		return true
+	})
+	require.ErrorIs(t, err, nerrors.ErrTooManyRetries)
+	require.ErrorIs(t, err, nerrors.ErrVersionNotMatch)
+	var retryErr *retry.Error
+	require.ErrorAs(t, err, &retryErr)
+	require.Equal(t, 3, retryErr.Attempts)
+	require.Equal(t, 3, calls)
+
+	// errors the predicate rejects are returned at once
+	doc.SetRetryPolicy(retry.Policy{
+		MaxAttempts: 3,
+		Retryable:   func(error) bool { return false },
+	})
+	calls = 0
+	err = doc.Update(func() bool {
+		calls++
+		return true
+	})
+	require.ErrorIs(t, err, nerrors.ErrVersionNotMatch)
+	require.Equal(t, 1, calls)
+
+	// the deadline stops retrying before the attempts run out
+	doc.SetRetryPolicy(retry.Policy{
+		MaxAttempts: 100,
+		BaseDelay:   20 * time.Millisecond,
+		Jitter:      retry.NoJitter,
+		Deadline:    50 * time.Millisecond,
+	})
+	calls = 0
+	err = doc.Update(func() bool {
+		calls++
+		require.NoError(t, seed.Update(func() bool {
+			seed.Data.Message = "again"
+			return true
+		}))
+		return true
+	})
+	require.ErrorAs(t, err, &retryErr)
+	require.Equal(t, 2, retryErr.Attempts)
+}
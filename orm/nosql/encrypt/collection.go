@@ -0,0 +1,129 @@
+// Package encrypt seals tagged struct fields before documents reach the store.
+//
+// Tag a field with `encrypt:"true"` and open collections through NewCollection or NewProvider.
+// Each value is encrypted with AES-256-GCM under its own random data key, which is wrapped by
+// a key encryption key from the Keyring and stored with that key's id, so keys can be rotated
+// with Reencrypt without decrypting the data. Ciphertexts are bound to their field path, not to
+// the document key, so backups can be restored under another namespace.
+//
+// Documents with tagged fields are stored through their JSON form.
+package encrypt
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+
+	"github.com/gstones/moke-kit/orm/internal/jsonx"
+	"github.com/gstones/moke-kit/orm/nerrors"
+	"github.com/gstones/moke-kit/orm/nosql/diface"
+	"github.com/gstones/moke-kit/orm/nosql/key"
+	"github.com/gstones/moke-kit/orm/nosql/noptions"
+)
+
+// Collection is an ICollection that encrypts tagged fields on Set and decrypts them on Get.
+type Collection struct {
+	diface.ICollection
+	keyring *Keyring
+}
+
+// NewCollection wraps inner with field level encryption.
+func NewCollection(inner diface.ICollection, keyring *Keyring) *Collection {
+	return &Collection{
+		ICollection: inner,
+		keyring:     keyring,
+	}
+}
+
+// Set encrypts the tagged fields of the source before storing it.
+func (c *Collection) Set(ctx context.Context, key key.Key, opts ...noptions.Option) (noptions.Version, error) {
+	o, err := noptions.NewOptions(opts...)
+	if err != nil {
+		return noptions.NoVersion, err
+	}
+	fs := fieldsOf(reflect.TypeOf(o.Source))
+	if fs == nil {
+		return c.ICollection.Set(ctx, key, opts...)
+	}
+	data, err := json.Marshal(o.Source)
+	if err != nil {
+		return noptions.NoVersion, err
+	}
+	v, err := jsonx.Decode(data)
+	if err != nil {
+		return noptions.NoVersion, err
+	}
+	if m, ok := v.(map[string]any); ok {
+		if err := c.keyring.sealFields(m, fs, ""); err != nil {
+			return noptions.NoVersion, err
+		}
+	}
+	return c.ICollection.Set(ctx, key, append(opts[:len(opts):len(opts)], noptions.WithSource(v))...)
+}
+
+// Get loads the document and decrypts the tagged fields of the destination.
+func (c *Collection) Get(ctx context.Context, key key.Key, opts ...noptions.Option) (noptions.Version, error) {
+	o, err := noptions.NewOptions(opts...)
+	if err != nil {
+		return noptions.NoVersion, err
+	}
+	fs := fieldsOf(reflect.TypeOf(o.Destination))
+	if fs == nil {
+		return c.ICollection.Get(ctx, key, opts...)
+	}
+	var doc document
+	version, err := c.ICollection.Get(ctx, key, append(opts[:len(opts):len(opts)], noptions.WithDestination(&doc))...)
+	if err != nil {
+		return version, err
+	}
+	if m, ok := object(doc.value); ok {
+		if err := c.keyring.openFields(m, fs, ""); err != nil {
+			return noptions.NoVersion, err
+		}
+		doc.value = m
+	}
+	data, err := json.Marshal(doc.value)
+	if err != nil {
+		return noptions.NoVersion, err
+	}
+	if err := json.Unmarshal(data, o.Destination); err != nil {
+		return noptions.NoVersion, err
+	}
+	return version, nil
+}
+
+// Scan forwards to the wrapped collection, so exports keep their ciphertexts.
+func (c *Collection) Scan(
+	ctx context.Context,
+	prefix string,
+	fn func(k key.Key, version noptions.Version, data []byte) error,
+) error {
+	scanner, ok := c.ICollection.(diface.IScanner)
+	if !ok {
+		return nerrors.ErrScanNotSupported
+	}
+	return scanner.Scan(ctx, prefix, fn)
+}
+
+// Provider opens collections wrapped with field level encryption.
+type Provider struct {
+	diface.IDocumentProvider
+	keyring *Keyring
+}
+
+// NewProvider wraps every collection opened by inner with field level encryption.
+func NewProvider(inner diface.IDocumentProvider, keyring *Keyring) *Provider {
+	return &Provider{
+		IDocumentProvider: inner,
+		keyring:           keyring,
+	}
+}
+
+// OpenDbDriver opens the named collection of the wrapped provider and wraps it.
+func (p *Provider) OpenDbDriver(name string) (diface.ICollection, error) {
+	coll, err := p.IDocumentProvider.OpenDbDriver(name)
+	if err != nil {
+		return nil, err
+	}
+	return NewCollection(coll, p.keyring), nil
+}
@@ -0,0 +1,192 @@
+package encrypt
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.uber.org/zap"
+
+	"github.com/gstones/moke-kit/orm/nerrors"
+	"github.com/gstones/moke-kit/orm/nosql/diface"
+	"github.com/gstones/moke-kit/orm/nosql/key"
+	"github.com/gstones/moke-kit/orm/nosql/mock"
+	"github.com/gstones/moke-kit/orm/nosql/noptions"
+)
+
+type billing struct {
+	Card    string `json:"card" encrypt:"true"`
+	Country string `json:"country"`
+}
+
+type account struct {
+	Name    string            `json:"name"`
+	Email   string            `json:"email" encrypt:"true"`
+	Balance int64             `json:"balance" encrypt:"true"`
+	Tags    map[string]string `json:"tags,omitempty" encrypt:"true"`
+	Billing billing           `json:"billing"`
+}
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, keySize)
+}
+
+func openInner(t *testing.T) diface.ICollection {
+	t.Helper()
+	coll, err := mock.NewMockDriverProvider(zap.NewNop()).OpenDbDriver("secure")
+	require.NoError(t, err)
+	return coll
+}
+
+func TestCollectionRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	kr, err := NewKeyring("k1", map[string][]byte{"k1": testKey(1)})
+	require.NoError(t, err)
+	inner := openInner(t)
+	coll := NewCollection(inner, kr)
+	k := key.NewKey("/account/1")
+
+	in := account{
+		Name:    "alice",
+		Email:   "alice@example.com",
+		Balance: 1 << 60,
+		Tags:    map[string]string{"vip": "gold"},
+		Billing: billing{Card: "4111111111111111", Country: "NZ"},
+	}
+	v, err := coll.Set(ctx, k, noptions.WithSource(&in))
+	require.NoError(t, err)
+
+	var raw map[string]any
+	_, err = inner.Get(ctx, k, noptions.WithDestination(&raw))
+	require.NoError(t, err)
+	require.Equal(t, "alice", raw["name"])
+	require.True(t, strings.HasPrefix(raw["email"].(string), "$enc:v1:k1:"))
+	require.True(t, isSealed(raw["balance"].(string)))
+	require.True(t, isSealed(raw["tags"].(string)))
+	require.True(t, isSealed(raw["billing"].(map[string]any)["card"].(string)))
+	require.Equal(t, "NZ", raw["billing"].(map[string]any)["country"])
+
+	var out account
+	got, err := coll.Get(ctx, k, noptions.WithDestination(&out))
+	require.NoError(t, err)
+	require.Equal(t, v, got)
+	require.Equal(t, in, out)
+
+	// CAS updates keep working through the decorator
+	out.Email = "alice@example.org"
+	_, err = coll.Set(ctx, k, noptions.WithSource(&out), noptions.WithVersion(got))
+	require.NoError(t, err)
+
+	// untagged types pass through untouched
+	plain := key.NewKey("/plain/1")
+	_, err = coll.Set(ctx, plain, noptions.WithSource(map[string]string{"email": "bob@example.com"}))
+	require.NoError(t, err)
+	_, err = inner.Get(ctx, plain, noptions.WithDestination(&raw))
+	require.NoError(t, err)
+	require.Equal(t, "bob@example.com", raw["email"])
+}
+
+func TestCiphertextBoundToField(t *testing.T) {
+	kr, err := NewKeyring("k1", map[string][]byte{"k1": testKey(1)})
+	require.NoError(t, err)
+	sealed, err := kr.seal([]byte(`"secret"`), []byte("email"))
+	require.NoError(t, err)
+	_, err = kr.open(sealed, []byte("billing.card"))
+	require.ErrorIs(t, err, nerrors.ErrDecryptFailed)
+
+	other, err := NewKeyring("k2", map[string][]byte{"k2": testKey(2)})
+	require.NoError(t, err)
+	_, err = other.open(sealed, []byte("email"))
+	require.ErrorIs(t, err, nerrors.ErrUnknownKeyID)
+}
+
+func TestReencrypt(t *testing.T) {
+	ctx := context.Background()
+	old, err := NewKeyring("k1", map[string][]byte{"k1": testKey(1)})
+	require.NoError(t, err)
+	inner := openInner(t)
+	for i, k := range []string{"/account/1", "/account/2"} {
+		_, err := NewCollection(inner, old).Set(ctx, key.NewKey(k), noptions.WithSource(&account{
+			Name:  k,
+			Email: "user@example.com",
+			Billing: billing{
+				Card: strings.Repeat("4", i+1),
+			},
+		}))
+		require.NoError(t, err)
+	}
+
+	rotated, err := NewKeyring("k2", map[string][]byte{"k1": testKey(1), "k2": testKey(2)})
+	require.NoError(t, err)
+	res, err := Reencrypt(ctx, inner, rotated, "/account")
+	require.NoError(t, err)
+	require.Equal(t, ReencryptResult{Scanned: 2, Rewrapped: 2}, res)
+
+	// a second run has nothing left to do
+	res, err = Reencrypt(ctx, inner, rotated, "/account")
+	require.NoError(t, err)
+	require.Equal(t, ReencryptResult{Scanned: 2}, res)
+
+	// k1 can now be retired
+	retired, err := NewKeyring("k2", map[string][]byte{"k2": testKey(2)})
+	require.NoError(t, err)
+	var out account
+	_, err = NewCollection(inner, retired).Get(ctx, key.NewKey("/account/2"), noptions.WithDestination(&out))
+	require.NoError(t, err)
+	require.Equal(t, "user@example.com", out.Email)
+	require.Equal(t, "44", out.Billing.Card)
+}
+
+func TestDocumentFromBSON(t *testing.T) {
+	kr, err := NewKeyring("k1", map[string][]byte{"k1": testKey(1)})
+	require.NoError(t, err)
+	sealed, err := kr.seal([]byte(`"4111"`), []byte("billing.card"))
+	require.NoError(t, err)
+
+	// the shape the mongo driver stores: {_id, data, version}
+	raw, err := bson.Marshal(bson.M{
+		"_id":     "/account/1",
+		"version": int64(1),
+		"data": bson.M{
+			"name":    "alice",
+			"billing": bson.M{"card": sealed, "country": "NZ"},
+		},
+	})
+	require.NoError(t, err)
+	var doc document
+	require.NoError(t, bson.Raw(raw).Lookup("data").Unmarshal(&doc))
+
+	m, ok := object(doc.value)
+	require.True(t, ok)
+	require.NoError(t, kr.openFields(m, fieldsOf(reflect.TypeOf(account{})), ""))
+	billing, ok := object(m["billing"])
+	require.True(t, ok)
+	require.Equal(t, json.RawMessage(`"4111"`), billing["card"])
+}
+
+func TestParseKeys(t *testing.T) {
+	k1 := base64.StdEncoding.EncodeToString(testKey(0xfb))
+	k2 := base64.RawURLEncoding.EncodeToString(testKey(0xff))
+	keys, err := ParseKeys(" k1:" + k1 + ", k2:" + k2)
+	require.NoError(t, err)
+	require.Equal(t, testKey(0xfb), keys["k1"])
+	require.Equal(t, testKey(0xff), keys["k2"])
+
+	_, err = ParseKeys("k1")
+	require.ErrorIs(t, err, nerrors.ErrInvalidEncryptionKey)
+	_, err = NewKeyring("k1", map[string][]byte{"k1": []byte("short")})
+	require.ErrorIs(t, err, nerrors.ErrInvalidEncryptionKey)
+	_, err = NewKeyring("k3", keys)
+	require.ErrorIs(t, err, nerrors.ErrUnknownKeyID)
+
+	t.Setenv("ENCRYPTION_KEYS", "only:"+k1)
+	kr, err := LoadKeyring()
+	require.NoError(t, err)
+	require.Equal(t, "only", kr.CurrentID())
+}
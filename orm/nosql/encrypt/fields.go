@@ -0,0 +1,241 @@
+package encrypt
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/gstones/moke-kit/orm/internal/jsonx"
+)
+
+// TagName is the struct tag marking a field for encryption: `encrypt:"true"`.
+const TagName = "encrypt"
+
+// fields maps the json names of a struct level to their encryption plan:
+// a nil entry encrypts the whole value, a non nil entry descends into a nested struct.
+type fields map[string]*fields
+
+var fieldCache sync.Map // reflect.Type -> fields
+
+// fieldsOf returns the encryption plan of t, or nil if no field of t is tagged.
+func fieldsOf(t reflect.Type) fields {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil
+	}
+	if cached, ok := fieldCache.Load(t); ok {
+		return cached.(fields)
+	}
+	fs := buildFields(t, map[reflect.Type]bool{})
+	fieldCache.Store(t, fs)
+	return fs
+}
+
+func buildFields(t reflect.Type, visiting map[reflect.Type]bool) fields {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || visiting[t] {
+		return nil
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+
+	var out fields
+	add := func(name string, plan *fields) {
+		if out == nil {
+			out = fields{}
+		}
+		out[name] = plan
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, named, skip := jsonName(f)
+		if skip {
+			continue
+		}
+		if f.Tag.Get(TagName) == "true" {
+			add(name, nil)
+			continue
+		}
+		sub := buildFields(f.Type, visiting)
+		if len(sub) == 0 {
+			continue
+		}
+		if f.Anonymous && !named {
+			// embedded structs are flattened by encoding/json
+			for n, plan := range sub {
+				add(n, plan)
+			}
+			continue
+		}
+		add(name, &sub)
+	}
+	return out
+}
+
+// jsonName returns the encoding/json name of f, whether it was set by a tag, and whether
+// encoding/json skips the field.
+func jsonName(f reflect.StructField) (name string, named, skip bool) {
+	if !f.IsExported() && !f.Anonymous {
+		return "", false, true
+	}
+	tag := f.Tag.Get("json")
+	if tag == "-" {
+		return "", false, true
+	}
+	name, _, _ = strings.Cut(tag, ",")
+	if name != "" {
+		return name, true, false
+	}
+	return f.Name, false, false
+}
+
+// document receives stored data losslessly from both JSON (mock, sql) and BSON (mongo) drivers.
+type document struct {
+	value any
+}
+
+func (d *document) UnmarshalJSON(data []byte) error {
+	v, err := jsonx.Decode(data)
+	if err != nil {
+		return err
+	}
+	d.value = v
+	return nil
+}
+
+func (d *document) UnmarshalBSON(data []byte) error {
+	var m bson.M
+	if err := bson.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	d.value = m
+	return nil
+}
+
+// object returns m as a map whatever driver decoded it.
+func object(v any) (map[string]any, bool) {
+	switch m := v.(type) {
+	case map[string]any:
+		return m, true
+	case bson.M:
+		return m, true
+	case bson.D:
+		return m.Map(), true
+	}
+	return nil, false
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// sealFields replaces every planned value of m by its sealed JSON encoding.
+func (kr *Keyring) sealFields(m map[string]any, fs fields, path string) error {
+	for name, plan := range fs {
+		v, ok := m[name]
+		if !ok || v == nil {
+			continue
+		}
+		p := joinPath(path, name)
+		if plan == nil {
+			raw, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			sealed, err := kr.seal(raw, []byte(p))
+			if err != nil {
+				return err
+			}
+			m[name] = sealed
+			continue
+		}
+		if child, ok := object(v); ok {
+			if err := kr.sealFields(child, *plan, p); err != nil {
+				return err
+			}
+			m[name] = child
+		}
+	}
+	return nil
+}
+
+// openFields replaces every sealed planned value of m by its decrypted JSON. Plain values are
+// kept, so documents written before a field was tagged still load.
+func (kr *Keyring) openFields(m map[string]any, fs fields, path string) error {
+	for name, plan := range fs {
+		v, ok := m[name]
+		if !ok || v == nil {
+			continue
+		}
+		p := joinPath(path, name)
+		if plan == nil {
+			sealed, ok := v.(string)
+			if !ok || !isSealed(sealed) {
+				continue
+			}
+			raw, err := kr.open(sealed, []byte(p))
+			if err != nil {
+				return err
+			}
+			m[name] = json.RawMessage(raw)
+			continue
+		}
+		if child, ok := object(v); ok {
+			if err := kr.openFields(child, *plan, p); err != nil {
+				return err
+			}
+			m[name] = child
+		}
+	}
+	return nil
+}
+
+// rewrapAll re-wraps every sealed string found anywhere in v with the current key.
+func (kr *Keyring) rewrapAll(v any) (any, bool, error) {
+	switch t := v.(type) {
+	case string:
+		if !isSealed(t) {
+			return t, false, nil
+		}
+		return kr.rewrap(t)
+	case []any:
+		return kr.rewrapSlice(t)
+	case bson.A:
+		return kr.rewrapSlice(t)
+	}
+	m, ok := object(v)
+	if !ok {
+		return v, false, nil
+	}
+	changed := false
+	for k, child := range m {
+		nv, c, err := kr.rewrapAll(child)
+		if err != nil {
+			return nil, false, err
+		}
+		m[k], changed = nv, changed || c
+	}
+	return m, changed, nil
+}
+
+func (kr *Keyring) rewrapSlice(s []any) (any, bool, error) {
+	changed := false
+	for i, child := range s {
+		nv, c, err := kr.rewrapAll(child)
+		if err != nil {
+			return nil, false, err
+		}
+		s[i], changed = nv, changed || c
+	}
+	return s, changed, nil
+}
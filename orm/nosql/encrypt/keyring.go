@@ -0,0 +1,237 @@
+package encrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+
+	"github.com/gstones/moke-kit/orm/nerrors"
+	"github.com/gstones/moke-kit/utility"
+)
+
+const (
+	// keySize is the size of key encryption keys and per value data keys (AES-256).
+	keySize = 32
+	// sealedPrefix marks an encrypted value: $enc:v1:<key id>:<wrapped data key>:<ciphertext>
+	sealedPrefix = "$enc:v1:"
+)
+
+// Settings locates the key encryption keys. EncryptionKeys is a comma separated list of
+// id:base64key entries; EncryptionKeyID names the one new values are sealed with and may be
+// omitted when there is a single key. When VaultAddr is set, the vault tagged fields are read
+// from VaultPath.
+type Settings struct {
+	EncryptionKeys  string `envconfig:"ENCRYPTION_KEYS" vault:"encryption_keys" default:""`
+	EncryptionKeyID string `envconfig:"ENCRYPTION_KEY_ID" vault:"encryption_key_id" default:""`
+
+	VaultAddr  string `envconfig:"ENCRYPTION_VAULT_ADDR" default:""`
+	VaultToken string `envconfig:"ENCRYPTION_VAULT_TOKEN" default:""`
+	VaultPath  string `envconfig:"ENCRYPTION_VAULT_PATH" default:""`
+}
+
+// LoadKeyring loads Settings with utility.Load and builds the Keyring from them.
+func LoadKeyring() (*Keyring, error) {
+	var s Settings
+	if err := utility.Load(&s); err != nil {
+		return nil, err
+	}
+	keys, err := ParseKeys(s.EncryptionKeys)
+	if err != nil {
+		return nil, err
+	}
+	current := s.EncryptionKeyID
+	if current == "" && len(keys) == 1 {
+		for id := range keys {
+			current = id
+		}
+	}
+	return NewKeyring(current, keys)
+}
+
+// ParseKeys parses "id1:base64key,id2:base64key" into raw keys. Keys may use standard or url
+// base64, padded or not.
+func ParseKeys(spec string) (map[string][]byte, error) {
+	keys := make(map[string][]byte)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, nerrors.ErrInvalidEncryptionKey
+		}
+		raw, err := decodeKey(encoded)
+		if err != nil {
+			return nil, nerrors.ErrInvalidEncryptionKey
+		}
+		keys[id] = raw
+	}
+	return keys, nil
+}
+
+func decodeKey(s string) ([]byte, error) {
+	s = strings.TrimRight(s, "=")
+	if strings.ContainsAny(s, "-_") {
+		return base64.RawURLEncoding.DecodeString(s)
+	}
+	return base64.RawStdEncoding.DecodeString(s)
+}
+
+// Keyring holds the key encryption keys by id. Values are sealed with the current key and
+// opened with whichever key sealed them, so old keys stay until Reencrypt has run.
+type Keyring struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// NewKeyring creates a Keyring sealing with the key named current. Keys must be 32 bytes and
+// ids must be non empty without ":".
+func NewKeyring(current string, keys map[string][]byte) (*Keyring, error) {
+	kr := &Keyring{
+		current: current,
+		keys:    make(map[string]cipher.AEAD, len(keys)),
+	}
+	for id, raw := range keys {
+		if id == "" || strings.Contains(id, ":") || len(raw) != keySize {
+			return nil, nerrors.ErrInvalidEncryptionKey
+		}
+		aead, err := newAEAD(raw)
+		if err != nil {
+			return nil, err
+		}
+		kr.keys[id] = aead
+	}
+	if _, ok := kr.keys[current]; !ok {
+		return nil, nerrors.ErrUnknownKeyID
+	}
+	return kr, nil
+}
+
+// CurrentID returns the id of the key new values are sealed with.
+func (kr *Keyring) CurrentID() string {
+	return kr.current
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plaintext with a fresh data key and wraps that key with the current key.
+// aad binds the ciphertext to its field path.
+func (kr *Keyring) seal(plaintext, aad []byte) (string, error) {
+	dataKey := make([]byte, keySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := sealWith(aead, plaintext, aad)
+	if err != nil {
+		return "", err
+	}
+	wrapped, err := sealWith(kr.keys[kr.current], dataKey, []byte(kr.current))
+	if err != nil {
+		return "", err
+	}
+	return format(kr.current, wrapped, ciphertext), nil
+}
+
+// open decrypts a value produced by seal.
+func (kr *Keyring) open(sealed string, aad []byte) ([]byte, error) {
+	id, wrapped, ciphertext, err := parse(sealed)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := kr.unwrap(id, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return openWith(aead, ciphertext, aad)
+}
+
+// rewrap re-wraps the data key of a sealed value with the current key. The ciphertext is kept,
+// so no field path is needed. It reports false if the value already uses the current key.
+func (kr *Keyring) rewrap(sealed string) (string, bool, error) {
+	id, wrapped, ciphertext, err := parse(sealed)
+	if err != nil {
+		return "", false, err
+	}
+	if id == kr.current {
+		return sealed, false, nil
+	}
+	dataKey, err := kr.unwrap(id, wrapped)
+	if err != nil {
+		return "", false, err
+	}
+	rewrapped, err := sealWith(kr.keys[kr.current], dataKey, []byte(kr.current))
+	if err != nil {
+		return "", false, err
+	}
+	return format(kr.current, rewrapped, ciphertext), true, nil
+}
+
+func (kr *Keyring) unwrap(id string, wrapped []byte) ([]byte, error) {
+	kek, ok := kr.keys[id]
+	if !ok {
+		return nil, nerrors.ErrUnknownKeyID
+	}
+	return openWith(kek, wrapped, []byte(id))
+}
+
+func isSealed(s string) bool {
+	return strings.HasPrefix(s, sealedPrefix)
+}
+
+func format(id string, wrapped, ciphertext []byte) string {
+	return sealedPrefix + id + ":" +
+		base64.RawURLEncoding.EncodeToString(wrapped) + ":" +
+		base64.RawURLEncoding.EncodeToString(ciphertext)
+}
+
+func parse(sealed string) (id string, wrapped, ciphertext []byte, err error) {
+	parts := strings.Split(strings.TrimPrefix(sealed, sealedPrefix), ":")
+	if !isSealed(sealed) || len(parts) != 3 {
+		return "", nil, nil, nerrors.ErrDecryptFailed
+	}
+	if wrapped, err = base64.RawURLEncoding.DecodeString(parts[1]); err != nil {
+		return "", nil, nil, nerrors.ErrDecryptFailed
+	}
+	if ciphertext, err = base64.RawURLEncoding.DecodeString(parts[2]); err != nil {
+		return "", nil, nil, nerrors.ErrDecryptFailed
+	}
+	return parts[0], wrapped, ciphertext, nil
+}
+
+// sealWith returns nonce|ciphertext.
+func sealWith(aead cipher.AEAD, plaintext, aad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func openWith(aead cipher.AEAD, sealed, aad []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, nerrors.ErrDecryptFailed
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, aad)
+	if err != nil {
+		return nil, nerrors.ErrDecryptFailed
+	}
+	return plaintext, nil
+}
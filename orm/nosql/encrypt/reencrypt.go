@@ -0,0 +1,82 @@
+package encrypt
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/gstones/moke-kit/orm/nerrors"
+	"github.com/gstones/moke-kit/orm/nosql/diface"
+	"github.com/gstones/moke-kit/orm/nosql/key"
+	"github.com/gstones/moke-kit/orm/nosql/noptions"
+)
+
+// ReencryptResult counts what Reencrypt did.
+type ReencryptResult struct {
+	// Scanned is the number of documents holding sealed values.
+	Scanned int
+	// Rewrapped is the number of documents moved to the current key.
+	Rewrapped int
+	// Conflicts is the number of documents changed concurrently, run again to pick them up.
+	Conflicts int
+}
+
+type pending struct {
+	key     key.Key
+	version noptions.Version
+}
+
+// Reencrypt moves every sealed value under prefix to the current key of keyring. Only the
+// wrapped data keys change, so coll must be the raw collection (not a Collection) implementing
+// diface.IScanner, and keyring must still hold the retired keys. Documents are written back with
+// their scanned version, so concurrent updates win and are counted as conflicts.
+func Reencrypt(ctx context.Context, coll diface.ICollection, keyring *Keyring, prefix string) (ReencryptResult, error) {
+	var res ReencryptResult
+	scanner, ok := coll.(diface.IScanner)
+	if !ok {
+		return res, nerrors.ErrScanNotSupported
+	}
+
+	var todo []pending
+	err := scanner.Scan(ctx, prefix, func(k key.Key, version noptions.Version, data []byte) error {
+		if bytes.Contains(data, []byte(sealedPrefix)) {
+			todo = append(todo, pending{k, version})
+		}
+		return nil
+	})
+	if err != nil {
+		return res, err
+	}
+
+	for _, p := range todo {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		res.Scanned++
+		var doc document
+		if _, err := coll.Get(ctx, p.key, noptions.WithVersion(p.version), noptions.WithDestination(&doc)); err != nil {
+			if errors.Is(err, nerrors.ErrNotFound) {
+				res.Conflicts++
+				continue
+			}
+			return res, err
+		}
+		value, changed, err := keyring.rewrapAll(doc.value)
+		if err != nil {
+			return res, errors.Wrapf(err, "reencrypt %s", p.key.String())
+		}
+		if !changed {
+			continue
+		}
+		if _, err := coll.Set(ctx, p.key, noptions.WithVersion(p.version), noptions.WithSource(value)); err != nil {
+			if errors.Is(err, nerrors.ErrVersionNotMatch) {
+				res.Conflicts++
+				continue
+			}
+			return res, err
+		}
+		res.Rewrapped++
+	}
+	return res, nil
+}
@@ -0,0 +1,309 @@
+// Package eventstore keeps aggregates as append-only event logs on top of diface.ICollection.
+//
+// Every Append writes one commit document holding its events, keyed by the version of its first
+// event and created with a create-only ICollection.Set, so two writers expecting the same version
+// cannot both succeed: the loser gets nerrors.ErrVersionNotMatch. For an aggregate key such as
+// /wallet/42, commits are stored at /wallet/events/<version in hex>$42 and snapshots at
+// /wallet/snapshots/42, so every aggregate of a kind shares the same collections.
+package eventstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/gstones/moke-kit/mq/miface"
+	"github.com/gstones/moke-kit/orm/nerrors"
+	"github.com/gstones/moke-kit/orm/nosql/diface"
+	"github.com/gstones/moke-kit/orm/nosql/key"
+	"github.com/gstones/moke-kit/orm/nosql/noptions"
+	"github.com/gstones/moke-kit/orm/retry"
+)
+
+const (
+	eventsSection    = "events"
+	snapshotsSection = "snapshots"
+)
+
+// Event is one change of an aggregate.
+type Event struct {
+	AggregateID string          `json:"aggregateId" bson:"aggregateId"`
+	Version     int64           `json:"version" bson:"version"`
+	Type        string          `json:"type" bson:"type"`
+	Time        time.Time       `json:"time" bson:"time"`
+	Data        json.RawMessage `json:"data" bson:"data"`
+}
+
+// NewEvent creates an event of the given type with payload encoded as JSON.
+// AggregateID, Version and Time are filled in by Append.
+func NewEvent(typ string, payload any) (Event, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return Event{}, err
+	}
+	return Event{Type: typ, Data: data}, nil
+}
+
+// Decode unmarshals the payload of e into dst.
+func (e Event) Decode(dst any) error {
+	return json.Unmarshal(e.Data, dst)
+}
+
+// Aggregate is state rebuilt by applying its events in order.
+type Aggregate interface {
+	Apply(e Event) error
+}
+
+type commit struct {
+	Events []Event `json:"events" bson:"events"`
+}
+
+type snapshot[T any] struct {
+	Version int64 `json:"version" bson:"version"`
+	State   T     `json:"state" bson:"state"`
+}
+
+// Option configures a Store.
+type Option func(o *options)
+
+type options struct {
+	snapshotEvery int64
+	mq            miface.MessageQueue
+	topic         string
+	logger        *zap.Logger
+}
+
+// WithSnapshotEvery makes Update store a snapshot whenever the version crosses a multiple of n.
+func WithSnapshotEvery(n int64) Option {
+	return func(o *options) {
+		o.snapshotEvery = n
+	}
+}
+
+// WithPublisher publishes every appended event as JSON to topic, e.g. "nats://economy.wallet".
+func WithPublisher(mq miface.MessageQueue, topic string) Option {
+	return func(o *options) {
+		o.mq = mq
+		o.topic = topic
+	}
+}
+
+// WithLogger sets the logger reporting failed snapshots.
+func WithLogger(l *zap.Logger) Option {
+	return func(o *options) {
+		o.logger = l
+	}
+}
+
+// Store appends and replays the events of aggregates of type T.
+// PT is the pointer type of T, which must implement Aggregate and be JSON/BSON serializable for snapshots.
+type Store[T any, PT interface {
+	*T
+	Aggregate
+}] struct {
+	coll diface.ICollection
+	options
+}
+
+// NewStore creates a Store on coll.
+func NewStore[T any, PT interface {
+	*T
+	Aggregate
+}](coll diface.ICollection, opts ...Option) *Store[T, PT] {
+	s := &Store[T, PT]{
+		coll: coll,
+		options: options{
+			logger: zap.NewNop(),
+		},
+	}
+	for _, opt := range opts {
+		opt(&s.options)
+	}
+	return s
+}
+
+func commitKey(k key.Key, version int64) (key.Key, error) {
+	return key.NewKeyFromString(fmt.Sprintf("%s/%s/%x%s%s",
+		k.Prefix(), eventsSection, version, key.PrefixSeparator, k.Base()))
+}
+
+func snapshotKey(k key.Key) (key.Key, error) {
+	return key.NewKeyFromString(k.Prefix() + key.KeySeparator + snapshotsSection + key.KeySeparator + k.Base())
+}
+
+// Append stores events after version expected and returns the new version. expected must be a
+// version returned by Load, Append or Update (0 for a new aggregate); if another writer appended
+// first it fails with ErrVersionNotMatch. If publishing fails the events are still stored and the
+// error wraps ErrEventPublishFailed.
+func (s *Store[T, PT]) Append(ctx context.Context, k key.Key, expected int64, events ...Event) (int64, error) {
+	stamped, err := s.append(ctx, k, expected, events)
+	if err != nil {
+		return expected, err
+	}
+	return expected + int64(len(stamped)), s.publish(stamped)
+}
+
+func (s *Store[T, PT]) append(ctx context.Context, k key.Key, expected int64, events []Event) ([]Event, error) {
+	if len(events) == 0 {
+		return nil, nil
+	}
+	ck, err := commitKey(k, expected+1)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	stamped := make([]Event, len(events))
+	for i, e := range events {
+		e.AggregateID = k.String()
+		e.Version = expected + 1 + int64(i)
+		if e.Time.IsZero() {
+			e.Time = now
+		}
+		stamped[i] = e
+	}
+	if _, err := s.coll.Set(ctx, ck, noptions.WithSource(&commit{Events: stamped})); err != nil {
+		return nil, err
+	}
+	return stamped, nil
+}
+
+func (s *Store[T, PT]) publish(events []Event) error {
+	if s.mq == nil {
+		return nil
+	}
+	for _, e := range events {
+		if err := s.mq.Publish(s.topic, miface.WithJSON(e)); err != nil {
+			return errors.Wrapf(nerrors.ErrEventPublishFailed, "%s version %d: %v", e.AggregateID, e.Version, err)
+		}
+	}
+	return nil
+}
+
+// Events returns the events of k after version from, which must be 0 or a version returned by
+// the Store.
+func (s *Store[T, PT]) Events(ctx context.Context, k key.Key, from int64) ([]Event, error) {
+	var out []Event
+	_, err := s.replay(ctx, k, from, func(e Event) error {
+		out = append(out, e)
+		return nil
+	})
+	return out, err
+}
+
+func (s *Store[T, PT]) replay(ctx context.Context, k key.Key, from int64, fn func(e Event) error) (int64, error) {
+	version := from
+	for {
+		ck, err := commitKey(k, version+1)
+		if err != nil {
+			return version, err
+		}
+		var c commit
+		if _, err := s.coll.Get(ctx, ck, noptions.WithDestination(&c)); err != nil {
+			if errors.Is(err, nerrors.ErrNotFound) {
+				return version, nil
+			}
+			return version, err
+		}
+		if len(c.Events) == 0 {
+			return version, nerrors.ErrCorruptEventLog
+		}
+		for _, e := range c.Events {
+			if e.Version != version+1 {
+				return version, nerrors.ErrCorruptEventLog
+			}
+			if err := fn(e); err != nil {
+				return version, err
+			}
+			version = e.Version
+		}
+	}
+}
+
+// Load rebuilds the aggregate of k from its latest snapshot and the events after it, and returns
+// it with its version. An aggregate without events is the zero T at version 0.
+func (s *Store[T, PT]) Load(ctx context.Context, k key.Key) (PT, int64, error) {
+	sk, err := snapshotKey(k)
+	if err != nil {
+		return nil, 0, err
+	}
+	snap := snapshot[T]{}
+	if _, err := s.coll.Get(ctx, sk, noptions.WithDestination(&snap)); err != nil {
+		if !errors.Is(err, nerrors.ErrNotFound) {
+			return nil, 0, err
+		}
+		snap = snapshot[T]{}
+	}
+	agg := PT(&snap.State)
+	version, err := s.replay(ctx, k, snap.Version, agg.Apply)
+	if err != nil {
+		return nil, 0, err
+	}
+	return agg, version, nil
+}
+
+// Snapshot stores agg as the state of k at version, so later loads only replay newer events.
+func (s *Store[T, PT]) Snapshot(ctx context.Context, k key.Key, agg PT, version int64) error {
+	sk, err := snapshotKey(k)
+	if err != nil {
+		return err
+	}
+	_, err = s.coll.Set(ctx, sk,
+		noptions.WithSource(&snapshot[T]{Version: version, State: *agg}),
+		noptions.WithAnyVersion(),
+	)
+	return err
+}
+
+// Update loads the aggregate of k, asks decide for new events and appends them, retrying from a
+// fresh load when another writer appended first, as retry.Default() allows; when it gives up, the
+// returned *retry.Error holds the attempt count and the last conflict. decide must not change the
+// aggregate, since the stored events are applied to it to take snapshots: with WithSnapshotEvery,
+// one is taken when the new version crosses a multiple of n.
+func (s *Store[T, PT]) Update(ctx context.Context, k key.Key, decide func(agg PT) ([]Event, error)) (int64, error) {
+	p := retry.Default()
+	start := time.Now()
+	for attempt := 0; ; attempt++ {
+		agg, version, err := s.Load(ctx, k)
+		if err != nil {
+			return version, err
+		}
+		events, err := decide(agg)
+		if err != nil {
+			return version, err
+		}
+		stamped, err := s.append(ctx, k, version, events)
+		if err == nil {
+			next := version + int64(len(stamped))
+			s.maybeSnapshot(ctx, k, agg, stamped, version, next)
+			return next, s.publish(stamped)
+		} else if !p.IsRetryable(err) {
+			return version, err
+		}
+		delay, ok := p.Next(attempt, start)
+		if !ok {
+			return 0, retry.NewError(attempt+1, err)
+		}
+		if err := retry.Wait(ctx, delay); err != nil {
+			return version, err
+		}
+	}
+}
+
+func (s *Store[T, PT]) maybeSnapshot(ctx context.Context, k key.Key, agg PT, events []Event, from, to int64) {
+	if s.snapshotEvery <= 0 || from/s.snapshotEvery == to/s.snapshotEvery {
+		return
+	}
+	for _, e := range events {
+		if err := agg.Apply(e); err != nil {
+			s.logger.Warn("skip snapshot, apply failed", zap.String("aggregate", k.String()), zap.Error(err))
+			return
+		}
+	}
+	if err := s.Snapshot(ctx, k, agg, to); err != nil {
+		s.logger.Warn("snapshot failed", zap.String("aggregate", k.String()), zap.Error(err))
+	}
+}
@@ -0,0 +1,181 @@
+package eventstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/gstones/moke-kit/mq/miface"
+	"github.com/gstones/moke-kit/orm/nerrors"
+	"github.com/gstones/moke-kit/orm/nosql/diface"
+	"github.com/gstones/moke-kit/orm/nosql/key"
+	"github.com/gstones/moke-kit/orm/nosql/mock"
+	"github.com/gstones/moke-kit/orm/nosql/noptions"
+)
+
+type deposited struct {
+	Amount int64 `json:"amount"`
+}
+
+type wallet struct {
+	Balance int64 `json:"balance"`
+	Count   int   `json:"count"`
+}
+
+func (w *wallet) Apply(e Event) error {
+	var d deposited
+	if err := e.Decode(&d); err != nil {
+		return err
+	}
+	w.Balance += d.Amount
+	w.Count++
+	return nil
+}
+
+type recordingMQ struct {
+	miface.MessageQueue
+	mu     sync.Mutex
+	topics []string
+	events []Event
+	fail   bool
+}
+
+func (r *recordingMQ) Subscribe(context.Context, string, miface.SubResponseHandler, ...miface.SubOption) (miface.Subscription, error) {
+	return nil, nil
+}
+
+func (r *recordingMQ) Publish(topic string, opts ...miface.PubOption) error {
+	if r.fail {
+		return errors.New("broker down")
+	}
+	o, err := miface.NewPubOptions(opts...)
+	if err != nil {
+		return err
+	}
+	var e Event
+	if err := json.Unmarshal(o.Data, &e); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.topics = append(r.topics, topic)
+	r.events = append(r.events, e)
+	return nil
+}
+
+func openStore(t *testing.T, opts ...Option) (diface.ICollection, *Store[wallet, *wallet]) {
+	t.Helper()
+	coll, err := mock.NewMockDriverProvider(zap.NewNop()).OpenDbDriver("events")
+	require.NoError(t, err)
+	return coll, NewStore[wallet](coll, opts...)
+}
+
+func deposit(t *testing.T, amounts ...int64) []Event {
+	t.Helper()
+	events := make([]Event, len(amounts))
+	for i, a := range amounts {
+		e, err := NewEvent("deposited", deposited{Amount: a})
+		require.NoError(t, err)
+		events[i] = e
+	}
+	return events
+}
+
+func TestAppendAndLoad(t *testing.T) {
+	ctx := context.Background()
+	mq := &recordingMQ{}
+	coll, store := openStore(t, WithPublisher(mq, "local://wallet"))
+	k := key.NewKey("/wallet/42")
+
+	agg, v, err := store.Load(ctx, k)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), v)
+	require.Equal(t, wallet{}, *agg)
+
+	v, err = store.Append(ctx, k, 0, deposit(t, 10, 20)...)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), v)
+	v, err = store.Append(ctx, k, v, deposit(t, 5)...)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), v)
+
+	// a writer that loaded version 2 lost the race
+	_, err = store.Append(ctx, k, 2, deposit(t, 1)...)
+	require.ErrorIs(t, err, nerrors.ErrVersionNotMatch)
+
+	agg, v, err = store.Load(ctx, k)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), v)
+	require.Equal(t, wallet{Balance: 35, Count: 3}, *agg)
+
+	events, err := store.Events(ctx, k, 2)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, int64(3), events[0].Version)
+	require.Equal(t, "/wallet/42", events[0].AggregateID)
+
+	require.Equal(t, []string{"local://wallet", "local://wallet", "local://wallet"}, mq.topics)
+	require.Equal(t, int64(1), mq.events[0].Version)
+
+	// commits share one collection per aggregate kind
+	_, err = coll.Get(ctx, key.NewKey("/wallet/events/3$42"))
+	require.NoError(t, err)
+
+	mq.fail = true
+	v, err = store.Append(ctx, k, 3, deposit(t, 1)...)
+	require.ErrorIs(t, err, nerrors.ErrEventPublishFailed)
+	require.Equal(t, int64(4), v)
+}
+
+func TestUpdateSnapshotsAndRetries(t *testing.T) {
+	ctx := context.Background()
+	coll, store := openStore(t, WithSnapshotEvery(4))
+	k := key.NewKey("/wallet/7")
+
+	const workers = 6
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			// like the DocumentBase concurrency test, keep going until the deposit lands
+			for {
+				_, err := store.Update(ctx, k, func(w *wallet) ([]Event, error) {
+					return deposit(t, 1), nil
+				})
+				if !errors.Is(err, nerrors.ErrTooManyRetries) {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	agg, v, err := store.Load(ctx, k)
+	require.NoError(t, err)
+	require.Equal(t, int64(workers), v)
+	require.Equal(t, wallet{Balance: workers, Count: workers}, *agg)
+
+	var snap snapshot[wallet]
+	_, err = coll.Get(ctx, key.NewKey("/wallet/snapshots/7"), noptions.WithDestination(&snap))
+	require.NoError(t, err)
+	require.Equal(t, int64(4), snap.Version)
+	require.Equal(t, wallet{Balance: 4, Count: 4}, snap.State)
+
+	// decide errors abort without appending
+	_, err = store.Update(ctx, k, func(w *wallet) ([]Event, error) {
+		return nil, errors.New("insufficient funds")
+	})
+	require.EqualError(t, err, "insufficient funds")
+}
@@ -0,0 +1,175 @@
+package gorm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/gstones/moke-kit/orm/nerrors"
+	"github.com/gstones/moke-kit/orm/nosql/diface"
+	"github.com/gstones/moke-kit/orm/nosql/key"
+	"github.com/gstones/moke-kit/orm/nosql/noptions"
+)
+
+type payload struct {
+	Message string `json:"message"`
+	Count   int    `json:"count"`
+}
+
+func openCollection(t *testing.T) diface.ICollection {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{
+		Logger: logger.Discard,
+	})
+	require.NoError(t, err)
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	coll, err := NewProvider(db, zap.NewNop()).OpenDbDriver("documents")
+	require.NoError(t, err)
+	require.Equal(t, "documents", coll.GetName())
+	return coll
+}
+
+func TestCollectionCreateAndCAS(t *testing.T) {
+	ctx := context.Background()
+	coll := openCollection(t)
+	k := key.NewKey("/test/document/1")
+
+	v1, err := coll.Set(ctx, k, noptions.WithSource(&payload{Message: "a", Count: 1}))
+	require.NoError(t, err)
+	require.Equal(t, noptions.Version(1), v1)
+
+	_, err = coll.Set(ctx, k, noptions.WithSource(&payload{Message: "again"}))
+	require.ErrorIs(t, err, nerrors.ErrVersionNotMatch)
+
+	v2, err := coll.Set(ctx, k, noptions.WithSource(&payload{Message: "b", Count: 2}), noptions.WithVersion(v1))
+	require.NoError(t, err)
+	require.Equal(t, noptions.Version(2), v2)
+
+	_, err = coll.Set(ctx, k, noptions.WithSource(&payload{Message: "stale"}), noptions.WithVersion(v1))
+	require.ErrorIs(t, err, nerrors.ErrVersionNotMatch)
+
+	var got payload
+	v, err := coll.Get(ctx, k, noptions.WithDestination(&got))
+	require.NoError(t, err)
+	require.Equal(t, v2, v)
+	require.Equal(t, payload{Message: "b", Count: 2}, got)
+
+	_, err = coll.Get(ctx, k, noptions.WithDestination(&got), noptions.WithVersion(v1))
+	require.ErrorIs(t, err, nerrors.ErrNotFound)
+
+	require.NoError(t, coll.Delete(ctx, k))
+	require.ErrorIs(t, coll.Delete(ctx, k), nerrors.ErrNotFound)
+	_, err = coll.Get(ctx, k, noptions.WithDestination(&got))
+	require.ErrorIs(t, err, nerrors.ErrNotFound)
+}
+
+func TestCollectionAnyVersion(t *testing.T) {
+	ctx := context.Background()
+	coll := openCollection(t)
+	k := key.NewKey("/test/document/any")
+
+	v, err := coll.Set(ctx, k, noptions.WithSource(&payload{Message: "new"}), noptions.WithAnyVersion())
+	require.NoError(t, err)
+	require.Equal(t, noptions.Version(1), v)
+
+	v, err = coll.Set(ctx, k, noptions.WithSource(&payload{Message: "over"}), noptions.WithAnyVersion())
+	require.NoError(t, err)
+	require.Equal(t, noptions.Version(2), v)
+
+	var got payload
+	_, err = coll.Get(ctx, k, noptions.WithDestination(&got))
+	require.NoError(t, err)
+	require.Equal(t, "over", got.Message)
+}
+
+func TestCollectionTTL(t *testing.T) {
+	ctx := context.Background()
+	coll := openCollection(t)
+	k := key.NewKey("/test/document/ttl")
+
+	_, err := coll.Set(ctx, k, noptions.WithSource(&payload{Message: "soon gone"}), noptions.WithTTL(50*time.Millisecond))
+	require.NoError(t, err)
+	var got payload
+	_, err = coll.Get(ctx, k, noptions.WithDestination(&got))
+	require.NoError(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+	_, err = coll.Get(ctx, k, noptions.WithDestination(&got))
+	require.ErrorIs(t, err, nerrors.ErrNotFound)
+
+	// an expired document can be created again
+	v, err := coll.Set(ctx, k, noptions.WithSource(&payload{Message: "back"}))
+	require.NoError(t, err)
+	require.Equal(t, noptions.Version(1), v)
+}
+
+func TestCollectionIncr(t *testing.T) {
+	ctx := context.Background()
+	coll := openCollection(t)
+	k := key.NewKey("/test/counter/1")
+
+	n, err := coll.Incr(ctx, k, "count", 5)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), n)
+	n, err = coll.Incr(ctx, k, "count", 3)
+	require.NoError(t, err)
+	require.Equal(t, int64(8), n)
+	n, err = coll.Incr(ctx, k, "other", 1)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), n)
+
+	var got map[string]int64
+	_, err = coll.Get(ctx, k, noptions.WithDestination(&got))
+	require.NoError(t, err)
+	require.Equal(t, map[string]int64{"count": 8, "other": 1}, got)
+}
+
+func TestCollectionIncrExpired(t *testing.T) {
+	ctx := context.Background()
+	coll := openCollection(t)
+	k := key.NewKey("/test/counter/ttl")
+
+	_, err := coll.Set(ctx, k, noptions.WithSource(map[string]int64{"count": 7}), noptions.WithTTL(50*time.Millisecond))
+	require.NoError(t, err)
+	time.Sleep(100 * time.Millisecond)
+
+	// an expired counter starts over, without the expiration time of the old one
+	n, err := coll.Incr(ctx, k, "count", 1)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), n)
+	time.Sleep(100 * time.Millisecond)
+	var got map[string]int64
+	_, err = coll.Get(ctx, k, noptions.WithDestination(&got))
+	require.NoError(t, err)
+	require.Equal(t, map[string]int64{"count": 1}, got)
+}
+
+func TestCollectionScan(t *testing.T) {
+	ctx := context.Background()
+	coll := openCollection(t)
+	for _, k := range []string{"/scan/a", "/scan/a/1", "/scan/a/2", "/scan/ab/1", "/scan/b/1"} {
+		_, err := coll.Set(ctx, key.NewKey(k), noptions.WithSource(&payload{Message: k}))
+		require.NoError(t, err)
+	}
+	_, err := coll.Set(ctx, key.NewKey("/scan/a/3"), noptions.WithSource(&payload{}), noptions.WithTTL(time.Millisecond))
+	require.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+
+	var keys []string
+	err = coll.(diface.IScanner).Scan(ctx, "/scan/a", func(k key.Key, v noptions.Version, data []byte) error {
+		keys = append(keys, k.String())
+		require.Contains(t, string(data), k.String())
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"/scan/a", "/scan/a/1", "/scan/a/2"}, keys)
+}
@@ -0,0 +1,18 @@
+package gorm
+
+import (
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/gstones/moke-kit/orm/nosql/diface"
+	"github.com/gstones/moke-kit/orm/nosql/gorm/internal"
+)
+
+// NewProvider returns a new IDocumentProvider that stores documents as
+// key/data(JSON)/version/expires rows, one table per opened name.
+func NewProvider(
+	db *gorm.DB,
+	logger *zap.Logger,
+) diface.IDocumentProvider {
+	return internal.NewDriverProvider(db, logger)
+}
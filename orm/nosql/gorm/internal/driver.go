@@ -0,0 +1,282 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/gstones/moke-kit/orm/nerrors"
+	"github.com/gstones/moke-kit/orm/nosql/key"
+	"github.com/gstones/moke-kit/orm/nosql/noptions"
+)
+
+const (
+	notExpired    = "expires_at IS NULL OR expires_at > ?"
+	scanBatchSize = 500
+)
+
+// DocumentRow is the table layout of a SQL backed collection.
+type DocumentRow struct {
+	ID        string `gorm:"primaryKey;size:255"`
+	Prefix    string `gorm:"index;size:255"`
+	Data      []byte
+	Version   int64      `gorm:"not null"`
+	ExpiresAt *time.Time `gorm:"index"`
+}
+
+// CollectionDriver is an ICollection stored in a single SQL table.
+type CollectionDriver struct {
+	db   *gorm.DB
+	name string
+}
+
+// GetName Name returns the name of this ICollection.
+func (cd *CollectionDriver) GetName() string {
+	return cd.name
+}
+
+func (cd *CollectionDriver) table(db *gorm.DB) *gorm.DB {
+	return db.Table(cd.name)
+}
+
+func expiresAt(ttl time.Duration) *time.Time {
+	if ttl <= 0 {
+		return nil
+	}
+	t := time.Now().Add(ttl)
+	return &t
+}
+
+// Set with a key and options.
+// - No version and not AnyVersion: create only (fails if document exists).
+// - WithVersion: CAS update via a conditional UPDATE (fails if version mismatches).
+// - WithAnyVersion: overwrite/create regardless of current version.
+func (cd *CollectionDriver) Set(ctx context.Context, key key.Key, opts ...noptions.Option) (noptions.Version, error) {
+	o, err := noptions.NewOptions(opts...)
+	if err != nil {
+		return noptions.NoVersion, err
+	}
+	if o.Source == nil {
+		return noptions.NoVersion, nerrors.ErrSourceIsNil
+	}
+	data, err := json.Marshal(o.Source)
+	if err != nil {
+		return noptions.NoVersion, err
+	}
+
+	id := key.String()
+	expires := expiresAt(o.TTL)
+	updates := map[string]any{
+		"data":    data,
+		"version": gorm.Expr("version + ?", 1),
+	}
+	if expires != nil {
+		updates["expires_at"] = expires
+	}
+
+	if !o.AnyVersion && o.Version != noptions.NoVersion {
+		res := cd.table(cd.db.WithContext(ctx)).
+			Where("id = ? AND version = ?", id, o.Version).
+			Where(notExpired, time.Now()).
+			Updates(updates)
+		if res.Error != nil {
+			return noptions.NoVersion, res.Error
+		}
+		if res.RowsAffected == 0 {
+			return noptions.NoVersion, nerrors.ErrVersionNotMatch
+		}
+		return o.Version + 1, nil
+	}
+
+	version := noptions.NoVersion
+	err = cd.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// an expired document no longer exists as far as callers are concerned
+		if err := cd.table(tx).
+			Where("id = ? AND expires_at <= ?", id, time.Now()).
+			Delete(&DocumentRow{}).Error; err != nil {
+			return err
+		}
+		if o.AnyVersion {
+			res := cd.table(tx).Where("id = ?", id).Updates(updates)
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected > 0 {
+				return cd.table(tx).Select("version").Where("id = ?", id).Scan(&version).Error
+			}
+		}
+		res := cd.table(tx).Clauses(clause.OnConflict{DoNothing: true}).Create(&DocumentRow{
+			ID:        id,
+			Prefix:    key.Prefix(),
+			Data:      data,
+			Version:   1,
+			ExpiresAt: expires,
+		})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return nerrors.ErrVersionNotMatch
+		}
+		version = 1
+		return nil
+	})
+	if err != nil {
+		return noptions.NoVersion, err
+	}
+	return version, nil
+}
+
+// Get loads a document into the destination option and returns its version.
+// WithTTL refreshes the expiration time of the document.
+func (cd *CollectionDriver) Get(ctx context.Context, key key.Key, opts ...noptions.Option) (noptions.Version, error) {
+	o, err := noptions.NewOptions(opts...)
+	if err != nil {
+		return noptions.NoVersion, err
+	}
+
+	db := cd.db.WithContext(ctx)
+	q := cd.table(db).Where("id = ?", key.String()).Where(notExpired, time.Now())
+	if o.Version != noptions.NoVersion {
+		q = q.Where("version = ?", o.Version)
+	}
+	var row DocumentRow
+	if err := q.Take(&row).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return noptions.NoVersion, nerrors.ErrNotFound
+		}
+		return noptions.NoVersion, err
+	}
+	if o.TTL > 0 {
+		if err := cd.table(db).
+			Where("id = ?", row.ID).
+			Update("expires_at", expiresAt(o.TTL)).Error; err != nil {
+			return noptions.NoVersion, err
+		}
+	}
+	if o.Destination != nil && row.Data != nil {
+		if err := json.Unmarshal(row.Data, o.Destination); err != nil {
+			return noptions.NoVersion, err
+		}
+	}
+	return row.Version, nil
+}
+
+// Delete deletes a document by a key. It returns ErrNotFound if the key does not exist.
+func (cd *CollectionDriver) Delete(ctx context.Context, key key.Key) error {
+	res := cd.table(cd.db.WithContext(ctx)).
+		Where("id = ?", key.String()).
+		Where(notExpired, time.Now()).
+		Delete(&DocumentRow{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nerrors.ErrNotFound
+	}
+	return nil
+}
+
+// Incr increments a numeric field of the stored JSON object and returns the new value.
+// (tips: can not be used for document,because the version)
+func (cd *CollectionDriver) Incr(ctx context.Context, key key.Key, field string, amount int32) (int64, error) {
+	var value int64
+	err := cd.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// an expired counter starts over, like Set recreates an expired document
+		if err := cd.table(tx).
+			Where("id = ? AND expires_at <= ?", key.String(), time.Now()).
+			Delete(&DocumentRow{}).Error; err != nil {
+			return err
+		}
+		var row DocumentRow
+		err := cd.table(tx).
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ?", key.String()).
+			Take(&row).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			value = int64(amount)
+			data, _ := json.Marshal(map[string]int64{field: value})
+			res := cd.table(tx).Clauses(clause.OnConflict{DoNothing: true}).Create(&DocumentRow{
+				ID:      key.String(),
+				Prefix:  key.Prefix(),
+				Data:    data,
+				Version: 1,
+			})
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected == 0 {
+				return nerrors.ErrVersionNotMatch
+			}
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		values := map[string]any{}
+		dec := json.NewDecoder(bytes.NewReader(row.Data))
+		dec.UseNumber()
+		if err := dec.Decode(&values); err != nil {
+			return err
+		}
+		if n, ok := values[field].(json.Number); ok {
+			if value, err = n.Int64(); err != nil {
+				return err
+			}
+		}
+		value += int64(amount)
+		values[field] = value
+		data, err := json.Marshal(values)
+		if err != nil {
+			return err
+		}
+		return cd.table(tx).Where("id = ?", row.ID).Update("data", data).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return value, nil
+}
+
+// Scan calls fn for every unexpired document under prefix in key order, loading scanBatchSize rows at a time.
+func (cd *CollectionDriver) Scan(
+	ctx context.Context,
+	prefix string,
+	fn func(k key.Key, version noptions.Version, data []byte) error,
+) error {
+	q := cd.table(cd.db.WithContext(ctx)).Where(notExpired, time.Now())
+	if prefix != "" {
+		// "0" sorts right after the "/" separator, so the range holds exactly the keys below prefix
+		q = q.Where("id = ? OR (id >= ? AND id < ?)", prefix, prefix+key.KeySeparator, prefix+"0")
+	}
+	var rows []DocumentRow
+	var fnErr error
+	res := q.FindInBatches(&rows, scanBatchSize, func(tx *gorm.DB, batch int) error {
+		for _, row := range rows {
+			if fnErr = fn(key.NewKeyFromStringUnchecked(row.ID), row.Version, row.Data); fnErr != nil {
+				return fnErr
+			}
+		}
+		return nil
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	return res.Error
+}
+
+// NewCollectionDriver creates a new CollectionDriver on table name, creating or migrating the table.
+func NewCollectionDriver(db *gorm.DB, name string) (*CollectionDriver, error) {
+	if err := db.Table(name).AutoMigrate(&DocumentRow{}); err != nil {
+		return nil, err
+	}
+	return &CollectionDriver{
+		db:   db,
+		name: name,
+	}, nil
+}
@@ -0,0 +1,35 @@
+package internal
+
+import (
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/gstones/moke-kit/orm/nosql/diface"
+)
+
+type DriverProvider struct {
+	db     *gorm.DB
+	logger *zap.Logger
+}
+
+// Shutdown is a no-op: the *gorm.DB belongs to the GormModule, which closes it.
+func (dp *DriverProvider) Shutdown() error {
+	return nil
+}
+
+// OpenDbDriver opens the table called name, creating it on first use.
+func (dp *DriverProvider) OpenDbDriver(name string) (diface.ICollection, error) {
+	if s, err := NewCollectionDriver(dp.db, name); err != nil {
+		return nil, err
+	} else {
+		return s, nil
+	}
+}
+
+// NewDriverProvider returns a new DriverProvider.
+func NewDriverProvider(
+	db *gorm.DB,
+	logger *zap.Logger,
+) *DriverProvider {
+	return &DriverProvider{db, logger}
+}
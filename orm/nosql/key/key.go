@@ -2,6 +2,7 @@ package key
 
 import (
 	"errors"
+	"hash/fnv"
 	"regexp"
 	"strings"
 )
@@ -131,6 +132,66 @@ func (k *Key) Prefix() string {
 	return k.String()[:strings.LastIndex(k.String(), KeySeparator)]
 }
 
+// Parent returns the key with the final section removed. The namespace is never re-applied, so the parent of
+// /ns/player/1 is /ns/player. It fails with ErrNotEnoughParts if the parent would not be a valid key.
+func (k *Key) Parent() (Key, error) {
+	if len(k.Parts()) <= 2 {
+		return Key{}, ErrNotEnoughParts
+	}
+	return Key{k.Prefix()}, nil
+}
+
+// Child returns a new key with part appended as a single section.
+func (k *Key) Child(part string) (Key, error) {
+	if k.IsEmpty() {
+		return Key{}, ErrNotEnoughParts
+	}
+	if err := ValidatePart(part); err != nil {
+		return Key{}, err
+	}
+	return Key{k.value + KeySeparator + part}, nil
+}
+
+// Join returns a new key with every part appended in order. A part may itself contain KeySeparator-separated
+// sections, e.g. k.Join("inventory/slot1").
+func (k *Key) Join(parts ...string) (Key, error) {
+	if k.IsEmpty() {
+		return Key{}, ErrNotEnoughParts
+	}
+	var builder strings.Builder
+	builder.WriteString(k.value)
+	for _, part := range parts {
+		for _, section := range strings.Split(strings.Trim(part, KeySeparator), KeySeparator) {
+			if err := ValidatePart(section); err != nil {
+				return Key{}, err
+			}
+			builder.WriteString(KeySeparator)
+			builder.WriteString(section)
+		}
+	}
+	return Key{builder.String()}, nil
+}
+
+// IsDescendantOf reports whether k is located below parent.
+func (k *Key) IsDescendantOf(parent Key) bool {
+	return parent.HasValue() && strings.HasPrefix(k.value, parent.value+KeySeparator)
+}
+
+// Hash returns a stable 64-bit FNV-1a hash of the key, suitable for sharding.
+func (k *Key) Hash() uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(k.value))
+	return h.Sum64()
+}
+
+// Shard maps the key onto one of n shards. It returns 0 when n is not positive.
+func (k *Key) Shard(n int) int {
+	if n <= 0 {
+		return 0
+	}
+	return int(k.Hash() % uint64(n))
+}
+
 // Prefixes returns a slice of PrefixSeparator-separated elements of the Base.
 func (k *Key) Prefixes() []string {
 	p := strings.Split(k.Base(), PrefixSeparator)
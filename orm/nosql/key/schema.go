@@ -0,0 +1,278 @@
+package key
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	validatePartPattern = `^([0-9a-f]+\$)*[a-zA-Z0-9_.-]+$`
+	// A single section of a key: alphanumeric with underscores, periods or dashes,
+	// optionally preceded by hexadecimal prefixes separated by a $.
+)
+
+var (
+	validatePartExp = regexp.MustCompile(validatePartPattern)
+
+	ErrInvalidSchema   = errors.New("ErrInvalidSchema")
+	ErrInvalidSegment  = errors.New("ErrInvalidSegment")
+	ErrMissingSegment  = errors.New("ErrMissingSegment")
+	ErrSchemaMismatch  = errors.New("ErrSchemaMismatch")
+	ErrUnknownSegment  = errors.New("ErrUnknownSegment")
+	ErrSegmentNotFound = errors.New("ErrSegmentNotFound")
+)
+
+// SegmentType constrains the values a named schema segment accepts.
+type SegmentType string
+
+const (
+	// SegmentString accepts any valid key part. It is the default type.
+	SegmentString SegmentType = "string"
+	// SegmentInt accepts a base 10 signed integer.
+	SegmentInt SegmentType = "int"
+	// SegmentHex accepts a lowercase hexadecimal string.
+	SegmentHex SegmentType = "hex"
+)
+
+// SegmentError reports which segment of a key or schema failed validation.
+type SegmentError struct {
+	// Segment is the schema name of the segment, or the literal if it is fixed.
+	Segment string
+	// Index is the zero based position of the segment within the schema.
+	Index int
+	// Value is the offending value.
+	Value string
+	Err   error
+}
+
+func (e *SegmentError) Error() string {
+	return fmt.Sprintf("key segment %q (index %d) value %q: %v", e.Segment, e.Index, e.Value, e.Err)
+}
+
+func (e *SegmentError) Unwrap() error {
+	return e.Err
+}
+
+// ValidatePart reports whether s is usable as a single section of a key.
+func ValidatePart(s string) error {
+	if !validatePartExp.MatchString(s) {
+		return ErrInvalidKeyFormat
+	}
+	return nil
+}
+
+// IsValid reports whether value is a well-formed fully qualified key string.
+func IsValid(value string) bool {
+	return validatePathExp.MatchString(value)
+}
+
+type segment struct {
+	literal string
+	name    string
+	typ     SegmentType
+}
+
+func (s segment) isParam() bool {
+	return s.name != ""
+}
+
+func (s segment) label() string {
+	if s.isParam() {
+		return s.name
+	}
+	return s.literal
+}
+
+func (s segment) validate(value string) error {
+	if err := ValidatePart(value); err != nil {
+		return err
+	}
+	switch s.typ {
+	case SegmentInt:
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return ErrInvalidSegment
+		}
+	case SegmentHex:
+		for _, c := range value {
+			if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+				return ErrInvalidSegment
+			}
+		}
+	}
+	return nil
+}
+
+// KeySchema describes the layout of a family of keys, e.g. /player/{id}/inventory.
+// Literal segments must match exactly; {name} segments are placeholders that accept
+// any valid key part, and {name:int} or {name:hex} additionally constrain the value.
+// The current Namespace is applied when building and stripped when parsing, so schemas
+// never mention it.
+type KeySchema struct {
+	pattern  string
+	segments []segment
+	names    map[string]int
+}
+
+// NewKeySchema parses pattern into a KeySchema.
+func NewKeySchema(pattern string) (*KeySchema, error) {
+	trimmed := strings.TrimPrefix(pattern, KeySeparator)
+	if trimmed == "" {
+		return nil, ErrInvalidSchema
+	}
+	ks := &KeySchema{
+		pattern: pattern,
+		names:   make(map[string]int),
+	}
+	for i, raw := range strings.Split(trimmed, KeySeparator) {
+		seg, err := parseSegment(raw)
+		if err != nil {
+			return nil, &SegmentError{Segment: raw, Index: i, Value: raw, Err: err}
+		}
+		if seg.isParam() {
+			if _, dup := ks.names[seg.name]; dup {
+				return nil, &SegmentError{Segment: seg.name, Index: i, Value: raw, Err: ErrInvalidSchema}
+			}
+			ks.names[seg.name] = i
+		}
+		ks.segments = append(ks.segments, seg)
+	}
+	return ks, nil
+}
+
+// MustSchema is like NewKeySchema but panics if the pattern is invalid.
+func MustSchema(pattern string) *KeySchema {
+	ks, err := NewKeySchema(pattern)
+	if err != nil {
+		panic(err)
+	}
+	return ks
+}
+
+func parseSegment(raw string) (segment, error) {
+	if !strings.HasPrefix(raw, "{") || !strings.HasSuffix(raw, "}") {
+		if err := ValidatePart(raw); err != nil {
+			return segment{}, err
+		}
+		return segment{literal: raw}, nil
+	}
+	name, typ, _ := strings.Cut(raw[1:len(raw)-1], ":")
+	if err := ValidatePart(name); err != nil {
+		return segment{}, ErrInvalidSchema
+	}
+	seg := segment{name: name, typ: SegmentType(typ)}
+	switch seg.typ {
+	case "":
+		seg.typ = SegmentString
+	case SegmentString, SegmentInt, SegmentHex:
+	default:
+		return segment{}, ErrInvalidSchema
+	}
+	return seg, nil
+}
+
+// String returns the pattern the schema was created from.
+func (ks *KeySchema) String() string {
+	return ks.pattern
+}
+
+// Names returns the placeholder names in schema order.
+func (ks *KeySchema) Names() []string {
+	names := make([]string, 0, len(ks.names))
+	for _, seg := range ks.segments {
+		if seg.isParam() {
+			names = append(names, seg.name)
+		}
+	}
+	return names
+}
+
+// Build creates a key from named segment values. Every placeholder must be provided
+// and no unknown names are accepted.
+func (ks *KeySchema) Build(values map[string]string) (Key, error) {
+	for name := range values {
+		if _, ok := ks.names[name]; !ok {
+			return Key{}, &SegmentError{Segment: name, Index: -1, Value: values[name], Err: ErrUnknownSegment}
+		}
+	}
+	parts := make([]string, len(ks.segments))
+	for i, seg := range ks.segments {
+		if !seg.isParam() {
+			parts[i] = seg.literal
+			continue
+		}
+		value, ok := values[seg.name]
+		if !ok {
+			return Key{}, &SegmentError{Segment: seg.name, Index: i, Err: ErrMissingSegment}
+		}
+		if err := seg.validate(value); err != nil {
+			return Key{}, &SegmentError{Segment: seg.name, Index: i, Value: value, Err: err}
+		}
+		parts[i] = value
+	}
+	return NewKeyFromParts(parts...)
+}
+
+// BuildArgs creates a key from placeholder values given in schema order.
+func (ks *KeySchema) BuildArgs(values ...any) (Key, error) {
+	names := ks.Names()
+	if len(values) != len(names) {
+		return Key{}, ErrSchemaMismatch
+	}
+	m := make(map[string]string, len(values))
+	for i, v := range values {
+		m[names[i]] = fmt.Sprint(v)
+	}
+	return ks.Build(m)
+}
+
+// Parse splits k into its named segments, failing with a SegmentError that
+// identifies the first segment that does not match the schema.
+func (ks *KeySchema) Parse(k Key) (Segments, error) {
+	parts := k.Parts()
+	if ns := Namespace(); ns != "" && len(parts) == len(ks.segments)+1 && parts[0] == ns {
+		parts = parts[1:]
+	}
+	if len(parts) != len(ks.segments) {
+		return nil, ErrSchemaMismatch
+	}
+	out := make(Segments, len(ks.names))
+	for i, seg := range ks.segments {
+		if !seg.isParam() {
+			if parts[i] != seg.literal {
+				return nil, &SegmentError{Segment: seg.literal, Index: i, Value: parts[i], Err: ErrSchemaMismatch}
+			}
+			continue
+		}
+		if err := seg.validate(parts[i]); err != nil {
+			return nil, &SegmentError{Segment: seg.label(), Index: i, Value: parts[i], Err: err}
+		}
+		out[seg.name] = parts[i]
+	}
+	return out, nil
+}
+
+// Match reports whether k fits the schema.
+func (ks *KeySchema) Match(k Key) bool {
+	_, err := ks.Parse(k)
+	return err == nil
+}
+
+// Segments holds the named values of a parsed key.
+type Segments map[string]string
+
+// Get returns the named value, or an empty string if it is not present.
+func (s Segments) Get(name string) string {
+	return s[name]
+}
+
+// Int returns the named value parsed as a base 10 integer.
+func (s Segments) Int(name string) (int64, error) {
+	v, ok := s[name]
+	if !ok {
+		return 0, ErrSegmentNotFound
+	}
+	return strconv.ParseInt(v, 10, 64)
+}
@@ -0,0 +1,90 @@
+package key
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeySchemaBuildAndParse(t *testing.T) {
+	ks := MustSchema("/player/{id:int}/inventory/{slot}")
+	require.Equal(t, []string{"id", "slot"}, ks.Names())
+
+	k, err := ks.Build(map[string]string{"id": "42", "slot": "weapon"})
+	require.NoError(t, err)
+	require.Equal(t, "/player/42/inventory/weapon", k.String())
+
+	args, err := ks.BuildArgs(42, "weapon")
+	require.NoError(t, err)
+	require.Equal(t, k, args)
+
+	seg, err := ks.Parse(k)
+	require.NoError(t, err)
+	require.Equal(t, "weapon", seg.Get("slot"))
+	id, err := seg.Int("id")
+	require.NoError(t, err)
+	require.Equal(t, int64(42), id)
+	require.True(t, ks.Match(k))
+}
+
+func TestKeySchemaSegmentErrors(t *testing.T) {
+	ks := MustSchema("/player/{id:int}/inventory")
+
+	_, err := ks.Build(map[string]string{"id": "abc"})
+	var segErr *SegmentError
+	require.True(t, errors.As(err, &segErr))
+	require.Equal(t, "id", segErr.Segment)
+	require.Equal(t, 1, segErr.Index)
+	require.ErrorIs(t, err, ErrInvalidSegment)
+
+	_, err = ks.Build(map[string]string{})
+	require.ErrorIs(t, err, ErrMissingSegment)
+
+	_, err = ks.Build(map[string]string{"id": "1", "zone": "x"})
+	require.ErrorIs(t, err, ErrUnknownSegment)
+
+	_, err = ks.Parse(NewKey("/player/1/equipment"))
+	require.True(t, errors.As(err, &segErr))
+	require.Equal(t, "inventory", segErr.Segment)
+	require.ErrorIs(t, err, ErrSchemaMismatch)
+
+	_, err = NewKeySchema("/player/{id:float}")
+	require.ErrorIs(t, err, ErrInvalidSchema)
+	_, err = NewKeySchema("/player/{id}/{id}")
+	require.ErrorIs(t, err, ErrInvalidSchema)
+}
+
+func TestKeyNavigation(t *testing.T) {
+	k := NewKey("/player/42/inventory")
+
+	parent, err := k.Parent()
+	require.NoError(t, err)
+	require.Equal(t, "/player/42", parent.String())
+	_, err = parent.Parent()
+	require.ErrorIs(t, err, ErrNotEnoughParts)
+
+	child, err := k.Child("weapon")
+	require.NoError(t, err)
+	require.Equal(t, "/player/42/inventory/weapon", child.String())
+	require.True(t, child.IsDescendantOf(k))
+	require.False(t, k.IsDescendantOf(child))
+	_, err = k.Child("a/b")
+	require.ErrorIs(t, err, ErrInvalidKeyFormat)
+
+	joined, err := parent.Join("inventory/weapon")
+	require.NoError(t, err)
+	require.Equal(t, child, joined)
+}
+
+func TestKeyShard(t *testing.T) {
+	a := NewKey("/player/1")
+	b := NewKey("/player/1")
+	require.Equal(t, a.Hash(), b.Hash())
+	for i := 0; i < 100; i++ {
+		s := a.Shard(8)
+		require.GreaterOrEqual(t, s, 0)
+		require.Less(t, s, 8)
+	}
+	require.Equal(t, 0, a.Shard(0))
+}
@@ -3,6 +3,8 @@ package internal
 import (
 	"context"
 	"encoding/json"
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/stretchr/testify/mock"
@@ -150,3 +152,35 @@ func (m *MockCollection) Incr(ctx context.Context, k key.Key, field string, amou
 	entry.data = data
 	return values[field], nil
 }
+
+// Scan calls fn for every document under prefix in key order.
+func (m *MockCollection) Scan(
+	ctx context.Context,
+	prefix string,
+	fn func(k key.Key, version noptions.Version, data []byte) error,
+) error {
+	type entry struct {
+		id      string
+		version noptions.Version
+		data    []byte
+	}
+	m.mu.Lock()
+	entries := make([]entry, 0, len(m.docs))
+	for id, doc := range m.docs {
+		if prefix == "" || id == prefix || strings.HasPrefix(id, prefix+key.KeySeparator) {
+			entries = append(entries, entry{id, doc.version, append([]byte(nil), doc.data...)})
+		}
+	}
+	m.mu.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].id < entries[j].id })
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(key.NewKeyFromStringUnchecked(e.id), e.version, e.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
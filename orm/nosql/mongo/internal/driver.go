@@ -2,7 +2,10 @@ package internal
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"sort"
+	"strings"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -157,6 +160,88 @@ func (dd *DatabaseDriver) Incr(ctx context.Context, key key.Key, field string, a
 	return value, nil
 }
 
+type scanDocument struct {
+	ID      string           `bson:"_id"`
+	Version noptions.Version `bson:"version"`
+	Data    bson.RawValue    `bson:"data"`
+}
+
+// Scan calls fn for every document under prefix. Documents live in the collection named after
+// their key prefix, so the scan visits the document at prefix itself, then every collection named
+// prefix or below it. Data is passed as relaxed extended JSON, which is plain JSON for numbers,
+// strings, arrays and objects, e.g. dates become {"$date":"..."}.
+func (dd *DatabaseDriver) Scan(
+	ctx context.Context,
+	prefix string,
+	fn func(k key.Key, version noptions.Version, data []byte) error,
+) error {
+	if i := strings.LastIndex(prefix, key.KeySeparator); i > 0 {
+		coll := dd.database.Collection(prefix[:i])
+		var doc scanDocument
+		if err := coll.FindOne(ctx, bson.M{"_id": prefix}).Decode(&doc); err == nil {
+			if err := dd.scanDocument(doc, fn); err != nil {
+				return err
+			}
+		} else if !errors.Is(err, mongo.ErrNoDocuments) {
+			return err
+		}
+	}
+
+	names, err := dd.database.ListCollectionNames(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !strings.HasPrefix(name, key.KeySeparator) {
+			continue
+		}
+		if prefix != "" && name != prefix && !strings.HasPrefix(name, prefix+key.KeySeparator) {
+			continue
+		}
+		cur, err := dd.database.Collection(name).Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+		if err != nil {
+			return err
+		}
+		for cur.Next(ctx) {
+			var doc scanDocument
+			if err := cur.Decode(&doc); err != nil {
+				_ = cur.Close(ctx)
+				return err
+			}
+			if err := dd.scanDocument(doc, fn); err != nil {
+				_ = cur.Close(ctx)
+				return err
+			}
+		}
+		err = cur.Err()
+		_ = cur.Close(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (dd *DatabaseDriver) scanDocument(
+	doc scanDocument,
+	fn func(k key.Key, version noptions.Version, data []byte) error,
+) error {
+	ext, err := bson.MarshalExtJSON(struct {
+		Data bson.RawValue `bson:"data"`
+	}{doc.Data}, false, false)
+	if err != nil {
+		return err
+	}
+	var out struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(ext, &out); err != nil {
+		return err
+	}
+	return fn(key.NewKeyFromStringUnchecked(doc.ID), doc.Version, out.Data)
+}
+
 // NewCollectionDriver creates a new DatabaseDriver.
 func NewCollectionDriver(database *mongo.Database) (*DatabaseDriver, error) {
 	return &DatabaseDriver{
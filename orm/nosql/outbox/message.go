@@ -0,0 +1,50 @@
+// Package outbox implements the transactional outbox on top of diface.IOutbox: messages are staged
+// in the same commit as document writes (see DocumentBase.SaveWithOutbox) and a Relay publishes them
+// to the message queue afterwards, retrying until the broker accepts them. Delivery is at least
+// once, so consumers should tolerate duplicates.
+package outbox
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/gstones/moke-kit/orm/nosql/diface"
+)
+
+// NewMessage stages data for topic, e.g. "nats://player.levelup".
+func NewMessage(topic string, data []byte) diface.OutboxMessage {
+	return diface.OutboxMessage{
+		Topic: topic,
+		Data:  data,
+	}
+}
+
+// NewJSONMessage stages v encoded as JSON for topic.
+func NewJSONMessage(topic string, v any) (diface.OutboxMessage, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return diface.OutboxMessage{}, err
+	}
+	return NewMessage(topic, data), nil
+}
+
+// prepare fills in the ID and timestamps of messages about to be staged.
+func prepare(msgs []diface.OutboxMessage) []diface.OutboxMessage {
+	now := time.Now().UTC()
+	out := make([]diface.OutboxMessage, len(msgs))
+	for i, m := range msgs {
+		if m.ID == "" {
+			m.ID = uuid.NewString()
+		}
+		if m.CreatedAt.IsZero() {
+			m.CreatedAt = now
+		}
+		if m.NextAttempt.IsZero() {
+			m.NextAttempt = m.CreatedAt
+		}
+		out[i] = m
+	}
+	return out
+}
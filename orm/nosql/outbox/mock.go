@@ -0,0 +1,115 @@
+package outbox
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gstones/moke-kit/orm/nerrors"
+	"github.com/gstones/moke-kit/orm/nosql/diface"
+)
+
+// MockStore is an in-memory IOutbox for tests and the mock document store. Commit serializes
+// writes and stages the messages only when write succeeded.
+type MockStore struct {
+	mu      sync.Mutex
+	pending map[string]*diface.OutboxMessage
+	sent    []diface.OutboxMessage
+}
+
+// NewMockStore creates an empty MockStore.
+func NewMockStore() *MockStore {
+	return &MockStore{
+		pending: make(map[string]*diface.OutboxMessage),
+	}
+}
+
+// Commit runs write and stages msgs if it succeeded.
+func (s *MockStore) Commit(ctx context.Context, write func(ctx context.Context) error, msgs ...diface.OutboxMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := write(ctx); err != nil {
+		return err
+	}
+	for _, m := range prepare(msgs) {
+		m := m
+		s.pending[m.ID] = &m
+	}
+	return nil
+}
+
+// Pending returns up to limit due messages, oldest first.
+func (s *MockStore) Pending(_ context.Context, limit int) ([]diface.OutboxMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pendingLocked(limit), nil
+}
+
+// Claim returns up to limit due messages, oldest first, and delays them by lease.
+func (s *MockStore) Claim(_ context.Context, limit int, lease time.Duration) ([]diface.OutboxMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := s.pendingLocked(limit)
+	until := time.Now().Add(lease)
+	for _, m := range out {
+		s.pending[m.ID].NextAttempt = until
+	}
+	return out, nil
+}
+
+func (s *MockStore) pendingLocked(limit int) []diface.OutboxMessage {
+	now := time.Now()
+	out := make([]diface.OutboxMessage, 0, len(s.pending))
+	for _, m := range s.pending {
+		if !m.NextAttempt.After(now) {
+			out = append(out, *m)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].CreatedAt.Equal(out[j].CreatedAt) {
+			return out[i].ID < out[j].ID
+		}
+		return out[i].CreatedAt.Before(out[j].CreatedAt)
+	})
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+// MarkSent moves a message to the sent list.
+func (s *MockStore) MarkSent(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.pending[id]
+	if !ok {
+		return nerrors.ErrNotFound
+	}
+	delete(s.pending, id)
+	s.sent = append(s.sent, *m)
+	return nil
+}
+
+// MarkFailed records a failed attempt.
+func (s *MockStore) MarkFailed(_ context.Context, id string, cause error, next time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.pending[id]
+	if !ok {
+		return nerrors.ErrNotFound
+	}
+	m.Attempts++
+	m.NextAttempt = next
+	if cause != nil {
+		m.LastError = cause.Error()
+	}
+	return nil
+}
+
+// Sent returns the messages marked sent, in order.
+func (s *MockStore) Sent() []diface.OutboxMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]diface.OutboxMessage(nil), s.sent...)
+}
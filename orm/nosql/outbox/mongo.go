@@ -0,0 +1,155 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/gstones/moke-kit/orm/nerrors"
+	"github.com/gstones/moke-kit/orm/nosql/diface"
+)
+
+// DefaultCollection is the collection holding staged messages.
+const DefaultCollection = "outbox"
+
+// MongoStore is an IOutbox staging messages in a MongoDB collection inside a multi-document
+// transaction, so it needs a replica set or sharded cluster. Document writes made through the
+// mongo ICollection with the ctx passed to write join the transaction.
+type MongoStore struct {
+	client *mongo.Client
+	coll   *mongo.Collection
+}
+
+// NewMongoStore creates a MongoStore on database.collection.
+func NewMongoStore(client *mongo.Client, database, collection string) *MongoStore {
+	return &MongoStore{
+		client: client,
+		coll:   client.Database(database).Collection(collection),
+	}
+}
+
+// EnsureIndexes creates the index used by Pending and Claim.
+func (s *MongoStore) EnsureIndexes(ctx context.Context) error {
+	_, err := s.coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "sent", Value: 1}, {Key: "nextAttempt", Value: 1}, {Key: "createdAt", Value: 1}},
+	})
+	return err
+}
+
+// Commit runs write and inserts msgs in one transaction.
+func (s *MongoStore) Commit(ctx context.Context, write func(ctx context.Context) error, msgs ...diface.OutboxMessage) error {
+	session, err := s.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (any, error) {
+		if err := write(sc); err != nil {
+			return nil, err
+		}
+		if len(msgs) == 0 {
+			return nil, nil
+		}
+		docs := make([]any, 0, len(msgs))
+		for _, m := range prepare(msgs) {
+			docs = append(docs, mongoMessage{OutboxMessage: m})
+		}
+		_, err := s.coll.InsertMany(sc, docs)
+		return nil, err
+	})
+	return err
+}
+
+type mongoMessage struct {
+	diface.OutboxMessage `bson:",inline"`
+	Sent                 bool       `bson:"sent"`
+	SentAt               *time.Time `bson:"sentAt,omitempty"`
+}
+
+// Pending returns up to limit due messages, oldest first.
+func (s *MongoStore) Pending(ctx context.Context, limit int) ([]diface.OutboxMessage, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	cur, err := s.coll.Find(ctx, bson.M{
+		"sent":        false,
+		"nextAttempt": bson.M{"$lte": time.Now().UTC()},
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var docs []mongoMessage
+	if err := cur.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	out := make([]diface.OutboxMessage, len(docs))
+	for i, d := range docs {
+		out[i] = d.OutboxMessage
+	}
+	return out, nil
+}
+
+// Claim takes up to limit due messages, oldest first, one atomic update each, and delays them by
+// lease.
+func (s *MongoStore) Claim(ctx context.Context, limit int, lease time.Duration) ([]diface.OutboxMessage, error) {
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "createdAt", Value: 1}}).
+		SetReturnDocument(options.After)
+	var out []diface.OutboxMessage
+	for limit <= 0 || len(out) < limit {
+		now := time.Now().UTC()
+		var doc mongoMessage
+		err := s.coll.FindOneAndUpdate(ctx,
+			bson.M{"sent": false, "nextAttempt": bson.M{"$lte": now}},
+			bson.M{"$set": bson.M{"nextAttempt": now.Add(lease)}},
+			opts,
+		).Decode(&doc)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			break
+		} else if err != nil {
+			return out, err
+		}
+		out = append(out, doc.OutboxMessage)
+	}
+	return out, nil
+}
+
+// MarkSent flags a message as sent. Sent messages are kept for auditing; add a TTL index on
+// sentAt to expire them.
+func (s *MongoStore) MarkSent(ctx context.Context, id string) error {
+	res, err := s.coll.UpdateByID(ctx, id, bson.M{
+		"$set": bson.M{"sent": true, "sentAt": time.Now().UTC()},
+	})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return nerrors.ErrNotFound
+	}
+	return nil
+}
+
+// MarkFailed records a failed attempt.
+func (s *MongoStore) MarkFailed(ctx context.Context, id string, cause error, next time.Time) error {
+	set := bson.M{"nextAttempt": next.UTC()}
+	if cause != nil {
+		set["lastError"] = cause.Error()
+	}
+	res, err := s.coll.UpdateByID(ctx, id, bson.M{
+		"$set": set,
+		"$inc": bson.M{"attempts": 1},
+	})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return nerrors.ErrNotFound
+	}
+	return nil
+}
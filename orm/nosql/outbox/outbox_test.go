@@ -0,0 +1,180 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/gstones/moke-kit/mq/miface"
+	"github.com/gstones/moke-kit/orm/nerrors"
+	"github.com/gstones/moke-kit/orm/nosql"
+	"github.com/gstones/moke-kit/orm/nosql/key"
+	"github.com/gstones/moke-kit/orm/nosql/mock"
+)
+
+type flakyMQ struct {
+	miface.MessageQueue
+	mu        sync.Mutex
+	failures  int
+	published []string
+	ids       []string
+}
+
+func (f *flakyMQ) Subscribe(context.Context, string, miface.SubResponseHandler, ...miface.SubOption) (miface.Subscription, error) {
+	return nil, nil
+}
+
+func (f *flakyMQ) Publish(topic string, opts ...miface.PubOption) error {
+	o, err := miface.NewPubOptions(opts...)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failures > 0 {
+		f.failures--
+		return errors.New("broker unavailable")
+	}
+	f.published = append(f.published, topic+" "+string(o.Data))
+	f.ids = append(f.ids, o.MessageId)
+	return nil
+}
+
+func (f *flakyMQ) Published() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.published...)
+}
+
+type profile struct {
+	Level int `json:"level"`
+}
+
+type profileDoc struct {
+	nosql.DocumentBase
+	Data *profile
+}
+
+func newProfileDoc(t *testing.T, id string) *profileDoc {
+	t.Helper()
+	coll, err := mock.NewMockDriverProvider(zap.NewNop()).OpenDbDriver("outbox")
+	require.NoError(t, err)
+	k, err := key.NewKeyFromParts("profile", id)
+	require.NoError(t, err)
+	d := &profileDoc{Data: &profile{}}
+	d.Init(context.Background(), &d.Data, func() { d.Data = &profile{} }, coll, k)
+	return d
+}
+
+func TestDocumentOutboxCommit(t *testing.T) {
+	store := NewMockStore()
+	doc := newProfileDoc(t, "1")
+
+	msg, err := NewJSONMessage("local://profile.created", map[string]int{"level": 1})
+	require.NoError(t, err)
+	require.NoError(t, doc.CreateWithOutbox(store, msg))
+
+	// the version check fails, so neither the document nor the message is written
+	stale := newProfileDoc(t, "1")
+	stale.DocumentStore = doc.DocumentStore
+	require.ErrorIs(t, stale.SaveWithOutbox(store, NewMessage("local://profile.lost", nil)), nerrors.ErrVersionNotMatch)
+
+	require.NoError(t, doc.UpdateWithOutbox(store, func() bool {
+		doc.Data.Level = 2
+		return true
+	}, NewMessage("local://profile.levelup", []byte(`{"level":2}`))))
+
+	pending, err := store.Pending(context.Background(), 0)
+	require.NoError(t, err)
+	require.Len(t, pending, 2)
+	require.Equal(t, "local://profile.created", pending[0].Topic)
+	require.Equal(t, "local://profile.levelup", pending[1].Topic)
+	require.NotEmpty(t, pending[0].ID)
+}
+
+func TestRelayRetriesUntilPublished(t *testing.T) {
+	ctx := context.Background()
+	store := NewMockStore()
+	mq := &flakyMQ{failures: 1}
+	require.NoError(t, store.Commit(ctx, func(context.Context) error { return nil },
+		NewMessage("local://a", []byte("1")),
+	))
+
+	relay := NewRelay(store, mq, zap.NewNop(), WithRetryDelay(time.Millisecond, 10*time.Millisecond))
+	n, err := relay.Flush(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	require.Empty(t, mq.Published())
+
+	pending, err := store.Pending(ctx, 0)
+	require.NoError(t, err)
+	require.Empty(t, pending, "failed message waits for its retry delay")
+	time.Sleep(5 * time.Millisecond)
+
+	pending, err = store.Pending(ctx, 0)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	require.Equal(t, 1, pending[0].Attempts)
+	require.Equal(t, "broker unavailable", pending[0].LastError)
+
+	_, err = relay.Flush(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"local://a 1"}, mq.Published())
+	require.Len(t, store.Sent(), 1)
+}
+
+func TestRelayStartStop(t *testing.T) {
+	ctx := context.Background()
+	store := NewMockStore()
+	mq := &flakyMQ{}
+	relay := NewRelay(store, mq, zap.NewNop(), WithInterval(5*time.Millisecond), WithBatchSize(2))
+	require.NoError(t, relay.Start(ctx))
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, store.Commit(ctx, func(context.Context) error { return nil },
+			NewMessage("local://b", []byte{'0' + byte(i)}),
+		))
+	}
+	require.Eventually(t, func() bool { return len(mq.Published()) == 5 }, time.Second, 5*time.Millisecond)
+	require.NoError(t, relay.Stop(ctx))
+}
+
+func TestRelayNonPositiveIntervalKeepsDefault(t *testing.T) {
+	ctx := context.Background()
+	relay := NewRelay(NewMockStore(), &flakyMQ{}, zap.NewNop(), WithInterval(0))
+	require.Equal(t, time.Second, relay.interval)
+	require.NoError(t, relay.Start(ctx))
+	require.NoError(t, relay.Stop(ctx))
+}
+
+func TestRelaysClaimTheirBatches(t *testing.T) {
+	ctx := context.Background()
+	store := NewMockStore()
+	require.NoError(t, store.Commit(ctx, func(context.Context) error { return nil },
+		NewMessage("local://c", []byte("1")),
+	))
+	pending, err := store.Pending(ctx, 0)
+	require.NoError(t, err)
+
+	claimed, err := store.Claim(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+
+	// another replica finds nothing to publish while the claim lasts
+	mq := &flakyMQ{}
+	n, err := NewRelay(store, mq, zap.NewNop()).Flush(ctx)
+	require.NoError(t, err)
+	require.Zero(t, n)
+
+	// an interrupted claim expires and the message goes out with its outbox id
+	require.NoError(t, store.MarkFailed(ctx, claimed[0].ID, nil, time.Now()))
+	n, err = NewRelay(store, mq, zap.NewNop()).Flush(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	require.Equal(t, []string{pending[0].ID}, mq.ids)
+}
@@ -0,0 +1,177 @@
+package outbox
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/gstones/moke-kit/mq/miface"
+	"github.com/gstones/moke-kit/orm/nosql/diface"
+)
+
+// RelayOption configures a Relay.
+type RelayOption func(r *Relay)
+
+// WithInterval sets how often the relay polls for pending messages (default 1s), a non-positive
+// interval keeps the default.
+func WithInterval(d time.Duration) RelayOption {
+	return func(r *Relay) {
+		if d > 0 {
+			r.interval = d
+		}
+	}
+}
+
+// WithBatchSize sets how many messages are published per poll (default 100).
+func WithBatchSize(n int) RelayOption {
+	return func(r *Relay) {
+		r.batchSize = n
+	}
+}
+
+// WithLease sets how long the messages a relay claimed stay hidden from the relays of other
+// replicas, it must exceed the time a batch takes to publish (default 30s).
+func WithLease(d time.Duration) RelayOption {
+	return func(r *Relay) {
+		r.lease = d
+	}
+}
+
+// WithRetryDelay sets the delay after the first failed publish of a message, doubled on every
+// further failure up to max (default 1s and 5m).
+func WithRetryDelay(base, max time.Duration) RelayOption {
+	return func(r *Relay) {
+		r.baseDelay = base
+		r.maxDelay = max
+	}
+}
+
+// Relay publishes staged messages and marks them sent. A message is only marked sent after the
+// message queue accepted it, so a crash in between publishes it again; messages carry their outbox
+// ID as message id for consumers to drop the duplicates. Failed messages are retried with
+// exponential backoff without holding back the others, so ordering is best effort. Relays claim
+// their batches, so one can run on every replica.
+type Relay struct {
+	store  diface.IOutbox
+	mq     miface.MessageQueue
+	logger *zap.Logger
+
+	interval  time.Duration
+	batchSize int
+	lease     time.Duration
+	baseDelay time.Duration
+	maxDelay  time.Duration
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewRelay creates a Relay publishing messages of store to mq.
+func NewRelay(store diface.IOutbox, mq miface.MessageQueue, logger *zap.Logger, opts ...RelayOption) *Relay {
+	r := &Relay{
+		store:     store,
+		mq:        mq,
+		logger:    logger,
+		interval:  time.Second,
+		batchSize: 100,
+		lease:     30 * time.Second,
+		baseDelay: time.Second,
+		maxDelay:  5 * time.Minute,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Start runs the relay in the background until Stop.
+func (r *Relay) Start(context.Context) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// drain the backlog before waiting for the next tick
+				for {
+					n, err := r.Flush(ctx)
+					if err != nil {
+						r.logger.Warn("outbox relay poll failed", zap.Error(err))
+					}
+					if err != nil || n < r.batchSize || ctx.Err() != nil {
+						break
+					}
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop stops the background loop and waits for the current batch, or until ctx is done.
+func (r *Relay) Stop(ctx context.Context) error {
+	if r.cancel == nil {
+		return nil
+	}
+	r.cancel()
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Flush claims and publishes one batch of due messages and returns how many it handled.
+func (r *Relay) Flush(ctx context.Context) (int, error) {
+	msgs, err := r.store.Claim(ctx, r.batchSize, r.lease)
+	if err != nil {
+		return 0, err
+	}
+	for _, m := range msgs {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		if err := r.mq.Publish(m.Topic, miface.WithBytes(m.Data), miface.WithMessageId(m.ID)); err != nil {
+			next := time.Now().Add(r.retryDelay(m.Attempts))
+			r.logger.Warn("outbox publish failed",
+				zap.String("id", m.ID),
+				zap.String("topic", m.Topic),
+				zap.Int("attempts", m.Attempts+1),
+				zap.Error(err),
+			)
+			if err := r.store.MarkFailed(ctx, m.ID, err, next); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if err := r.store.MarkSent(ctx, m.ID); err != nil {
+			return 0, err
+		}
+	}
+	return len(msgs), nil
+}
+
+func (r *Relay) retryDelay(attempts int) time.Duration {
+	d := r.baseDelay
+	for i := 0; i < attempts && d < r.maxDelay; i++ {
+		d *= 2
+	}
+	if d > r.maxDelay {
+		d = r.maxDelay
+	}
+	return d
+}
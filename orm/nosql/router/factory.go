@@ -0,0 +1,69 @@
+package router
+
+import (
+	"net/url"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/gstones/moke-kit/orm/nerrors"
+	"github.com/gstones/moke-kit/orm/nosql/diface"
+	"github.com/gstones/moke-kit/orm/nosql/router/internal"
+)
+
+// Route sends every key located under Prefix to Database on Provider.
+type Route = internal.Route
+
+// Wildcard matches exactly one section of a key in a route prefix.
+const Wildcard = internal.Wildcard
+
+const (
+	specSeparator   = ";"
+	targetSeparator = "="
+)
+
+// NewProvider returns an IDocumentProvider that serves keys matching a route from that route's target and
+// every other key from fallback, so DocumentBase code is unchanged when data is relocated.
+func NewProvider(
+	logger *zap.Logger,
+	fallback diface.IDocumentProvider,
+	routes ...Route,
+) (diface.IDocumentProvider, error) {
+	return internal.NewRouterProvider(logger, fallback, routes...)
+}
+
+// RouteSpec is the configured form of a Route: a key prefix and the url of the store that owns it.
+type RouteSpec struct {
+	Prefix string
+	URL    string
+}
+
+// Database returns the database named by the url path, or "" to keep the opened name.
+func (rs RouteSpec) Database() string {
+	if u, err := url.Parse(rs.URL); err == nil {
+		return strings.Trim(u.Path, "/")
+	}
+	return ""
+}
+
+// ParseRouteSpecs parses a list of prefix=url pairs separated by ";", e.g.
+// "/prod/audit=mongodb://audit:27017/audit;/*/purchase=mongodb://pay:27017/purchase".
+func ParseRouteSpecs(spec string) ([]RouteSpec, error) {
+	var specs []RouteSpec
+	for _, item := range strings.Split(spec, specSeparator) {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		prefix, target, ok := strings.Cut(item, targetSeparator)
+		prefix, target = strings.TrimSpace(prefix), strings.TrimSpace(target)
+		if !ok || prefix == "" || target == "" {
+			return nil, nerrors.ErrInvalidRouteSpec
+		}
+		if _, err := url.Parse(target); err != nil {
+			return nil, nerrors.ErrInvalidRouteSpec
+		}
+		specs = append(specs, RouteSpec{Prefix: prefix, URL: target})
+	}
+	return specs, nil
+}
@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"context"
+	"strings"
+
+	"github.com/gstones/moke-kit/orm/nerrors"
+	"github.com/gstones/moke-kit/orm/nosql/diface"
+	"github.com/gstones/moke-kit/orm/nosql/key"
+	"github.com/gstones/moke-kit/orm/nosql/noptions"
+)
+
+type target struct {
+	sections []string
+	coll     diface.ICollection
+}
+
+// RouterCollection is an ICollection that forwards each call to the target owning the key.
+type RouterCollection struct {
+	fallback diface.ICollection
+	targets  []target
+}
+
+func newRouterCollection(fallback diface.ICollection, targets []target) *RouterCollection {
+	return &RouterCollection{
+		fallback: fallback,
+		targets:  targets,
+	}
+}
+
+// Route returns the collection serving k: the longest matching route prefix wins,
+// ties go to the route declared first, and unmatched keys go to the fallback.
+func (rc *RouterCollection) Route(k key.Key) diface.ICollection {
+	parts := k.Parts()
+	best, bestLen := rc.fallback, 0
+	for _, t := range rc.targets {
+		if len(t.sections) > bestLen && matchSections(t.sections, parts) {
+			best, bestLen = t.coll, len(t.sections)
+		}
+	}
+	return best
+}
+
+// GetName returns the name of the fallback collection.
+func (rc *RouterCollection) GetName() string {
+	return rc.fallback.GetName()
+}
+
+// Set forwards to the collection owning key.
+func (rc *RouterCollection) Set(ctx context.Context, key key.Key, opts ...noptions.Option) (noptions.Version, error) {
+	return rc.Route(key).Set(ctx, key, opts...)
+}
+
+// Get forwards to the collection owning key.
+func (rc *RouterCollection) Get(ctx context.Context, key key.Key, opts ...noptions.Option) (noptions.Version, error) {
+	return rc.Route(key).Get(ctx, key, opts...)
+}
+
+// Delete forwards to the collection owning key.
+func (rc *RouterCollection) Delete(ctx context.Context, key key.Key) error {
+	return rc.Route(key).Delete(ctx, key)
+}
+
+// Incr forwards to the collection owning key.
+func (rc *RouterCollection) Incr(ctx context.Context, key key.Key, field string, amount int32) (int64, error) {
+	return rc.Route(key).Incr(ctx, key, field, amount)
+}
+
+// Scan covers every collection holding keys under prefix: the one owning prefix and those of the
+// routes below it, each document being taken from the collection its key routes to, so scanning a
+// parent namespace does not miss the prefixes routed elsewhere. It fails with ErrScanNotSupported
+// if one of them cannot scan.
+func (rc *RouterCollection) Scan(
+	ctx context.Context,
+	prefix string,
+	fn func(k key.Key, version noptions.Version, data []byte) error,
+) error {
+	var scanners []diface.IScanner
+	seen := map[diface.IScanner]bool{}
+	add := func(coll diface.ICollection) error {
+		scanner, ok := coll.(diface.IScanner)
+		if !ok {
+			return nerrors.ErrScanNotSupported
+		}
+		if !seen[scanner] {
+			seen[scanner] = true
+			scanners = append(scanners, scanner)
+		}
+		return nil
+	}
+
+	var sections []string
+	owner := rc.fallback
+	if prefix != "" {
+		sections = strings.Split(strings.Trim(prefix, key.KeySeparator), key.KeySeparator)
+		// route on a placeholder child, so single section prefixes such as /player resolve too
+		owner = rc.Route(key.NewKeyFromStringUnchecked(prefix + key.KeySeparator + "_"))
+	}
+	if err := add(owner); err != nil {
+		return err
+	}
+	for _, t := range rc.targets {
+		if len(t.sections) > len(sections) && matchSections(t.sections[:len(sections)], sections) {
+			if err := add(t.coll); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, scanner := range scanners {
+		err := scanner.Scan(ctx, prefix, func(k key.Key, version noptions.Version, data []byte) error {
+			// keys routed to another collection are that one's, e.g. when routes share a backend
+			if s, ok := rc.Route(k).(diface.IScanner); !ok || s != scanner {
+				return nil
+			}
+			return fn(k, version, data)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"errors"
+
+	"go.uber.org/zap"
+
+	"github.com/gstones/moke-kit/orm/nerrors"
+	"github.com/gstones/moke-kit/orm/nosql/diface"
+	"github.com/gstones/moke-kit/orm/nosql/key"
+)
+
+// RouterProvider is an IDocumentProvider that spreads a logical database across several targets by key prefix.
+type RouterProvider struct {
+	fallback diface.IDocumentProvider
+	routes   []Route
+	logger   *zap.Logger
+}
+
+// NewRouterProvider creates a RouterProvider. Keys that match no route are served by fallback.
+func NewRouterProvider(
+	logger *zap.Logger,
+	fallback diface.IDocumentProvider,
+	routes ...Route,
+) (*RouterProvider, error) {
+	if fallback == nil {
+		return nil, nerrors.ErrNoFallbackProvider
+	}
+	for _, r := range routes {
+		if r.Provider == nil {
+			return nil, nerrors.ErrNoRouteProvider
+		}
+		for _, s := range r.sections() {
+			if s == Wildcard {
+				continue
+			}
+			if err := key.ValidatePart(s); err != nil {
+				return nil, nerrors.ErrInvalidRoutePrefix
+			}
+		}
+	}
+	return &RouterProvider{
+		fallback: fallback,
+		routes:   routes,
+		logger:   logger,
+	}, nil
+}
+
+// OpenDbDriver opens name on the fallback and every routed target.
+func (rp *RouterProvider) OpenDbDriver(name string) (diface.ICollection, error) {
+	def, err := rp.fallback.OpenDbDriver(name)
+	if err != nil {
+		return nil, err
+	}
+	targets := make([]target, 0, len(rp.routes))
+	for _, r := range rp.routes {
+		db := r.Database
+		if db == "" {
+			db = name
+		}
+		coll, err := r.Provider.OpenDbDriver(db)
+		if err != nil {
+			return nil, err
+		}
+		rp.logger.Info(
+			"route document prefix",
+			zap.String("name", name),
+			zap.String("prefix", r.Prefix),
+			zap.String("database", db),
+		)
+		targets = append(targets, target{sections: r.sections(), coll: coll})
+	}
+	return newRouterCollection(def, targets), nil
+}
+
+// Shutdown shuts down the fallback and every distinct routed provider.
+func (rp *RouterProvider) Shutdown() error {
+	seen := map[diface.IDocumentProvider]bool{rp.fallback: true}
+	errs := []error{rp.fallback.Shutdown()}
+	for _, r := range rp.routes {
+		if seen[r.Provider] {
+			continue
+		}
+		seen[r.Provider] = true
+		errs = append(errs, r.Provider.Shutdown())
+	}
+	return errors.Join(errs...)
+}
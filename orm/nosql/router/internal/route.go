@@ -0,0 +1,36 @@
+package internal
+
+import (
+	"strings"
+
+	"github.com/gstones/moke-kit/orm/nosql/diface"
+	"github.com/gstones/moke-kit/orm/nosql/key"
+)
+
+// Wildcard matches exactly one section of a key in a route prefix.
+const Wildcard = "*"
+
+// Route sends every key located under Prefix to Database on Provider.
+// Prefix sections may be Wildcard, e.g. /*/audit matches the audit data of every namespace.
+// An empty Database keeps the name passed to OpenDbDriver.
+type Route struct {
+	Prefix   string
+	Provider diface.IDocumentProvider
+	Database string
+}
+
+func (r Route) sections() []string {
+	return strings.Split(strings.Trim(r.Prefix, key.KeySeparator), key.KeySeparator)
+}
+
+func matchSections(pattern []string, parts []string) bool {
+	if len(parts) < len(pattern) {
+		return false
+	}
+	for i, p := range pattern {
+		if p != Wildcard && p != parts[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,143 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/gstones/moke-kit/orm/nerrors"
+	"github.com/gstones/moke-kit/orm/nosql/backup"
+	"github.com/gstones/moke-kit/orm/nosql/diface"
+	"github.com/gstones/moke-kit/orm/nosql/key"
+	"github.com/gstones/moke-kit/orm/nosql/mock"
+	"github.com/gstones/moke-kit/orm/nosql/noptions"
+)
+
+// fixedProvider always hands out the same collection so tests can inspect where data landed.
+type fixedProvider struct {
+	coll     diface.ICollection
+	opened   []string
+	shutdown int
+}
+
+func newFixedProvider(t *testing.T) *fixedProvider {
+	coll, err := mock.NewMockDriverProvider(zap.NewNop()).OpenDbDriver("fixed")
+	require.NoError(t, err)
+	return &fixedProvider{coll: coll}
+}
+
+func (p *fixedProvider) OpenDbDriver(name string) (diface.ICollection, error) {
+	p.opened = append(p.opened, name)
+	return p.coll, nil
+}
+
+func (p *fixedProvider) Shutdown() error {
+	p.shutdown++
+	return nil
+}
+
+func TestRouterRoutesByPrefix(t *testing.T) {
+	ctx := context.Background()
+	fallback, audit, purchase := newFixedProvider(t), newFixedProvider(t), newFixedProvider(t)
+
+	provider, err := NewProvider(zap.NewNop(), fallback,
+		Route{Prefix: "/prod/audit", Provider: audit, Database: "audit"},
+		Route{Prefix: "/*/purchase", Provider: purchase},
+		Route{Prefix: "/prod/purchase/vip", Provider: audit},
+	)
+	require.NoError(t, err)
+	coll, err := provider.OpenDbDriver("game")
+	require.NoError(t, err)
+	require.Equal(t, []string{"audit", "game"}, audit.opened)
+	require.Equal(t, []string{"game"}, purchase.opened)
+
+	cases := []struct {
+		key  string
+		want *fixedProvider
+	}{
+		{"/prod/audit/1", audit},
+		{"/prod/purchase/1", purchase},
+		{"/dev/purchase/1", purchase},
+		{"/prod/purchase/vip/1", audit},
+		{"/prod/player/1", fallback},
+		{"/prod/auditlog/1", fallback},
+	}
+	for _, c := range cases {
+		k := key.NewKeyFromStringUnchecked(c.key)
+		_, err := coll.Set(ctx, k, noptions.WithSource(map[string]string{"k": c.key}))
+		require.NoError(t, err)
+
+		var got map[string]string
+		_, err = c.want.coll.Get(ctx, k, noptions.WithDestination(&got))
+		require.NoError(t, err, c.key)
+		require.Equal(t, c.key, got["k"])
+	}
+
+	require.NoError(t, provider.Shutdown())
+	require.Equal(t, 1, fallback.shutdown)
+	require.Equal(t, 1, audit.shutdown)
+	require.Equal(t, 1, purchase.shutdown)
+}
+
+func TestRouterExportCoversRoutes(t *testing.T) {
+	ctx := context.Background()
+	fallback, audit, purchase := newFixedProvider(t), newFixedProvider(t), newFixedProvider(t)
+	provider, err := NewProvider(zap.NewNop(), fallback,
+		Route{Prefix: "/prod/audit", Provider: audit},
+		Route{Prefix: "/*/purchase", Provider: purchase},
+		Route{Prefix: "/prod/purchase/vip", Provider: audit},
+	)
+	require.NoError(t, err)
+	coll, err := provider.OpenDbDriver("game")
+	require.NoError(t, err)
+
+	keys := []string{"/prod/audit/1", "/prod/purchase/1", "/dev/purchase/1", "/prod/purchase/vip/1", "/prod/player/1"}
+	for _, k := range keys {
+		_, err := coll.Set(ctx, key.NewKeyFromStringUnchecked(k), noptions.WithSource(map[string]string{"k": k}))
+		require.NoError(t, err)
+	}
+
+	exported := func(prefix string) []string {
+		var buf bytes.Buffer
+		_, err := backup.Export(ctx, coll, &buf, prefix)
+		require.NoError(t, err)
+		var got []string
+		dec := json.NewDecoder(&buf)
+		for dec.More() {
+			var r backup.Record
+			require.NoError(t, dec.Decode(&r))
+			got = append(got, r.Key)
+		}
+		return got
+	}
+	require.ElementsMatch(t, keys, exported(""))
+	require.ElementsMatch(t, []string{"/prod/audit/1", "/prod/purchase/1", "/prod/purchase/vip/1", "/prod/player/1"},
+		exported("/prod"))
+	require.ElementsMatch(t, []string{"/prod/purchase/1", "/prod/purchase/vip/1"}, exported("/prod/purchase"))
+}
+
+func TestRouterRejectsInvalidRoutes(t *testing.T) {
+	fallback := newFixedProvider(t)
+	_, err := NewProvider(zap.NewNop(), nil)
+	require.ErrorIs(t, err, nerrors.ErrNoFallbackProvider)
+	_, err = NewProvider(zap.NewNop(), fallback, Route{Prefix: "/prod/audit"})
+	require.ErrorIs(t, err, nerrors.ErrNoRouteProvider)
+	_, err = NewProvider(zap.NewNop(), fallback, Route{Prefix: "/prod/a b", Provider: fallback})
+	require.ErrorIs(t, err, nerrors.ErrInvalidRoutePrefix)
+}
+
+func TestParseRouteSpecs(t *testing.T) {
+	specs, err := ParseRouteSpecs(" /prod/audit=mongodb://a:27017,b:27017/audit?replicaSet=rs0 ; /*/purchase=mock://pay ")
+	require.NoError(t, err)
+	require.Len(t, specs, 2)
+	require.Equal(t, "/prod/audit", specs[0].Prefix)
+	require.Equal(t, "audit", specs[0].Database())
+	require.Equal(t, "", specs[1].Database())
+
+	_, err = ParseRouteSpecs("/prod/audit")
+	require.ErrorIs(t, err, nerrors.ErrInvalidRouteSpec)
+}
@@ -0,0 +1,412 @@
+package nosql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
+	"go.uber.org/zap"
+
+	"github.com/gstones/moke-kit/orm/nerrors"
+	"github.com/gstones/moke-kit/orm/nosql/diface"
+	"github.com/gstones/moke-kit/orm/nosql/key"
+	"github.com/gstones/moke-kit/orm/nosql/noptions"
+)
+
+const meterName = "github.com/gstones/moke-kit/orm"
+
+// WriteBehindOption configures a WriteBehind.
+type WriteBehindOption func(wb *WriteBehind)
+
+// WithFlushInterval sets how often dirty documents are flushed (default 1s), a non-positive interval
+// keeps the default. It bounds how old the unflushed changes lost by a crash can be, as long as the
+// store accepts the writes.
+func WithFlushInterval(d time.Duration) WriteBehindOption {
+	return func(wb *WriteBehind) {
+		if d > 0 {
+			wb.interval = d
+		}
+	}
+}
+
+// WithMaxPending flushes right away once n documents are dirty (default 1000).
+func WithMaxPending(n int) WriteBehindOption {
+	return func(wb *WriteBehind) {
+		wb.maxPending = n
+	}
+}
+
+// WithConflictHandler is called after a flush found that another writer changed a document.
+// The deferred changes of that document are dropped and its next Save returns the conflict.
+func WithConflictHandler(f func(k key.Key, err error)) WriteBehindOption {
+	return func(wb *WriteBehind) {
+		wb.onConflict = f
+	}
+}
+
+// WithWriteBehindLogger sets the logger reporting failed flushes.
+func WithWriteBehindLogger(l *zap.Logger) WriteBehindOption {
+	return func(wb *WriteBehind) {
+		wb.logger = l
+	}
+}
+
+// WriteBehindStats is a snapshot of the WriteBehind counters.
+type WriteBehindStats struct {
+	// Pending is the number of dirty documents.
+	Pending int
+	// OldestPending is how long the oldest dirty document has been waiting.
+	OldestPending time.Duration
+	Flushed       int64
+	Conflicts     int64
+	Failures      int64
+}
+
+// WriteBehind defers the saves of documents attached with DocumentBase.EnableWriteBehind.
+// Their Save and Update only snapshot the data, update the cache and mark them dirty; the
+// snapshots are CAS written in the background every interval, when MaxPending documents are
+// dirty, on Flush and on Stop. Only the latest snapshot of a document is written.
+//
+// Snapshots are taken through encoding/json, so data must survive a JSON round trip.
+// Metrics are reported to the global OpenTelemetry meter provider as orm.write_behind.*.
+type WriteBehind struct {
+	logger     *zap.Logger
+	interval   time.Duration
+	maxPending int
+	onConflict func(k key.Key, err error)
+
+	mu    sync.Mutex
+	dirty map[*writeBehindEntry]struct{}
+	kick  chan struct{}
+	// flushing serializes flushes, so an entry is never written twice at once
+	flushing sync.Mutex
+
+	flushed   atomic.Int64
+	conflicts atomic.Int64
+	failures  atomic.Int64
+
+	registration metric.Registration
+	cancel       context.CancelFunc
+	wg           sync.WaitGroup
+}
+
+// NewWriteBehind creates a WriteBehind, call Start to flush in the background.
+func NewWriteBehind(opts ...WriteBehindOption) *WriteBehind {
+	wb := &WriteBehind{
+		logger:     zap.NewNop(),
+		interval:   time.Second,
+		maxPending: 1000,
+		dirty:      make(map[*writeBehindEntry]struct{}),
+		kick:       make(chan struct{}, 1),
+	}
+	for _, opt := range opts {
+		opt(wb)
+	}
+	wb.registerMetrics()
+	return wb
+}
+
+func (wb *WriteBehind) registerMetrics() {
+	meter := otel.Meter(meterName)
+	pending, err1 := meter.Int64ObservableGauge("orm.write_behind.pending",
+		metric.WithDescription("Dirty documents waiting for a flush"))
+	oldest, err2 := meter.Float64ObservableGauge("orm.write_behind.oldest_pending",
+		metric.WithDescription("Age of the oldest dirty document"), metric.WithUnit("s"))
+	flushed, err3 := meter.Int64ObservableCounter("orm.write_behind.flushed",
+		metric.WithDescription("Documents written by flushes"))
+	conflicts, err4 := meter.Int64ObservableCounter("orm.write_behind.conflicts",
+		metric.WithDescription("Flushes dropped because of a version conflict"))
+	failures, err5 := meter.Int64ObservableCounter("orm.write_behind.failures",
+		metric.WithDescription("Flushes failed and kept for the next attempt"))
+	if err := errors.Join(err1, err2, err3, err4, err5); err != nil {
+		wb.logger.Warn("write behind metrics disabled", zap.Error(err))
+		return
+	}
+	reg, err := meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		s := wb.Stats()
+		o.ObserveInt64(pending, int64(s.Pending))
+		o.ObserveFloat64(oldest, s.OldestPending.Seconds())
+		o.ObserveInt64(flushed, s.Flushed)
+		o.ObserveInt64(conflicts, s.Conflicts)
+		o.ObserveInt64(failures, s.Failures)
+		return nil
+	}, pending, oldest, flushed, conflicts, failures)
+	if err != nil {
+		wb.logger.Warn("write behind metrics disabled", zap.Error(err))
+		return
+	}
+	wb.registration = reg
+}
+
+// Stats returns the current counters.
+func (wb *WriteBehind) Stats() WriteBehindStats {
+	wb.mu.Lock()
+	entries := wb.dirtyEntries()
+	wb.mu.Unlock()
+
+	s := WriteBehindStats{
+		Pending:   len(entries),
+		Flushed:   wb.flushed.Load(),
+		Conflicts: wb.conflicts.Load(),
+		Failures:  wb.failures.Load(),
+	}
+	now := time.Now()
+	for _, e := range entries {
+		e.mu.Lock()
+		if !e.since.IsZero() && now.Sub(e.since) > s.OldestPending {
+			s.OldestPending = now.Sub(e.since)
+		}
+		e.mu.Unlock()
+	}
+	return s
+}
+
+// Start flushes in the background until Stop.
+func (wb *WriteBehind) Start(context.Context) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	wb.cancel = cancel
+	wb.wg.Add(1)
+	go func() {
+		defer wb.wg.Done()
+		ticker := time.NewTicker(wb.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-wb.kick:
+			}
+			if err := wb.Flush(ctx); err != nil && ctx.Err() == nil {
+				wb.logger.Warn("write behind flush failed", zap.Error(err))
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop stops the background loop and flushes every dirty document.
+func (wb *WriteBehind) Stop(ctx context.Context) error {
+	if wb.cancel != nil {
+		wb.cancel()
+		wb.wg.Wait()
+	}
+	if wb.registration != nil {
+		_ = wb.registration.Unregister()
+		wb.registration = nil
+	}
+	return wb.Flush(ctx)
+}
+
+// Flush writes every dirty document now. Documents that failed stay dirty, except conflicting
+// ones, and the errors are joined.
+func (wb *WriteBehind) Flush(ctx context.Context) error {
+	wb.flushing.Lock()
+	defer wb.flushing.Unlock()
+
+	wb.mu.Lock()
+	entries := wb.dirtyEntries()
+	wb.mu.Unlock()
+
+	var errs []error
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return errors.Join(append(errs, err)...)
+		}
+		if err := wb.flushEntry(ctx, e); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", e.key.String(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (wb *WriteBehind) dirtyEntries() []*writeBehindEntry {
+	entries := make([]*writeBehindEntry, 0, len(wb.dirty))
+	for e := range wb.dirty {
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func (wb *WriteBehind) markDirty(e *writeBehindEntry) {
+	wb.mu.Lock()
+	wb.dirty[e] = struct{}{}
+	full := wb.maxPending > 0 && len(wb.dirty) >= wb.maxPending
+	wb.mu.Unlock()
+	if full {
+		select {
+		case wb.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// forget removes e from the dirty set. The caller holds e.mu, so a concurrent save marks e
+// dirty again only after its snapshot is visible.
+func (wb *WriteBehind) forget(e *writeBehindEntry) {
+	wb.mu.Lock()
+	delete(wb.dirty, e)
+	wb.mu.Unlock()
+}
+
+func (wb *WriteBehind) flushEntry(ctx context.Context, e *writeBehindEntry) error {
+	e.mu.Lock()
+	snapshot, seq, version := e.pending, e.seq, e.version
+	if snapshot == nil {
+		wb.forget(e)
+		e.mu.Unlock()
+		return nil
+	}
+	e.mu.Unlock()
+
+	opts := []noptions.Option{noptions.WithSource(snapshot)}
+	if version != noptions.NoVersion {
+		opts = append(opts, noptions.WithVersion(version))
+	}
+	newVersion, err := e.store.Set(ctx, e.key, opts...)
+	if err != nil && !errors.Is(err, nerrors.ErrVersionNotMatch) {
+		wb.failures.Add(1)
+		return err
+	}
+
+	e.mu.Lock()
+	if err != nil {
+		e.pending, e.since, e.err = nil, time.Time{}, err
+		wb.forget(e)
+		e.mu.Unlock()
+		wb.conflicts.Add(1)
+		// the cache holds the dropped changes
+		e.cache.DeleteCache(ctx, e.key)
+		if wb.onConflict != nil {
+			wb.onConflict(e.key, err)
+		} else {
+			wb.logger.Warn("write behind conflict, changes dropped", zap.String("key", e.key.String()))
+		}
+		return err
+	}
+	e.version = newVersion
+	if e.seq == seq {
+		e.pending, e.since = nil, time.Time{}
+		wb.forget(e)
+	}
+	e.mu.Unlock()
+	wb.flushed.Add(1)
+	return nil
+}
+
+// writeBehindEntry is the state of a document shared between its owner and the flusher.
+type writeBehindEntry struct {
+	wb    *WriteBehind
+	store diface.ICollection
+	cache diface.ICache
+	key   key.Key
+
+	mu sync.Mutex
+	// version is the version in the store the next flush expects
+	version noptions.Version
+	// pending is the latest unflushed snapshot and seq counts snapshots
+	pending any
+	seq     uint64
+	since   time.Time
+	// err is a conflict found by a flush, returned by the next Save
+	err error
+}
+
+// save replaces the pending snapshot of the document.
+func (e *writeBehindEntry) save(d *DocumentBase) error {
+	snapshot, err := snapshotOf(d.data)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	if e.err != nil {
+		err, e.err = e.err, nil
+		e.mu.Unlock()
+		return err
+	}
+	e.pending = snapshot
+	e.seq++
+	if e.since.IsZero() {
+		e.since = time.Now()
+	}
+	d.version = e.version
+	e.mu.Unlock()
+
+	d.cache.SetCache(d.ctx, d.Key, &VersionCache{
+		Version: d.version,
+		Data:    d.data,
+	}, DefaultCacheTTL)
+	e.wb.markDirty(e)
+	return nil
+}
+
+// restore loads the pending snapshot into the document, if there is one.
+func (e *writeBehindEntry) restore(d *DocumentBase) (bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.pending == nil {
+		return false, nil
+	}
+	data, err := json.Marshal(e.pending)
+	if err != nil {
+		return true, err
+	}
+	d.clear()
+	d.version = e.version
+	return true, json.Unmarshal(data, d.data)
+}
+
+// loaded records the version a clean document was loaded at. A cached version may be older than
+// the last flush, so the newest one wins.
+func (e *writeBehindEntry) loaded(version noptions.Version) {
+	e.mu.Lock()
+	if e.pending == nil && version > e.version {
+		e.version = version
+	}
+	e.mu.Unlock()
+}
+
+// reset forgets the pending snapshot and sets the version, after a synchronous write.
+func (e *writeBehindEntry) reset(version noptions.Version) {
+	e.mu.Lock()
+	e.pending, e.since, e.err = nil, time.Time{}, nil
+	e.version = version
+	e.wb.forget(e)
+	e.mu.Unlock()
+}
+
+// snapshotOf deep copies data through JSON, so the owner can keep changing it while it is flushed.
+func snapshotOf(data any) (any, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	p := reflect.New(reflect.TypeOf(data))
+	if err := json.Unmarshal(raw, p.Interface()); err != nil {
+		return nil, err
+	}
+	return p.Elem().Interface(), nil
+}
+
+// EnableWriteBehind defers the saves of this document to wb: Save and Update return once the
+// change is snapshotted and cached, and the store is written by the next flush. Load returns the
+// unflushed data if there is some. When a flush finds that another writer changed the document,
+// its changes are dropped and the next Save returns ErrVersionNotMatch, so Update reloads and
+// retries. Create and Delete stay synchronous. The document must only be used by one goroutine,
+// like any DocumentBase.
+func (d *DocumentBase) EnableWriteBehind(wb *WriteBehind) {
+	d.behind = &writeBehindEntry{
+		wb:      wb,
+		store:   d.DocumentStore,
+		cache:   d.cache,
+		key:     d.Key,
+		version: d.version,
+	}
+}
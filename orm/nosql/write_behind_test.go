@@ -0,0 +1,150 @@
+package nosql
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/gstones/moke-kit/orm/nerrors"
+	"github.com/gstones/moke-kit/orm/nosql/diface"
+	"github.com/gstones/moke-kit/orm/nosql/key"
+	"github.com/gstones/moke-kit/orm/nosql/mock"
+)
+
+func newWriteBehindDoc(t *testing.T, coll diface.ICollection, k key.Key) *testDoc {
+	t.Helper()
+	td := &testDoc{Data: &docPayload{}}
+	td.Init(context.Background(), &td.Data, func() { td.Data = nil }, coll, k)
+	return td
+}
+
+func storedMessage(t *testing.T, coll diface.ICollection, k key.Key) string {
+	t.Helper()
+	td := newWriteBehindDoc(t, coll, k)
+	require.NoError(t, td.Load())
+	return td.Data.Message
+}
+
+func TestWriteBehind_DefersUpdates(t *testing.T) {
+	t.Parallel()
+
+	coll, err := mock.NewMockDriverProvider(zaptest.NewLogger(t)).OpenDbDriver("write-behind")
+	require.NoError(t, err)
+	k, err := key.NewKeyFromParts("player", "1")
+	require.NoError(t, err)
+
+	wb := NewWriteBehind()
+	td := newWriteBehindDoc(t, coll, k)
+	td.Data.Message = "v0"
+	require.NoError(t, td.Create())
+	td.EnableWriteBehind(wb)
+
+	for _, msg := range []string{"v1", "v2", "v3"} {
+		require.NoError(t, td.Update(func() bool {
+			td.Data.Message = msg
+			return true
+		}))
+	}
+	require.Equal(t, "v0", storedMessage(t, coll, k))
+	require.Equal(t, 1, wb.Stats().Pending)
+
+	// the owner keeps seeing its unflushed data
+	require.NoError(t, td.Load())
+	require.Equal(t, "v3", td.Data.Message)
+
+	require.NoError(t, wb.Flush(context.Background()))
+	require.Equal(t, "v3", storedMessage(t, coll, k))
+	stats := wb.Stats()
+	require.Equal(t, 0, stats.Pending)
+	require.EqualValues(t, 1, stats.Flushed)
+
+	// the version moved on, so the next flush is a CAS on top of the first one
+	require.NoError(t, td.Update(func() bool {
+		td.Data.Message = "v4"
+		return true
+	}))
+	require.NoError(t, wb.Stop(context.Background()))
+	require.Equal(t, "v4", storedMessage(t, coll, k))
+}
+
+func TestWriteBehind_Conflict(t *testing.T) {
+	t.Parallel()
+
+	coll, err := mock.NewMockDriverProvider(zaptest.NewLogger(t)).OpenDbDriver("write-behind-conflict")
+	require.NoError(t, err)
+	k, err := key.NewKeyFromParts("guild", "1")
+	require.NoError(t, err)
+
+	var conflicts atomic.Int32
+	wb := NewWriteBehind(WithConflictHandler(func(ck key.Key, err error) {
+		require.Equal(t, k, ck)
+		require.ErrorIs(t, err, nerrors.ErrVersionNotMatch)
+		conflicts.Add(1)
+	}))
+
+	td := newWriteBehindDoc(t, coll, k)
+	td.Data.Message = "a"
+	require.NoError(t, td.Create())
+	td.EnableWriteBehind(wb)
+	require.NoError(t, td.Update(func() bool {
+		td.Data.Message += "b"
+		return true
+	}))
+
+	other := newWriteBehindDoc(t, coll, k)
+	require.NoError(t, other.Load())
+	require.NoError(t, other.Update(func() bool {
+		other.Data.Message += "c"
+		return true
+	}))
+
+	require.ErrorIs(t, wb.Flush(context.Background()), nerrors.ErrVersionNotMatch)
+	require.EqualValues(t, 1, conflicts.Load())
+	require.EqualValues(t, 1, wb.Stats().Conflicts)
+	require.Equal(t, "ac", storedMessage(t, coll, k))
+
+	// the next update sees the conflict, reloads and applies the change on the stored data
+	require.NoError(t, td.Update(func() bool {
+		td.Data.Message += "d"
+		return true
+	}))
+	require.NoError(t, wb.Flush(context.Background()))
+	require.Equal(t, "acd", storedMessage(t, coll, k))
+}
+
+func TestWriteBehind_FlushesWhenFull(t *testing.T) {
+	t.Parallel()
+
+	coll, err := mock.NewMockDriverProvider(zaptest.NewLogger(t)).OpenDbDriver("write-behind-full")
+	require.NoError(t, err)
+
+	wb := NewWriteBehind(WithFlushInterval(time.Hour), WithMaxPending(2))
+	require.NoError(t, wb.Start(context.Background()))
+	defer func() { require.NoError(t, wb.Stop(context.Background())) }()
+
+	var keys []key.Key
+	for _, id := range []string{"1", "2"} {
+		k, err := key.NewKeyFromParts("session", id)
+		require.NoError(t, err)
+		keys = append(keys, k)
+		td := newWriteBehindDoc(t, coll, k)
+		td.EnableWriteBehind(wb)
+		td.Data.Message = "new " + id
+		// a document without a version is created by its first flush
+		require.NoError(t, td.Save())
+	}
+	require.Eventually(t, func() bool { return wb.Stats().Pending == 0 }, time.Second, 5*time.Millisecond)
+	require.Equal(t, "new 1", storedMessage(t, coll, keys[0]))
+	require.Equal(t, "new 2", storedMessage(t, coll, keys[1]))
+}
+
+func TestWriteBehind_NonPositiveIntervalKeepsDefault(t *testing.T) {
+	wb := NewWriteBehind(WithFlushInterval(0))
+	require.Equal(t, time.Second, wb.interval)
+	require.NoError(t, wb.Start(context.Background()))
+	require.NoError(t, wb.Stop(context.Background()))
+}
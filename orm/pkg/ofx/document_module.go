@@ -5,13 +5,18 @@ import (
 	"net/url"
 
 	mongo2 "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.uber.org/fx"
 	"go.uber.org/zap"
+	"gorm.io/gorm"
 
 	"github.com/gstones/moke-kit/orm/nerrors"
 	"github.com/gstones/moke-kit/orm/nosql/diface"
+	gormdoc "github.com/gstones/moke-kit/orm/nosql/gorm"
 	"github.com/gstones/moke-kit/orm/nosql/mock"
 	"github.com/gstones/moke-kit/orm/nosql/mongo"
+	"github.com/gstones/moke-kit/orm/nosql/router"
+	"github.com/gstones/moke-kit/utility"
 )
 
 type DocumentStoreParams struct {
@@ -24,37 +29,126 @@ type DocumentStoreResult struct {
 	DriverProvider diface.IDocumentProvider `name:"DriverProvider"`
 }
 
+// documentGormParams lets the document store run on the GormDB when one is injected.
+type documentGormParams struct {
+	fx.In
+	GormDB *gorm.DB `name:"GormDB" optional:"true"`
+}
+
+// documentMongoParams leaves the MongoClient out of deployments storing documents in SQL only.
+type documentMongoParams struct {
+	fx.In
+	MongoClient *mongo2.Client `name:"MongoClient" optional:"true"`
+}
+
 func (dsr *DocumentStoreResult) init(
 	lc fx.Lifecycle,
 	l *zap.Logger,
 	mClient *mongo2.Client,
+	gormDB *gorm.DB,
 	connect string,
+	routes string,
 ) error {
 	if connect == "" {
 		return nerrors.ErrMissingNosqlURL
 	}
 
-	if u, e := url.Parse(connect); e != nil {
+	if sqlScheme(connect) != "" {
+		if gormDB == nil {
+			return nerrors.ErrMissingGormDB
+		}
+		dsr.DriverProvider = gormdoc.NewProvider(gormDB, l)
+	} else if u, e := url.Parse(connect); e != nil {
 		return e
 	} else {
 		switch u.Scheme {
 		case "mongodb", "mongodb+srv":
+			if mClient == nil {
+				return nerrors.ErrMissingMongoClient
+			}
 			dsr.DriverProvider = mongo.NewProvider(mClient, l)
 		case "mock":
 			dsr.DriverProvider = mock.NewMockDriverProvider(l)
 		default:
 			return nerrors.ErrInvalidNosqlURL
 		}
-		lc.Append(fx.Hook{
-			OnStop: func(context.Context) error {
-				return dsr.DriverProvider.Shutdown()
-			},
-		})
 	}
+	if routes != "" {
+		if p, err := createRouter(l, dsr.DriverProvider, routes); err != nil {
+			_ = dsr.DriverProvider.Shutdown()
+			return err
+		} else {
+			dsr.DriverProvider = p
+		}
+	}
+	lc.Append(fx.Hook{
+		OnStop: func(context.Context) error {
+			return dsr.DriverProvider.Shutdown()
+		},
+	})
 
 	return nil
 }
 
+// createRouter wraps fallback in a router provider, connecting to each distinct route url once.
+func createRouter(
+	l *zap.Logger,
+	fallback diface.IDocumentProvider,
+	routes string,
+) (diface.IDocumentProvider, error) {
+	specs, err := router.ParseRouteSpecs(routes)
+	if err != nil {
+		return nil, err
+	}
+	providers := make(map[string]diface.IDocumentProvider)
+	rs := make([]router.Route, 0, len(specs))
+	shutdown := func() {
+		for _, p := range providers {
+			_ = p.Shutdown()
+		}
+	}
+	for _, spec := range specs {
+		u, err := url.Parse(spec.URL)
+		if err != nil {
+			shutdown()
+			return nil, err
+		}
+		// the path selects the database, everything else identifies the cluster
+		cluster := *u
+		cluster.Path = ""
+		p, ok := providers[cluster.String()]
+		if !ok {
+			switch u.Scheme {
+			case "mongodb", "mongodb+srv":
+				l.Info("Connecting routed mongodb", zap.String("url", utility.RedactURL(cluster.String())))
+				client, err := mongo.NewMongoClient(options.Client().ApplyURI(cluster.String()))
+				if err != nil {
+					shutdown()
+					return nil, err
+				}
+				p = mongo.NewProvider(client, l)
+			case "mock":
+				p = mock.NewMockDriverProvider(l)
+			default:
+				shutdown()
+				return nil, nerrors.ErrInvalidNosqlURL
+			}
+			providers[cluster.String()] = p
+		}
+		rs = append(rs, router.Route{
+			Prefix:   spec.Prefix,
+			Provider: p,
+			Database: spec.Database(),
+		})
+	}
+	if rp, err := router.NewProvider(l, fallback, rs...); err != nil {
+		shutdown()
+		return nil, err
+	} else {
+		return rp, nil
+	}
+}
+
 // CreateDocumentStore creates a new DocumentStoreResult.
 func CreateDocumentStore(
 	lc fx.Lifecycle,
@@ -63,7 +157,36 @@ func CreateDocumentStore(
 	connect string,
 ) (DocumentStoreResult, error) {
 	var dOut DocumentStoreResult
-	err := dOut.init(lc, l, mClient, connect)
+	err := dOut.init(lc, l, mClient, nil, connect, "")
+	return dOut, err
+}
+
+// CreateRoutedDocumentStore creates a new DocumentStoreResult whose keys are routed
+// to other databases according to routes (see router.ParseRouteSpecs).
+func CreateRoutedDocumentStore(
+	lc fx.Lifecycle,
+	l *zap.Logger,
+	mClient *mongo2.Client,
+	connect string,
+	routes string,
+) (DocumentStoreResult, error) {
+	var dOut DocumentStoreResult
+	err := dOut.init(lc, l, mClient, nil, connect, routes)
+	return dOut, err
+}
+
+// CreateDocumentStoreFromSettings creates a new DocumentStoreResult on the store selected by
+// DatabaseURL: mongodb uses mClient, a SQL dialect uses gormDB. DatabaseRoutes relocates key
+// prefixes to other databases (see router.ParseRouteSpecs).
+func CreateDocumentStoreFromSettings(
+	lc fx.Lifecycle,
+	l *zap.Logger,
+	mClient *mongo2.Client,
+	gormDB *gorm.DB,
+	sp SettingsParams,
+) (DocumentStoreResult, error) {
+	var dOut DocumentStoreResult
+	err := dOut.init(lc, l, mClient, gormDB, sp.DatabaseURL, sp.DatabaseRoutes)
 	return dOut, err
 }
 
@@ -72,9 +195,10 @@ var DocumentStoreModule = fx.Provide(
 	func(
 		lc fx.Lifecycle,
 		l *zap.Logger,
-		mp MongoParams,
+		mp documentMongoParams,
+		gp documentGormParams,
 		sp SettingsParams,
 	) (dOut DocumentStoreResult, err error) {
-		return CreateDocumentStore(lc, l, mp.MongoClient, sp.DatabaseURL)
+		return CreateDocumentStoreFromSettings(lc, l, mp.MongoClient, gp.GormDB, sp)
 	},
 )
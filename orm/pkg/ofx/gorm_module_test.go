@@ -0,0 +1,79 @@
+package ofx
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/fx/fxtest"
+	"go.uber.org/zap"
+
+	"github.com/gstones/moke-kit/orm/nerrors"
+)
+
+func TestNewDialector(t *testing.T) {
+	for raw, name := range map[string]string{
+		"postgres://u:p@localhost:5432/game?sslmode=disable": "postgres",
+		"postgresql://localhost/game":                        "postgres",
+		"mysql://u:p@localhost:3306/game":                    "mysql",
+		"sqlite://file::memory:?cache=shared":                "sqlite",
+	} {
+		d, err := NewDialector(raw)
+		require.NoError(t, err, raw)
+		require.Equal(t, name, d.Name(), raw)
+	}
+	_, err := NewDialector("mongodb://localhost:27017")
+	require.ErrorIs(t, err, nerrors.ErrInvalidSqlURL)
+}
+
+func TestMysqlDSN(t *testing.T) {
+	u, err := url.Parse("mysql://u:p@db:3306/game?charset=utf8mb4")
+	require.NoError(t, err)
+	require.Equal(t, "u:p@tcp(db:3306)/game?charset=utf8mb4&parseTime=true", mysqlDSN(u))
+}
+
+func TestCreateGormDriverFromSettings(t *testing.T) {
+	lc := fxtest.NewLifecycle(t)
+	out, err := CreateGormDriverFromSettings(lc, zap.NewNop(), nil, SettingsParams{
+		DatabaseURL:        "sqlite://file:gorm_settings?mode=memory&cache=shared",
+		SqlMaxOpenConns:    4,
+		SqlConnMaxLifetime: time.Minute,
+	}, true)
+	require.NoError(t, err)
+	require.NotNil(t, out.GormDB)
+
+	var one int
+	require.NoError(t, out.GormDB.Raw("SELECT 1").Scan(&one).Error)
+	require.Equal(t, 1, one)
+	sqlDB, err := out.GormDB.DB()
+	require.NoError(t, err)
+	require.Equal(t, 4, sqlDB.Stats().MaxOpenConnections)
+
+	lc.RequireStart().RequireStop()
+	require.Error(t, sqlDB.Ping())
+
+	out, err = CreateGormDriverFromSettings(fxtest.NewLifecycle(t), zap.NewNop(), nil, SettingsParams{
+		DatabaseURL: "mongodb://localhost:27017",
+	}, false)
+	require.NoError(t, err)
+	require.Nil(t, out.GormDB)
+}
+
+func TestDocumentStoreWithoutMongo(t *testing.T) {
+	gormOut, err := CreateGormDriverFromSettings(fxtest.NewLifecycle(t), zap.NewNop(), nil, SettingsParams{
+		DatabaseURL: "sqlite://file:document_store?mode=memory&cache=shared",
+	}, false)
+	require.NoError(t, err)
+
+	lc := fxtest.NewLifecycle(t)
+	out, err := CreateDocumentStoreFromSettings(lc, zap.NewNop(), nil, gormOut.GormDB, SettingsParams{
+		DatabaseURL: "sqlite://file:document_store?mode=memory&cache=shared",
+	})
+	require.NoError(t, err)
+	require.NotNil(t, out.DriverProvider)
+	lc.RequireStart().RequireStop()
+
+	_, err = CreateRoutedDocumentStore(fxtest.NewLifecycle(t), zap.NewNop(), nil, "mongodb://localhost:27017", "")
+	require.ErrorIs(t, err, nerrors.ErrMissingMongoClient)
+}
@@ -0,0 +1,111 @@
+package ofx
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	mongo2 "go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+
+	"github.com/gstones/moke-kit/mq/pkg/mfx"
+	"github.com/gstones/moke-kit/orm/nerrors"
+	"github.com/gstones/moke-kit/orm/nosql/diface"
+	"github.com/gstones/moke-kit/orm/nosql/outbox"
+)
+
+type OutboxParams struct {
+	fx.In
+
+	Outbox diface.IOutbox `name:"Outbox"`
+}
+
+type OutboxResult struct {
+	fx.Out
+
+	Outbox diface.IOutbox `name:"Outbox"`
+}
+
+func (or *OutboxResult) init(
+	l *zap.Logger,
+	mClient *mongo2.Client,
+	n SettingsParams,
+) error {
+	u, err := url.Parse(n.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "mongodb", "mongodb+srv":
+		store := outbox.NewMongoStore(mClient, n.OutboxDatabase, n.OutboxCollection)
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := store.EnsureIndexes(ctx); err != nil {
+			return err
+		}
+		or.Outbox = store
+	case "mock":
+		or.Outbox = outbox.NewMockStore()
+	default:
+		l.Error("outbox needs a mongodb or mock document store", zap.String("scheme", u.Scheme))
+		return nerrors.ErrOutboxNotSupported
+	}
+	l.Info("open outbox", zap.String("scheme", u.Scheme))
+	return nil
+}
+
+// CreateOutbox creates the outbox matching the document store selected by DatabaseURL.
+func CreateOutbox(
+	l *zap.Logger,
+	mClient *mongo2.Client,
+	n SettingsParams,
+) (OutboxResult, error) {
+	var out OutboxResult
+	err := out.init(l, mClient, n)
+	return out, err
+}
+
+// OutboxModule provides the Outbox staging messages with DocumentBase.SaveWithOutbox.
+var OutboxModule = fx.Provide(
+	func(
+		l *zap.Logger,
+		mp MongoParams,
+		n SettingsParams,
+	) (OutboxResult, error) {
+		return CreateOutbox(l, mp.MongoClient, n)
+	},
+)
+
+// StartOutboxRelay runs a relay publishing the outbox to mq for the lifetime of the app.
+func StartOutboxRelay(
+	lc fx.Lifecycle,
+	l *zap.Logger,
+	ob diface.IOutbox,
+	mq mfx.MessageQueueParams,
+	n SettingsParams,
+) *outbox.Relay {
+	relay := outbox.NewRelay(ob, mq.MessageQueue, l,
+		outbox.WithInterval(n.OutboxRelayInterval),
+		outbox.WithBatchSize(n.OutboxBatchSize),
+	)
+	lc.Append(fx.Hook{
+		OnStart: relay.Start,
+		OnStop:  relay.Stop,
+	})
+	return relay
+}
+
+// OutboxRelayModule publishes staged outbox messages to the MessageQueue, it needs OutboxModule
+// and the mq module.
+var OutboxRelayModule = fx.Invoke(
+	func(
+		lc fx.Lifecycle,
+		l *zap.Logger,
+		op OutboxParams,
+		mq mfx.MessageQueueParams,
+		n SettingsParams,
+	) {
+		StartOutboxRelay(lc, l, op.Outbox, mq, n)
+	},
+)
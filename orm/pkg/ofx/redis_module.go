@@ -2,14 +2,13 @@ package ofx
 
 import (
 	"context"
-	"net/url"
+	"errors"
 
 	goredis "github.com/redis/go-redis/v9"
 
 	"go.uber.org/fx"
 	"go.uber.org/zap"
 
-	"github.com/gstones/moke-kit/orm/nerrors"
 	"github.com/gstones/moke-kit/utility"
 )
 
@@ -17,19 +16,19 @@ import (
 type RedisParams struct {
 	fx.In
 
-	//use redis db 0
-	Redis *goredis.Client `name:"Redis"`
-	//use redis db 1
-	Cache *goredis.Client `name:"Cache"`
+	//use redis db CACHE_REDIS_DB (default 0)
+	Redis goredis.UniversalClient `name:"Redis"`
+	//use redis db CACHE_CACHE_DB (default 1)
+	Cache goredis.UniversalClient `name:"Cache"`
 }
 
 // RedisResult provides the RedisResult to the mfx dependency graph.
 type RedisResult struct {
 	fx.Out
-	//use redis db 0
-	Redis *goredis.Client `name:"Redis"`
-	//use redis db 1
-	Cache *goredis.Client `name:"Cache"`
+	//use redis db CACHE_REDIS_DB (default 0)
+	Redis goredis.UniversalClient `name:"Redis"`
+	//use redis db CACHE_CACHE_DB (default 1)
+	Cache goredis.UniversalClient `name:"Cache"`
 }
 
 func (rr *RedisResult) init(
@@ -39,42 +38,43 @@ func (rr *RedisResult) init(
 ) error {
 	if n.CacheURL == "" {
 		return nil
-	} else if u, e := url.Parse(n.CacheURL); e != nil {
-		return e
-	} else if u.Scheme != "redis" {
+	}
+	topology, opts, err := ParseRedisURL(n.CacheURL)
+	if err != nil {
 		l.Error("Invalid redis url", zap.String("url", utility.RedactURL(n.CacheURL)))
-		return nerrors.ErrInvalidNosqlURL
-	} else {
-		username := u.User.Username()
-		if n.CacheUser != "" {
-			username = n.CacheUser
-		}
-		password, _ := u.User.Password()
-		if n.CachePassword != "" {
-			password = n.CachePassword
-		}
-		rr.Redis = goredis.NewClient(&goredis.Options{
-			Addr:     u.Host,
-			Username: username,
-			Password: password,
-			DB:       0,
-		})
-		rr.Cache = goredis.NewClient(&goredis.Options{
-			Addr:     u.Host,
-			Username: username,
-			Password: password,
-			DB:       1,
-		})
+		return err
+	}
+	if n.CacheUser != "" {
+		opts.Username = n.CacheUser
+	}
+	if n.CachePassword != "" {
+		opts.Password = n.CachePassword
+	}
+	if n.CachePoolSize > 0 {
+		opts.PoolSize = n.CachePoolSize
 	}
-	l.Info("Connecting redis", zap.String("url", utility.RedactURL(n.CacheURL)))
-	if rr.Redis != nil {
-		lc.Append(fx.Hook{
-			OnStop: func(ctx context.Context) error {
-				_ = rr.Redis.Close()
-				return rr.Cache.Close()
-			},
-		})
+	if n.CacheMinIdleConns > 0 {
+		opts.MinIdleConns = n.CacheMinIdleConns
 	}
+	if topology == RedisCluster && (n.CacheRedisDB != 0 || n.CacheCacheDB != 0) {
+		l.Info("redis cluster only supports db 0, Redis and Cache share it")
+	}
+
+	redisOpts, cacheOpts := *opts, *opts
+	redisOpts.DB = n.CacheRedisDB
+	cacheOpts.DB = n.CacheCacheDB
+	rr.Redis = NewRedisClient(topology, &redisOpts)
+	rr.Cache = NewRedisClient(topology, &cacheOpts)
+
+	l.Info("Connecting redis",
+		zap.String("url", utility.RedactURL(n.CacheURL)),
+		zap.String("topology", string(topology)),
+	)
+	lc.Append(fx.Hook{
+		OnStop: func(ctx context.Context) error {
+			return errors.Join(rr.Redis.Close(), rr.Cache.Close())
+		},
+	})
 	return nil
 }
 
@@ -0,0 +1,99 @@
+package ofx
+
+import (
+	"testing"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/fx/fxtest"
+	"go.uber.org/zap"
+
+	"github.com/gstones/moke-kit/orm/nerrors"
+)
+
+func TestParseRedisURL(t *testing.T) {
+	topology, opts, err := ParseRedisURL("rediss://u:p@cache:6380?pool_size=20&dial_timeout=2s")
+	require.NoError(t, err)
+	require.Equal(t, RedisStandalone, topology)
+	require.Equal(t, []string{"cache:6380"}, opts.Addrs)
+	require.Equal(t, "u", opts.Username)
+	require.Equal(t, "p", opts.Password)
+	require.Equal(t, 20, opts.PoolSize)
+	require.Equal(t, 2*time.Second, opts.DialTimeout)
+	require.NotNil(t, opts.TLSConfig)
+	require.Equal(t, "cache", opts.TLSConfig.ServerName)
+
+	topology, opts, err = ParseRedisURL("redis-sentinel://s1:26379,s2:26379/mymaster?sentinel_password=x")
+	require.NoError(t, err)
+	require.Equal(t, RedisSentinel, topology)
+	require.Equal(t, []string{"s1:26379", "s2:26379"}, opts.Addrs)
+	require.Equal(t, "mymaster", opts.MasterName)
+	require.Equal(t, "x", opts.SentinelPassword)
+	require.Nil(t, opts.TLSConfig)
+
+	_, opts, err = ParseRedisURL("redis://cache:6379/2")
+	require.NoError(t, err)
+	require.Equal(t, 2, opts.DB)
+
+	_, opts, err = ParseRedisURL("redis-sentinel://s1:26379/mymaster/3")
+	require.NoError(t, err)
+	require.Equal(t, "mymaster", opts.MasterName)
+	require.Equal(t, 3, opts.DB)
+
+	_, opts, err = ParseRedisURL("redis-sentinel://s1:26379/4?master=main")
+	require.NoError(t, err)
+	require.Equal(t, "main", opts.MasterName)
+	require.Equal(t, 4, opts.DB)
+
+	topology, opts, err = ParseRedisURL("redis-cluster://n1:6379?tls=true")
+	require.NoError(t, err)
+	require.Equal(t, RedisCluster, topology)
+	require.NotNil(t, opts.TLSConfig)
+
+	for _, raw := range []string{
+		"mongodb://localhost:27017",
+		"redis://a:6379,b:6379",
+		"redis-sentinel://s1:26379",
+		"redis://cache:6379?pool_size=many",
+		"redis://cache:6379/db",
+		"redis://cache:6379/-1",
+		"redis://cache:6379/1/2",
+		"redis-cluster://n1:6379/1",
+	} {
+		_, _, err = ParseRedisURL(raw)
+		require.ErrorIs(t, err, nerrors.ErrInvalidNosqlURL, raw)
+	}
+}
+
+func TestCreateRedis(t *testing.T) {
+	for raw, check := range map[string]func(c goredis.UniversalClient) bool{
+		"redis://localhost:6379":                 func(c goredis.UniversalClient) bool { _, ok := c.(*goredis.Client); return ok },
+		"redis-cluster://n1:6379,n2:6379":        func(c goredis.UniversalClient) bool { _, ok := c.(*goredis.ClusterClient); return ok },
+		"redis-sentinel://s1:26379/mymaster":     func(c goredis.UniversalClient) bool { _, ok := c.(*goredis.Client); return ok },
+		"rediss-sentinel://s1:26379?master=main": func(c goredis.UniversalClient) bool { _, ok := c.(*goredis.Client); return ok },
+	} {
+		lc := fxtest.NewLifecycle(t)
+		out, err := CreateRedis(lc, zap.NewNop(), SettingsParams{
+			CacheURL:      raw,
+			CachePassword: "secret",
+			CacheRedisDB:  2,
+			CacheCacheDB:  3,
+			CachePoolSize: 7,
+		})
+		require.NoError(t, err, raw)
+		require.True(t, check(out.Redis), raw)
+		require.True(t, check(out.Cache), raw)
+		if c, ok := out.Redis.(*goredis.Client); ok {
+			require.Equal(t, 2, c.Options().DB)
+			require.Equal(t, 7, c.Options().PoolSize)
+			require.Equal(t, "secret", c.Options().Password)
+			require.Equal(t, 3, out.Cache.(*goredis.Client).Options().DB)
+		}
+		lc.RequireStart().RequireStop()
+	}
+
+	out, err := CreateRedis(fxtest.NewLifecycle(t), zap.NewNop(), SettingsParams{})
+	require.NoError(t, err)
+	require.Nil(t, out.Redis)
+}
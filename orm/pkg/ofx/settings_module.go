@@ -1,6 +1,8 @@
 package ofx
 
 import (
+	"time"
+
 	"go.uber.org/fx"
 
 	"github.com/gstones/moke-kit/utility"
@@ -15,12 +17,37 @@ type SettingsParams struct {
 	DatabaseUser string `name:"DatabaseUser"`
 	// will replace  database url password
 	DatabasePassword string `name:"DatabasePassword"`
+	// DatabaseRoutes moves key prefixes to other databases: "/prod/audit=mongodb://host:27017/audit;..."
+	DatabaseRoutes string `name:"DatabaseRoutes"`
 	// CacheURL is the url of the cache(redis).
 	CacheURL string `name:"CacheURL"`
 	// will replace  cache url username
 	CacheUser string `name:"CacheUser"`
 	// will replace  cache url password
 	CachePassword string `name:"CachePassword"`
+	// db indexes of the Redis and Cache clients, ignored by redis cluster
+	CacheRedisDB int `name:"CacheRedisDB"`
+	CacheCacheDB int `name:"CacheCacheDB"`
+	// connection pool sizes, 0 keeps the url or go-redis default
+	CachePoolSize     int `name:"CachePoolSize"`
+	CacheMinIdleConns int `name:"CacheMinIdleConns"`
+	// SqlURL is the url of the sql database(postgres/mysql/sqlite) opened by the GormModule.
+	SqlURL string `name:"SqlURL"`
+	// connection pool limits, 0 keeps the database/sql default
+	SqlMaxOpenConns    int           `name:"SqlMaxOpenConns"`
+	SqlMaxIdleConns    int           `name:"SqlMaxIdleConns"`
+	SqlConnMaxLifetime time.Duration `name:"SqlConnMaxLifetime"`
+	// queries slower than this are logged as warnings
+	SqlSlowThreshold time.Duration `name:"SqlSlowThreshold"`
+	// OutboxDatabase and OutboxCollection locate the staged messages of the mongodb outbox.
+	OutboxDatabase   string `name:"OutboxDatabase"`
+	OutboxCollection string `name:"OutboxCollection"`
+	// the outbox relay polls every OutboxRelayInterval and publishes up to OutboxBatchSize messages at once
+	OutboxRelayInterval time.Duration `name:"OutboxRelayInterval"`
+	OutboxBatchSize     int           `name:"OutboxBatchSize"`
+	// write behind documents are flushed every WriteBehindInterval, or once WriteBehindMaxPending are dirty
+	WriteBehindInterval   time.Duration `name:"WriteBehindInterval"`
+	WriteBehindMaxPending int           `name:"WriteBehindMaxPending"`
 }
 
 type SettingsResult struct {
@@ -29,9 +56,29 @@ type SettingsResult struct {
 	DocumentURL      string `name:"DatabaseURL" envconfig:"DATABASE_URL" default:"mongodb://localhost:27017"`
 	DatabaseUser     string `name:"DatabaseUser" envconfig:"DATABASE_USER" default:""`
 	DatabasePassword string `name:"DatabasePassword" envconfig:"DATABASE_PASSWORD" default:""`
+	DatabaseRoutes   string `name:"DatabaseRoutes" envconfig:"DATABASE_ROUTES" default:""`
 	CacheURL         string `name:"CacheURL" envconfig:"CACHE_URL" default:"redis://localhost:6379"`
 	CacheUser        string `name:"CacheUser" envconfig:"CACHE_USER" default:""`
 	CachePassword    string `name:"CachePassword" envconfig:"CACHE_PASSWORD" default:""`
+
+	CacheRedisDB      int `name:"CacheRedisDB" envconfig:"CACHE_REDIS_DB" default:"0"`
+	CacheCacheDB      int `name:"CacheCacheDB" envconfig:"CACHE_CACHE_DB" default:"1"`
+	CachePoolSize     int `name:"CachePoolSize" envconfig:"CACHE_POOL_SIZE" default:"0"`
+	CacheMinIdleConns int `name:"CacheMinIdleConns" envconfig:"CACHE_MIN_IDLE_CONNS" default:"0"`
+
+	SqlURL             string        `name:"SqlURL" envconfig:"SQL_URL" default:""`
+	SqlMaxOpenConns    int           `name:"SqlMaxOpenConns" envconfig:"SQL_MAX_OPEN_CONNS" default:"50"`
+	SqlMaxIdleConns    int           `name:"SqlMaxIdleConns" envconfig:"SQL_MAX_IDLE_CONNS" default:"10"`
+	SqlConnMaxLifetime time.Duration `name:"SqlConnMaxLifetime" envconfig:"SQL_CONN_MAX_LIFETIME" default:"30m"`
+	SqlSlowThreshold   time.Duration `name:"SqlSlowThreshold" envconfig:"SQL_SLOW_THRESHOLD" default:"200ms"`
+
+	OutboxDatabase      string        `name:"OutboxDatabase" envconfig:"OUTBOX_DATABASE" default:"outbox"`
+	OutboxCollection    string        `name:"OutboxCollection" envconfig:"OUTBOX_COLLECTION" default:"outbox"`
+	OutboxRelayInterval time.Duration `name:"OutboxRelayInterval" envconfig:"OUTBOX_RELAY_INTERVAL" default:"1s"`
+	OutboxBatchSize     int           `name:"OutboxBatchSize" envconfig:"OUTBOX_BATCH_SIZE" default:"100"`
+
+	WriteBehindInterval   time.Duration `name:"WriteBehindInterval" envconfig:"WRITE_BEHIND_INTERVAL" default:"1s"`
+	WriteBehindMaxPending int           `name:"WriteBehindMaxPending" envconfig:"WRITE_BEHIND_MAX_PENDING" default:"1000"`
 }
 
 func (sr *SettingsResult) loadFromEnv() error {
@@ -0,0 +1,61 @@
+package ofx
+
+import (
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+
+	"github.com/gstones/moke-kit/orm/nosql"
+)
+
+type WriteBehindParams struct {
+	fx.In
+
+	WriteBehind *nosql.WriteBehind `name:"WriteBehind"`
+}
+
+type WriteBehindResult struct {
+	fx.Out
+
+	WriteBehind *nosql.WriteBehind `name:"WriteBehind"`
+}
+
+func (wr *WriteBehindResult) init(
+	lc fx.Lifecycle,
+	l *zap.Logger,
+	n SettingsParams,
+) {
+	wr.WriteBehind = nosql.NewWriteBehind(
+		nosql.WithFlushInterval(n.WriteBehindInterval),
+		nosql.WithMaxPending(n.WriteBehindMaxPending),
+		nosql.WithWriteBehindLogger(l),
+	)
+	lc.Append(fx.Hook{
+		OnStart: wr.WriteBehind.Start,
+		// flush the remaining dirty documents before the stores close
+		OnStop: wr.WriteBehind.Stop,
+	})
+}
+
+// CreateWriteBehind creates the WriteBehind flushing documents for the lifetime of the app.
+func CreateWriteBehind(
+	lc fx.Lifecycle,
+	l *zap.Logger,
+	n SettingsParams,
+) (WriteBehindResult, error) {
+	var out WriteBehindResult
+	out.init(lc, l, n)
+	return out, nil
+}
+
+// WriteBehindModule provides the WriteBehind passed to DocumentBase.EnableWriteBehind.
+// It is built after the document store, so fx stops it, flushing, before the store shuts down.
+var WriteBehindModule = fx.Provide(
+	func(
+		lc fx.Lifecycle,
+		l *zap.Logger,
+		_ DocumentStoreParams,
+		n SettingsParams,
+	) (WriteBehindResult, error) {
+		return CreateWriteBehind(lc, l, n)
+	},
+)
@@ -0,0 +1,233 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+
+	"github.com/gstones/moke-kit/orm/nerrors"
+	"github.com/gstones/moke-kit/orm/retry"
+)
+
+// Versioned is implemented by entities that carry an optimistic locking version.
+type Versioned interface {
+	GetVersion() int64
+	SetVersion(v int64)
+}
+
+// Model is a base entity with an auto increment id, a version column for optimistic locking,
+// timestamps and soft delete. Embed it in your entities.
+type Model struct {
+	ID        uint64 `gorm:"primaryKey"`
+	Version   int64  `gorm:"not null;default:1"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+}
+
+// GetVersion returns the version the entity was loaded or saved with.
+func (m *Model) GetVersion() int64 {
+	return m.Version
+}
+
+// SetVersion sets the version of the entity.
+func (m *Model) SetVersion(v int64) {
+	m.Version = v
+}
+
+// Scope narrows a query, e.g. func(db *gorm.DB) *gorm.DB { return db.Where("level > ?", 10) }.
+type Scope = func(db *gorm.DB) *gorm.DB
+
+// Page is one page of a paginated query.
+type Page[T any] struct {
+	Items  []T
+	Total  int64
+	Number int
+	Size   int
+}
+
+// Repository provides CRUD and CAS updates for entity T over a *gorm.DB.
+// PT is the pointer type of T, which must implement Versioned (embedding Model does).
+type Repository[T any, PT interface {
+	*T
+	Versioned
+}] struct {
+	db *gorm.DB
+}
+
+// NewRepository creates a Repository on db.
+func NewRepository[T any, PT interface {
+	*T
+	Versioned
+}](db *gorm.DB) *Repository[T, PT] {
+	return &Repository[T, PT]{db: db}
+}
+
+// Migrate creates or migrates the table of T.
+func (r *Repository[T, PT]) Migrate(ctx context.Context) error {
+	return r.db.WithContext(ctx).AutoMigrate(PT(new(T)))
+}
+
+// WithTx returns a copy of the repository bound to tx, for use inside Transaction.
+func (r *Repository[T, PT]) WithTx(tx *gorm.DB) *Repository[T, PT] {
+	return &Repository[T, PT]{db: tx}
+}
+
+// Unscoped returns a copy of the repository that also sees soft deleted entities.
+func (r *Repository[T, PT]) Unscoped() *Repository[T, PT] {
+	return &Repository[T, PT]{db: r.db.Unscoped()}
+}
+
+// Create inserts entity with version 1.
+func (r *Repository[T, PT]) Create(ctx context.Context, entity PT) error {
+	entity.SetVersion(1)
+	return r.db.WithContext(ctx).Create(entity).Error
+}
+
+// Get loads the entity with the given primary key.
+func (r *Repository[T, PT]) Get(ctx context.Context, id any) (PT, error) {
+	entity := PT(new(T))
+	if err := r.db.WithContext(ctx).First(entity, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nerrors.ErrNotFound
+		}
+		return nil, err
+	}
+	return entity, nil
+}
+
+// Reload refreshes entity from the database by its primary key.
+func (r *Repository[T, PT]) Reload(ctx context.Context, entity PT) error {
+	if err := r.db.WithContext(ctx).First(entity).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nerrors.ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// Save writes every field of entity if its version is still current, the SQL equivalent of
+// ICollection.Set with WithVersion. It fails with ErrVersionNotMatch otherwise.
+func (r *Repository[T, PT]) Save(ctx context.Context, entity PT) error {
+	version := entity.GetVersion()
+	entity.SetVersion(version + 1)
+	res := r.db.WithContext(ctx).
+		Model(entity).
+		Where("version = ?", version).
+		Select("*").
+		Omit("created_at").
+		Updates(entity)
+	if res.Error != nil {
+		entity.SetVersion(version)
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		entity.SetVersion(version)
+		return nerrors.ErrVersionNotMatch
+	}
+	return nil
+}
+
+// Update change the entity with the given function and CAS(compare and swap) save it to the database.
+// If the function returns false, the update will be aborted.
+// If the save fails, the entity is reloaded and the function retried as retry.Default() allows;
+// when it gives up, the returned *retry.Error holds the attempt count and the last conflict.
+func (r *Repository[T, PT]) Update(ctx context.Context, entity PT, f func() bool) error {
+	p := retry.Default()
+	start := time.Now()
+	for attempt := 0; ; attempt++ {
+		if !f() {
+			return nerrors.ErrUpdateLogicFailed
+		}
+		err := r.Save(ctx, entity)
+		if err == nil {
+			return nil
+		} else if !p.IsRetryable(err) {
+			return err
+		}
+		delay, ok := p.Next(attempt, start)
+		if !ok {
+			return retry.NewError(attempt+1, err)
+		}
+		if err := retry.Wait(ctx, delay); err != nil {
+			return err
+		}
+		if err := r.Reload(ctx, entity); err != nil {
+			return err
+		}
+	}
+}
+
+// Delete soft deletes entity. It fails with ErrNotFound if it does not exist or is already deleted.
+func (r *Repository[T, PT]) Delete(ctx context.Context, entity PT) error {
+	res := r.db.WithContext(ctx).Delete(entity)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nerrors.ErrNotFound
+	}
+	return nil
+}
+
+// Restore undoes a soft delete of entity.
+func (r *Repository[T, PT]) Restore(ctx context.Context, entity PT) error {
+	res := r.db.WithContext(ctx).Unscoped().Model(entity).Update("deleted_at", nil)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nerrors.ErrNotFound
+	}
+	return nil
+}
+
+// HardDelete permanently removes entity.
+func (r *Repository[T, PT]) HardDelete(ctx context.Context, entity PT) error {
+	res := r.db.WithContext(ctx).Unscoped().Delete(entity)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nerrors.ErrNotFound
+	}
+	return nil
+}
+
+// Find returns every entity matching the scopes.
+func (r *Repository[T, PT]) Find(ctx context.Context, scopes ...Scope) ([]T, error) {
+	var items []T
+	err := r.db.WithContext(ctx).Model(PT(new(T))).Scopes(scopes...).Find(&items).Error
+	return items, err
+}
+
+// Paginate returns page number (1 based) of size entities matching the scopes, with the total count.
+// Add an ordering scope for stable pages.
+func (r *Repository[T, PT]) Paginate(ctx context.Context, number, size int, scopes ...Scope) (Page[T], error) {
+	if number < 1 {
+		number = 1
+	}
+	if size < 1 {
+		return Page[T]{}, nerrors.ErrInvalidPageSize
+	}
+	page := Page[T]{Number: number, Size: size}
+	query := func() *gorm.DB {
+		return r.db.WithContext(ctx).Model(PT(new(T))).Scopes(scopes...)
+	}
+	if err := query().Count(&page.Total).Error; err != nil {
+		return page, err
+	}
+	if err := query().Offset((number - 1) * size).Limit(size).Find(&page.Items).Error; err != nil {
+		return page, err
+	}
+	return page, nil
+}
+
+// Transaction runs fn as a unit of work: every repository bound to tx with WithTx commits or
+// rolls back together, depending on whether fn returns an error.
+func Transaction(ctx context.Context, db *gorm.DB, fn func(tx *gorm.DB) error) error {
+	return db.WithContext(ctx).Transaction(fn)
+}
@@ -0,0 +1,200 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/gstones/moke-kit/orm/nerrors"
+	"github.com/gstones/moke-kit/orm/retry"
+)
+
+type player struct {
+	Model
+	Name  string
+	Level int
+	Gold  int64
+}
+
+func openRepo(t *testing.T) (*gorm.DB, *Repository[player, *player]) {
+	t.Helper()
+	dsn := filepath.Join(t.TempDir(), "repo.db") + "?_busy_timeout=5000"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	repo := NewRepository[player](db)
+	require.NoError(t, repo.Migrate(context.Background()))
+	return db, repo
+}
+
+func TestRepositoryCRUD(t *testing.T) {
+	ctx := context.Background()
+	_, repo := openRepo(t)
+
+	p := &player{Name: "alice", Level: 1}
+	require.NoError(t, repo.Create(ctx, p))
+	require.NotZero(t, p.ID)
+	require.Equal(t, int64(1), p.Version)
+
+	got, err := repo.Get(ctx, p.ID)
+	require.NoError(t, err)
+	require.Equal(t, "alice", got.Name)
+
+	require.NoError(t, repo.Update(ctx, got, func() bool {
+		got.Level = 2
+		return true
+	}))
+	require.Equal(t, int64(2), got.Version)
+
+	// a stale copy fails a single Save but Update reloads and reapplies
+	p.Gold = 5
+	require.ErrorIs(t, repo.Save(ctx, p), nerrors.ErrVersionNotMatch)
+	require.Equal(t, int64(1), p.Version)
+	require.NoError(t, repo.Update(ctx, p, func() bool {
+		p.Gold += 10
+		return true
+	}))
+	require.Equal(t, 2, p.Level)
+	require.Equal(t, int64(10), p.Gold)
+
+	require.ErrorIs(t, repo.Update(ctx, p, func() bool { return false }), nerrors.ErrUpdateLogicFailed)
+
+	require.NoError(t, repo.Delete(ctx, p))
+	_, err = repo.Get(ctx, p.ID)
+	require.ErrorIs(t, err, nerrors.ErrNotFound)
+	_, err = repo.Unscoped().Get(ctx, p.ID)
+	require.NoError(t, err)
+
+	require.NoError(t, repo.Restore(ctx, p))
+	_, err = repo.Get(ctx, p.ID)
+	require.NoError(t, err)
+
+	require.NoError(t, repo.HardDelete(ctx, p))
+	_, err = repo.Unscoped().Get(ctx, p.ID)
+	require.ErrorIs(t, err, nerrors.ErrNotFound)
+}
+
+func TestRepositoryConcurrentUpdates(t *testing.T) {
+	ctx := context.Background()
+	_, repo := openRepo(t)
+
+	seed := &player{Name: "guild"}
+	require.NoError(t, repo.Create(ctx, seed))
+
+	// Like TestDocumentBase_ConcurrentUpdates: workers may exceed a single
+	// Update's retries, so each keeps retrying until its increment lands.
+	const workers = 8
+	var success atomic.Int32
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for attempt := 0; attempt < 32; attempt++ {
+				p, err := repo.Get(ctx, seed.ID)
+				if err != nil {
+					continue
+				}
+				if err := repo.Update(ctx, p, func() bool {
+					p.Gold++
+					return true
+				}); err == nil {
+					success.Add(1)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	require.Equal(t, int32(workers), success.Load())
+	got, err := repo.Get(ctx, seed.ID)
+	require.NoError(t, err)
+	require.Equal(t, int64(workers), got.Gold)
+	require.Equal(t, int64(workers+1), got.Version)
+}
+
+func TestRepositoryPaginate(t *testing.T) {
+	ctx := context.Background()
+	_, repo := openRepo(t)
+	for i := 0; i < 7; i++ {
+		require.NoError(t, repo.Create(ctx, &player{Name: fmt.Sprintf("p%d", i), Level: i}))
+	}
+
+	byLevel := func(db *gorm.DB) *gorm.DB { return db.Where("level >= ?", 2).Order("level") }
+	page, err := repo.Paginate(ctx, 2, 2, byLevel)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), page.Total)
+	require.Len(t, page.Items, 2)
+	require.Equal(t, 4, page.Items[0].Level)
+	require.Equal(t, 5, page.Items[1].Level)
+
+	_, err = repo.Paginate(ctx, 1, 0)
+	require.ErrorIs(t, err, nerrors.ErrInvalidPageSize)
+
+	all, err := repo.Find(ctx)
+	require.NoError(t, err)
+	require.Len(t, all, 7)
+}
+
+func TestTransaction(t *testing.T) {
+	ctx := context.Background()
+	db, repo := openRepo(t)
+
+	err := Transaction(ctx, db, func(tx *gorm.DB) error {
+		if err := repo.WithTx(tx).Create(ctx, &player{Name: "rolled back"}); err != nil {
+			return err
+		}
+		return fmt.Errorf("abort")
+	})
+	require.Error(t, err)
+	all, err := repo.Find(ctx)
+	require.NoError(t, err)
+	require.Empty(t, all)
+
+	require.NoError(t, Transaction(ctx, db, func(tx *gorm.DB) error {
+		return repo.WithTx(tx).Create(ctx, &player{Name: "committed"})
+	}))
+	all, err = repo.Find(ctx)
+	require.NoError(t, err)
+	require.Len(t, all, 1)
+}
+
+func TestRepositoryUpdateUsesDefaultPolicy(t *testing.T) {
+	retry.SetDefault(retry.Policy{MaxAttempts: 2})
+	defer retry.SetDefault(retry.DefaultPolicy)
+
+	ctx := context.Background()
+	_, repo := openRepo(t)
+	p := &player{Name: "bob"}
+	require.NoError(t, repo.Create(ctx, p))
+
+	calls := 0
+	err := repo.Update(ctx, p, func() bool {
+		calls++
+		// another writer always gets there first
+		other, err := repo.Get(ctx, p.ID)
+		require.NoError(t, err)
+		other.Gold++
+		require.NoError(t, repo.Save(ctx, other))
+		p.Level++
+		return true
+	})
+	var re *retry.Error
+	require.ErrorAs(t, err, &re)
+	require.Equal(t, 2, re.Attempts)
+	require.Equal(t, 2, calls)
+	require.ErrorIs(t, err, nerrors.ErrTooManyRetries)
+}
@@ -0,0 +1,18 @@
+package retry
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// MaxRetries is the default number of attempts of a CAS update loop.
+const MaxRetries = 5
+
+// Backoff returns the randomized exponential delay to wait after the given failed attempt (0 based):
+// 2^attempt milliseconds plus up to the same amount again of jitter.
+func Backoff(attempt int) time.Duration {
+	backoff := time.Duration(math.Pow(2, float64(attempt))) * time.Millisecond
+	jitter := time.Duration(rand.Float64() * float64(backoff))
+	return backoff + jitter
+}
@@ -0,0 +1,146 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/gstones/moke-kit/orm/nerrors"
+)
+
+// Jitter decides how a delay is randomized.
+type Jitter int
+
+const (
+	// AddJitter waits the delay plus up to the same amount again, like Backoff.
+	AddJitter Jitter = iota
+	// NoJitter waits exactly the delay.
+	NoJitter
+	// FullJitter waits between 0 and the delay.
+	FullJitter
+	// EqualJitter waits between half the delay and the delay.
+	EqualJitter
+)
+
+// Policy tells a CAS update loop how often and how long to retry.
+type Policy struct {
+	// MaxAttempts is the number of attempts including the first one, at least 1.
+	MaxAttempts int
+	// BaseDelay is the delay after the first failed attempt, doubled after every further one.
+	BaseDelay time.Duration
+	// MaxDelay caps the delay before jitter, 0 leaves it uncapped.
+	MaxDelay time.Duration
+	Jitter   Jitter
+	// Retryable reports whether an error is worth another attempt, nil retries every error.
+	Retryable func(err error) bool
+	// Deadline bounds the total time spent retrying, 0 means no bound. No attempt is started
+	// once its delay would end past the deadline.
+	Deadline time.Duration
+}
+
+// DefaultPolicy is the policy used until SetDefault: MaxRetries attempts, delays as Backoff,
+// every error retried.
+var DefaultPolicy = Policy{
+	MaxAttempts: MaxRetries,
+	BaseDelay:   time.Millisecond,
+	Jitter:      AddJitter,
+}
+
+var defaultPolicy atomic.Pointer[Policy]
+
+// Default returns the policy used by update loops without a policy of their own.
+func Default() Policy {
+	if p := defaultPolicy.Load(); p != nil {
+		return *p
+	}
+	return DefaultPolicy
+}
+
+// SetDefault replaces the policy returned by Default.
+func SetDefault(p Policy) {
+	defaultPolicy.Store(&p)
+}
+
+// OnConflict is a Retryable predicate retrying version conflicts only.
+func OnConflict(err error) bool {
+	return errors.Is(err, nerrors.ErrVersionNotMatch)
+}
+
+// IsRetryable reports whether err is worth another attempt.
+func (p Policy) IsRetryable(err error) bool {
+	return p.Retryable == nil || p.Retryable(err)
+}
+
+// Delay returns the randomized delay to wait after the given failed attempt (0 based).
+func (p Policy) Delay(attempt int) time.Duration {
+	d := p.BaseDelay
+	for i := 0; i < attempt && d > 0 && (p.MaxDelay <= 0 || d < p.MaxDelay); i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	switch p.Jitter {
+	case NoJitter:
+		return d
+	case FullJitter:
+		return time.Duration(rand.Int63n(int64(d) + 1))
+	case EqualJitter:
+		return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+	default:
+		return d + time.Duration(rand.Float64()*float64(d))
+	}
+}
+
+// Next returns the delay before the attempt following the given failed attempt (0 based) of a
+// loop started at start, or false if the policy gives up.
+func (p Policy) Next(attempt int, start time.Time) (time.Duration, bool) {
+	if attempt+1 >= p.MaxAttempts {
+		return 0, false
+	}
+	d := p.Delay(attempt)
+	if p.Deadline > 0 && time.Since(start)+d > p.Deadline {
+		return 0, false
+	}
+	return d, true
+}
+
+// Wait sleeps for d or until ctx is done.
+func Wait(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// Error is returned when a policy gives up. It matches nerrors.ErrTooManyRetries and the last
+// error with errors.Is.
+type Error struct {
+	// Attempts is the number of attempts made.
+	Attempts int
+	// Err is the error of the last attempt, usually the final version conflict.
+	Err error
+}
+
+// NewError creates the Error of a loop that gave up after attempts with err.
+func NewError(attempts int, err error) *Error {
+	return &Error{Attempts: attempts, Err: err}
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s after %d attempts: %v", nerrors.ErrTooManyRetries, e.Attempts, e.Err)
+}
+
+func (e *Error) Unwrap() []error {
+	return []error{nerrors.ErrTooManyRetries, e.Err}
+}
@@ -0,0 +1,45 @@
+package retry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicyDelay(t *testing.T) {
+	p := Policy{MaxAttempts: 10, BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond, Jitter: NoJitter}
+	require.Equal(t, 10*time.Millisecond, p.Delay(0))
+	require.Equal(t, 40*time.Millisecond, p.Delay(2))
+	require.Equal(t, 50*time.Millisecond, p.Delay(3))
+	require.Equal(t, 50*time.Millisecond, p.Delay(60))
+
+	for attempt := 0; attempt < 8; attempt++ {
+		p.Jitter = FullJitter
+		require.LessOrEqual(t, p.Delay(attempt), 50*time.Millisecond)
+		p.Jitter = EqualJitter
+		require.GreaterOrEqual(t, p.Delay(attempt), p.BaseDelay/2)
+		p.Jitter = AddJitter
+		require.Less(t, p.Delay(attempt), 100*time.Millisecond)
+	}
+}
+
+func TestPolicyNext(t *testing.T) {
+	p := Policy{MaxAttempts: 3, BaseDelay: time.Millisecond, Jitter: NoJitter}
+	start := time.Now()
+	_, ok := p.Next(0, start)
+	require.True(t, ok)
+	_, ok = p.Next(2, start)
+	require.False(t, ok)
+
+	p.Deadline = time.Second
+	_, ok = p.Next(0, start.Add(-time.Second))
+	require.False(t, ok)
+}
+
+func TestSetDefault(t *testing.T) {
+	require.Equal(t, MaxRetries, Default().MaxAttempts)
+	SetDefault(Policy{MaxAttempts: 8})
+	defer SetDefault(DefaultPolicy)
+	require.Equal(t, 8, Default().MaxAttempts)
+}
@@ -30,13 +30,25 @@ func (m *MockSubscription) Unsubscribe() error {
 	return args.Error(0)
 }
 
+func (m *MockSubscription) Done() <-chan struct{} {
+	args := m.Called()
+	done, _ := args.Get(0).(<-chan struct{})
+	return done
+}
+
+func (m *MockSubscription) Err() error {
+	args := m.Called()
+	return args.Error(0)
+}
+
 // MockMessage is a mock implementation of Message
 type MockMessage struct {
 	mock.Mock
-	id    string
-	topic string
-	data  []byte
-	vPtr  interface{}
+	id      string
+	topic   string
+	data    []byte
+	vPtr    interface{}
+	headers map[string]string
 }
 
 func (m *MockMessage) ID() string {
@@ -71,6 +83,18 @@ func (m *MockMessage) VPtr() interface{} {
 	return args.Get(0)
 }
 
+func (m *MockMessage) Headers() map[string]string {
+	return m.headers
+}
+
+func (m *MockMessage) Timestamp() time.Time {
+	return time.Time{}
+}
+
+func (m *MockMessage) Context() context.Context {
+	return context.Background()
+}
+
 // MockMessageQueue is a mock implementation of MessageQueue
 type MockMessageQueue struct {
 	mock.Mock
@@ -95,6 +119,17 @@ func (m *MockMessageQueue) Subscribe(ctx context.Context, topic string, handler
 	return args.Get(0).(miface.Subscription), args.Error(1)
 }
 
+func (m *MockMessageQueue) Request(ctx context.Context, topic string, opts ...miface.PubOption) (miface.Message, error) {
+	args := m.Called(ctx, topic, opts)
+	msg, _ := args.Get(0).(miface.Message)
+	return msg, args.Error(1)
+}
+
+func (m *MockMessageQueue) Respond(ctx context.Context, topic string, handler miface.RequestHandler, opts ...miface.SubOption) (miface.Subscription, error) {
+	args := m.Called(ctx, topic, handler, opts)
+	return args.Get(0).(miface.Subscription), args.Error(1)
+}
+
 func (m *MockMessageQueue) Publish(topic string, opts ...miface.PubOption) error {
 	args := m.Called(topic, opts)
 	
@@ -106,9 +141,10 @@ func (m *MockMessageQueue) Publish(topic string, opts ...miface.PubOption) error
 	if len(handlers) > 0 {
 		options, _ := miface.NewPubOptions(opts...)
 		msg := &MockMessage{
-			id:    "test-message-id",
-			topic: topic,
-			data:  options.Data,
+			id:      "test-message-id",
+			topic:   topic,
+			data:    options.Data,
+			headers: options.Headers,
 		}
 		
 		for _, handler := range handlers {